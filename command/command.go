@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +16,11 @@ import (
 	"github.com/pericles-tpt/seye/records"
 	"github.com/pericles-tpt/seye/stats"
 	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+var (
+	validWalkAlgorithms = []string{"dir", "file", "recursive"}
 )
 
 func Help() {
@@ -21,6 +28,20 @@ func Help() {
 Parameters for the commands above:
 	scan [PATH]: Runs a manual scan of a directory (storing the resulting tree in a file)
 		'-c=false'     : forces either a "comprehensive" (true) or "shallow" (false) scan
+		'--symlinks=skip' : lets you choose how symlinks are handled, one of: skip (ignore them),
+		                 record (store each link and its target), follow (walk linked directories)
+		'--one-file-system' : don't descend into directories on other filesystems (pseudo-filesystems,
+		                 e.g. proc and sysfs, are always skipped)
+		'--count-links' : count the size of each hardlink, rather than each inode once
+		'--max-read-rate=50MB/s' : limit the rate files are read at while hashing, shared by all threads
+		'--max-iops=1000' : limit the number of 'stat's and 'readdir's per second
+		'--idle'       : run at idle I/O priority (linux only) and the lowest CPU priority
+		'--no-cache-friendly' : hash files normally, rather than without updating their access time and
+		                 dropping them from the page cache afterwards (linux only)
+		'--chunk-threshold=1GiB' : hash files at least this large in chunks on multiple threads (0 to disable)
+		'-w=dir'       : walks the tree in memory on multiple threads with the chosen walk algorithm,
+		                 one of: dir, file, recursive. By default the tree is written to disk as it's
+		                 walked (on one thread), so the whole tree is only in memory to 'diff' it
 		'-s'           : forces a "shallow" scan
 		'-n=2'         : lets you specify number of processing threads to run
 		'-label=setup' : lets you assign a label for the scan (can't be a whole number)
@@ -34,115 +55,254 @@ Parameters for the commands above:
 	report: Reports on the data from the LAST records. Additional args are
 		'-l=10'        : get the n largest files
 		'-d=10'        : get the n largest duplicates
-
-	diff [PATH]: Gets the difference of two prior scans (currently only supports "diff"ing the first and last scan)
+		'-b'           : list broken symlinks
+		'-m'           : break down usage by mount point
+		'--by=user'    : break down usage by owning user or group, one of: user, group
+		'--errors'     : list the paths that couldn't be read, grouped by the cause
+		'--from-scan'  : report on PATH from the last scan that contains it, rather than walking it
+		                 (can't be used with '-d')
+		'-S=10'        : get the n most sparse files (largest apparent size - allocated size)
+		'--disk-usage' : report sizes as allocated disk usage, rather than '--apparent-size' (default)
+		'--symlinks=skip' : same as for 'scan'
+		'--one-file-system' : same as for 'scan'
+		'--count-links' : same as for 'scan'
+		'--max-read-rate=50MB/s', '--max-iops=1000', '--idle', '--no-cache-friendly',
+		'--chunk-threshold=1GiB' : same as for 'scan'
+
+	diff [PATH]: Gets the difference of two prior scans (currently only supports "diff"ing the first and last scan),
+		PATH can be a scanned directory or any directory in it
+		'--disk-usage' : same as for 'report'
+		'--json'       : prints the differences as JSON
+		'--ignore=metadataModified' : ignores differences of these types, a comma separated list of:
+		                 contentModified, metadataModified (e.g. touched files), renamed, removed, added, copied
 
 	* NOTE: Can only report on duplicates if the last two scans are BOTH comprehensive
 
+	migrate: Rewrites the scan files ('.tree' and '.diff') written by older versions of seye (e.g.
+		as 'gob's, or without a header) in the current format, and moves the scans of directories
+		that weren't recorded by their canonical path to it. 'convert' is an alias of 'migrate'
+
+	browse [PATH]: Lists the subdirectories and files of PATH, largest first, from the last scan that
+		contains it
+		'--disk-usage' : same as for 'report'
+
+	history [PATH]: Lists the scan files kept for each directory (or only PATH), with their sizes
+		on disk and uncompressed. Scan files are compressed at the 'compressionLevel' in
+		'config.json', from 1 (fastest) to 9 (smallest), or 0 to write them uncompressed
+
+	relocate [OLD] [NEW]: Moves the scans of directory OLD to NEW, so its history continues there (e.g.
+		after moving the directory, or changing where it's mounted)
+
+	* NOTE: Directories are recorded by their canonical path (absolute, with symlinks resolved), so
+		e.g. './data' and '/home/me/data' share a history. Run 'migrate' to move the scans of
+		directories recorded by older versions of seye to their canonical paths ('scan' moves those of
+		the directory it scans)
+
+	fsck: Checks the scan files in the scans output directory against their records, for files that
+		are missing, unreadable or not referenced by any record, and checks the totals of each tree
+		and that replaying each directory's diffs onto its first scan reproduces its last
+		'--repair'     : repairs the problems found where possible, i.e. removes unreferenced files,
+		                 drops records of missing files (unreadable files are kept, renamed with a
+		                 '.corrupt' suffix), corrects 'currScanNum' and rebuilds tree indexes
+
+	* NOTE: Commands that write scans (scan, migrate, relocate and fsck) lock the scans
+		output directory, so only one can run at a time and not alongside commands that read scans.
+		They fail if it's locked, unless they're run with '--wait' ('--no-wait' is the default)
+
 	help: Prints this help text
 `)
 }
 
-func Scan(args []string, runPreviously bool) error {
-	// First execution setup, ask for output directory for tree scans
-	if !runPreviously {
-		fmt.Println("Detected first execution of `Fiye`")
-		newOutput, err := promptNewOutputDir()
+/*
+First execution setup, asks for the output directory for tree scans and creates it. Run before the
+scans are locked, since the lock is in the output directory
+*/
+func SetupOutputDir() error {
+	fmt.Println("Detected first execution of `Fiye`")
+	newOutput, err := promptNewOutputDir()
+	if err != nil {
+		return err
+	}
+	fmt.Println("New output is: ", newOutput)
+	config.SetScansOutputDir(newOutput)
+
+	outDir := config.GetScansOutputDir()
+	_, err = os.Stat(outDir)
+	if os.IsNotExist(err) {
+		err = os.Mkdir(outDir, 0770)
 		if err != nil {
-			return err
+			return errorx.Decorate(err, "failed to create directory '%s' for adding FileTree scans", outDir)
 		}
-		fmt.Println("New output is: ", newOutput)
-		config.SetScansOutputDir(newOutput)
+	} else {
+		return errorx.Decorate(err, "unexpected error when accessing scan directory '%s'", outDir)
+	}
 
-		outDir := config.GetScansOutputDir()
-		_, err = os.Stat(outDir)
-		if os.IsNotExist(err) {
-			err = os.Mkdir(outDir, 0770)
-			if err != nil {
-				return errorx.Decorate(err, "failed to create directory '%s' for adding FileTree scans", outDir)
-			}
-		} else {
-			return errorx.Decorate(err, "unexpected error when accessing scan directory '%s'", outDir)
-		}
+	config.SetRunPreviously(true)
+	return nil
+}
 
-		config.SetRunPreviously(true)
+func Scan(args []string) error {
+	// Check provided directory is readable, scans of it are recorded by its canonical path so it
+	// has one history however it's referred to
+	targetDir, err := utility.CanonicalPath(args[0])
+	if err != nil {
+		return errorx.Decorate(err, "failed to resolve directory '%s'", args[0])
+	}
+	_, err = os.ReadDir(targetDir)
+	if err != nil {
+		return err
 	}
 
-	// Check provided directory is readable
-	targetDir := args[0]
-	_, err := os.ReadDir(targetDir)
+	// Scans of it recorded by another path (e.g. a relative path, by older versions of seye) are moved
+	// to its canonical path, so its history continues
+	moved, conflicts, err := records.CanonicaliseRoot(targetDir)
+	if moved != "" {
+		fmt.Printf("Moved the scans of '%s' to its canonical path '%s'\n", moved, targetDir)
+	}
 	if err != nil {
 		return err
 	}
+	for _, rootPath := range conflicts {
+		fmt.Printf("WARNING: The scans of '%s' weren't moved to its canonical path '%s', since it already has scans, run `seye relocate` to move them elsewhere\n", rootPath, targetDir)
+	}
 
 	// Should set "Comprehensive" ON when: it's the first scan for a dir OR requested by user
 	previousFullScans := records.GetScansFull(targetDir)
 	// isComprehensive := (previousFullScans == nil || len((*previousFullScans).Records) == 0)
-	isComprehensive := false
-	if len(args) > 1 {
-		if args[1] == "-c" {
+	var (
+		isComprehensive = false
+		walkAlgorithm   = ""
+	)
+	for _, v := range args[1:] {
+		if v == "-c" {
 			isComprehensive = true
+		} else if ok, err := parseWalkArg(v); ok {
+			if err != nil {
+				return err
+			}
+		} else if strings.HasPrefix(v, "-w=") {
+			walkAlgorithm = strings.TrimPrefix(v, "-w=")
+			if !utility.Contains(validWalkAlgorithms, walkAlgorithm) {
+				return fmt.Errorf("invalid walk algorithm '%s' provided, must be one of: %s", walkAlgorithm, strings.Join(validWalkAlgorithms, ","))
+			}
 		} else {
-			return errors.New("invalid third argument provided, must be '-c' (fi provided)")
+			return fmt.Errorf("invalid argument '%s' provided, must be one of '-c', '-w=ALGORITHM', '--symlinks=POLICY', '--one-file-system', '--count-links', '--max-read-rate=RATE', '--max-iops=N', '--idle', '--no-cache-friendly' or '--chunk-threshold=SIZE'", v)
 		}
 	}
 
-	// Walk the tree, write the scan to `ScansRecord` and disk
+	// A "recursive" walk uses the last scan to decide where to split the walk between threads
+	var (
+		lastTree       tree.FileTree
+		lastTreeErr    error
+		lastTreeLoaded = false
+		hasLastScan    = previousFullScans != nil && len((*previousFullScans).Records) > 0
+	)
+	if hasLastScan && walkAlgorithm == "recursive" {
+		lastTree, lastTreeErr = tree.ReadBinary(config.GetScansOutputDir() + records.GetLastScanFilename(targetDir, false))
+		lastTreeLoaded = true
+	}
+
+	// Walk the tree, writing it to disk as it's walked unless a walk algorithm was chosen (which walks
+	// it in memory)
 	fmt.Printf("Started traversing tree '%s'... ", targetDir)
 	timer := time.Now()
-	newTree := tree.WalkTreeIterativeFile(targetDir, 0, isComprehensive, nil)
+	var (
+		newTree     *tree.FileTree
+		stagedPath  = ""
+		numUnstable = 0
+	)
+	if walkAlgorithm == "" {
+		stagedPath = records.StagedTreePath(targetDir)
+		root, n, err := tree.WalkWriteTree(targetDir, isComprehensive, nil, stagedPath)
+		if err != nil {
+			removeStagedTree(stagedPath)
+			return errorx.Decorate(err, "failed to walk and write tree '%s'", targetDir)
+		}
+		newTree, numUnstable = &root, n
+	} else if lastTreeLoaded && lastTreeErr == nil {
+		newTree = walkTree(targetDir, isComprehensive, nil, walkAlgorithm, &lastTree)
+		numUnstable = newTree.CountUnstableFiles()
+	} else {
+		newTree = walkTree(targetDir, isComprehensive, nil, walkAlgorithm, nil)
+		numUnstable = newTree.CountUnstableFiles()
+	}
 	fmt.Printf("Took %d ms to traverse the tree\n", time.Since(timer).Milliseconds())
+	if numUnstable > 0 {
+		fmt.Printf("WARNING: %d files changed while being hashed, they're marked as unstable and excluded from duplicates\n", numUnstable)
+	}
 
 	// Diff this scan with the previous full scan (if one exists)
-	if previousFullScans != nil && len((*previousFullScans).Records) > 0 {
+	var (
+		tDiff               *diff.ScanDiff
+		isDiffComprehensive = false
+	)
+	if hasLastScan {
 		lastScanTime := ((*previousFullScans).Records)[len((*previousFullScans).Records)-1].TimeCompleted
 		fmt.Printf("Detected an existing full scan, performed at: %s, running 'diff'...\n", lastScanTime.String())
 
-		// 1. Read previous scan into memory
-		lastTree, err := tree.ReadBinary(config.GetScansOutputDir() + records.GetLastScanFilename(newTree.BasePath, false))
-		if err != nil {
+		// 1. Read previous scan into memory (if it wasn't already read for the walk)
+		if !lastTreeLoaded {
+			lastTree, lastTreeErr = tree.ReadBinary(config.GetScansOutputDir() + records.GetLastScanFilename(newTree.BasePath, false))
+		}
+		if lastTreeErr != nil {
 			fmt.Println("WARNING: Failed to read last local scan for 'diff'ing, may be corrupt or inaccessible")
 		} else {
-			// 2. Diff with new scan
+			// 2. Diff with new scan, which needs the whole of it, so a tree written as it was walked is
+			// read back
+			if stagedPath != "" {
+				written, err := tree.ReadBinary(stagedPath)
+				if err != nil {
+					removeStagedTree(stagedPath)
+					return errorx.Decorate(err, "failed to read back tree '%s' for 'diff'ing", targetDir)
+				}
+				newTree = &written
+			}
 			timer = time.Now()
-			tDiff := diff.CompareTrees(&lastTree, newTree)
+			sDiff := diff.CompareTrees(&lastTree, newTree)
+			tDiff = &sDiff
+			isDiffComprehensive = lastTree.Comprehensive && newTree.Comprehensive
 			fmt.Printf("Took %d ms to run diff comparing this tree with the last one\n", time.Since(timer).Milliseconds())
-
-			// 3. Add new diff record (writing to disk in the process)
-			err = records.AddDiffScanRecord(lastTree.BasePath, lastTree.Comprehensive && newTree.Comprehensive, tDiff)
-			if err != nil {
-				// TODO: Do something with error here
-				records.RevertDiffScanRecord(lastTree.BasePath, lastTree.Comprehensive && newTree.Comprehensive, tDiff)
-				fmt.Println("WARNING: Failed to record new diff to disk")
-			}
 		}
 	}
+
+	// 3. Record the diff and new scan (writing them to disk in the process, if they aren't already)
 	fmt.Println("Writing tree data to disk...")
-	err = records.AddFullScanRecord(*newTree)
+	if stagedPath != "" {
+		err = records.RecordStagedScan(newTree, stagedPath, tDiff, isDiffComprehensive)
+	} else {
+		err = records.RecordScan(newTree, tDiff, isDiffComprehensive)
+	}
 	if err != nil {
-		// TODO: Do something with error here
-		records.RevertFullScanRecord(*newTree)
 		return errorx.Decorate(err, "failed to add scan information to record and/or local file")
 	}
 
 	return nil
 }
 
-func Report(args []string, runPreviously bool) error {
-	// Check dir is readable
-	targetDir := args[0]
-	_, err := os.ReadDir(targetDir)
-	if err != nil {
-		return err
-	}
+/*
+Removes a tree written for `records.RecordStagedScan` that won't be recorded, and its index
+*/
+func removeStagedTree(stagedPath string) {
+	os.Remove(stagedPath)
+	os.Remove(tree.IndexPath(stagedPath))
+}
 
+func Report(args []string, runPreviously bool) error {
 	// Always does COMPREHENSIVE atm
 	// TODO: Change this so we can read existing diffs to get data
+	var err error
 	var (
-		ws                     = stats.WalkStats{}
-		isComprehensive        = false
-		reportLargest    int64 = -1
-		reportDuplicates int64 = -1
+		targetDir               = args[0]
+		fromScan                = false
+		ws                      = stats.WalkStats{}
+		isComprehensive         = false
+		reportLargest     int64 = -1
+		reportDuplicates  int64 = -1
+		reportBrokenLinks       = false
+		reportMounts            = false
+		reportSparse      int64 = -1
+		reportBy                = ""
+		reportErrors            = false
 	)
 	for _, v := range args {
 		if strings.HasPrefix(v, "-l=") {
@@ -163,15 +323,70 @@ func Report(args []string, runPreviously bool) error {
 			fmt.Println("NOTE: Duplicate finding requested, performing a 'Comprehensive' scan, this may take a while")
 			fileMap := make(map[string][]stats.BasicFile, reportDuplicates)
 			ws.DuplicateMap = &fileMap
+		} else if v == "-b" {
+			reportBrokenLinks = true
+		} else if v == "-m" {
+			reportMounts = true
+		} else if v == "--errors" {
+			reportErrors = true
+		} else if v == "--from-scan" {
+			fromScan = true
+		} else if strings.HasPrefix(v, "-S=") {
+			reportSparse, err = strconv.ParseInt(strings.TrimPrefix(v, "-S="), 10, 64)
+			if err != nil {
+				return err
+			}
+		} else if strings.HasPrefix(v, "--by=") {
+			reportBy = strings.TrimPrefix(v, "--by=")
+			if reportBy != "user" && reportBy != "group" {
+				return fmt.Errorf("invalid argument '%s' provided, must be one of '--by=user' or '--by=group'", v)
+			}
+		} else if parseSizeModeArg(v) {
+		} else if ok, err := parseWalkArg(v); ok {
+			if err != nil {
+				return err
+			}
 		}
 	}
+	if reportBrokenLinks && tree.GetSymlinkPolicy() == tree.SymlinksSkip {
+		fmt.Println("NOTE: Broken links requested, recording symlinks during the scan")
+		tree.SetSymlinkPolicy(tree.SymlinksRecord)
+	}
 
-	// Walk the tree, write the scan to `ScansRecord` and disk
-	// TODO: Write the resultant `newTree` to disk, and perform diffs if possible
-	fmt.Printf("Started traversing tree '%s'...\n", targetDir)
-	timer := time.Now()
-	newTree := tree.WalkTreeIterativeFile(targetDir, 0, isComprehensive, &ws)
-	fmt.Printf(" Took %d ms to traverse the tree", time.Since(timer).Milliseconds())
+	var newTree *tree.FileTree
+	if fromScan {
+		if ws.DuplicateMap != nil {
+			return errors.New("'-d' can't be used with '--from-scan', duplicates are only found while walking")
+		}
+		targetDir, err = utility.CanonicalPath(targetDir)
+		if err != nil {
+			return errorx.Decorate(err, "failed to resolve directory '%s'", args[0])
+		}
+		rootPath, ok := findScanRoot(targetDir)
+		if !ok {
+			return fmt.Errorf("no scans exist that contain directory '%s'", targetDir)
+		}
+		st, err := readScanSubtree(rootPath, targetDir, records.GetLastScanFilename(rootPath, false), -1)
+		if err != nil {
+			return err
+		}
+		newTree = &st
+		addLargestFiles(&ws, newTree)
+		fmt.Printf("'%s': %d bytes in %d files, from the last scan of '%s'\n", newTree.BasePath, newTree.ReportedSizeBelow(), newTree.NumFilesBelow, rootPath)
+	} else {
+		// Check dir is readable
+		_, err = os.ReadDir(targetDir)
+		if err != nil {
+			return err
+		}
+
+		// Walk the tree, write the scan to `ScansRecord` and disk
+		// TODO: Write the resultant `newTree` to disk, and perform diffs if possible
+		fmt.Printf("Started traversing tree '%s'...\n", targetDir)
+		timer := time.Now()
+		newTree = walkTree(targetDir, isComprehensive, &ws, "", nil)
+		fmt.Printf(" Took %d ms to traverse the tree", time.Since(timer).Milliseconds())
+	}
 
 	fmt.Printf("REPORT GENERATED FOR TREE WITH ROOT '%s'\n", newTree.BasePath)
 	if ws.LargestFiles != nil {
@@ -207,6 +422,47 @@ func Report(args []string, runPreviously bool) error {
 		}
 	}
 
+	if reportSparse > 0 {
+		fmt.Printf("\n## The %d most sparse files are: ##\n", reportSparse)
+		for _, v := range newTree.GetMostSparseFiles(int(reportSparse)) {
+			fmt.Printf("'%s': %d bytes apparent, %d bytes allocated\n", v.Name, v.Size, v.Allocated)
+		}
+	}
+
+	if reportBy != "" {
+		fmt.Printf("\n## Usage by %s: ##\n", reportBy)
+		for _, v := range newTree.GetUsageByOwner(reportBy == "group") {
+			fmt.Printf("'%s' (%d): %d bytes in %d files\n", v.Name, v.Id, v.Size, v.NumFiles)
+		}
+	}
+
+	if reportMounts {
+		fmt.Printf("\n## Usage by mount point: ##\n")
+		for _, v := range newTree.GetMountUsage() {
+			fmt.Printf("'%s' (%s): %d bytes in %d files\n", v.MountPoint, v.FsType, v.Size, v.NumFiles)
+		}
+	}
+
+	if reportBrokenLinks {
+		brokenLinks := newTree.GetBrokenLinks()
+		fmt.Printf("\n## Found %d broken links: ##\n", len(brokenLinks))
+		for _, v := range brokenLinks {
+			fmt.Printf("'%s' -> '%s'\n", v.Name, v.LinkTarget)
+		}
+	}
+
+	if reportErrors {
+		walkErrors := newTree.GetErrors()
+		fmt.Printf("\n## Found %d inaccessible paths: ##\n", len(walkErrors))
+		for i, v := range walkErrors {
+			// `GetErrors` is sorted by class then op, so print a heading for each group
+			if i == 0 || v.Class != walkErrors[i-1].Class || v.Op != walkErrors[i-1].Op {
+				fmt.Printf("%s (%s):\n", v.Class, v.Op)
+			}
+			fmt.Printf("\t'%s': %s\n", v.Path, v.Message)
+		}
+	}
+
 	return nil
 }
 
@@ -217,37 +473,487 @@ func Diff(args []string) error {
 		return errors.New("no diffs available")
 	}
 
-	targetDir := args[0]
-	if _, ok := (*scans)[targetDir]; !ok {
+	var (
+		targetDir   = args[0]
+		outputJSON  = false
+		ignoreTypes = []diff.DiffType{}
+	)
+	for _, v := range args[1:] {
+		if v == "--json" {
+			outputJSON = true
+		} else if strings.HasPrefix(v, "--ignore=") {
+			ignoreTypes, err = diff.ParseDiffTypes(strings.TrimPrefix(v, "--ignore="))
+			if err != nil {
+				return err
+			}
+		} else if !parseSizeModeArg(v) {
+			return fmt.Errorf("invalid argument '%s' provided, must be one of '--apparent-size', '--disk-usage', '--json' or '--ignore=TYPES'", v)
+		}
+	}
+	targetDir, err = utility.CanonicalPath(targetDir)
+	if err != nil {
+		return errorx.Decorate(err, "failed to resolve directory '%s'", args[0])
+	}
+	rootPath, ok := findScanRoot(targetDir)
+	if !ok {
 		return errors.New("cannot perform diff, no prior scans exist to diff")
 	}
 
-	diffScans := (*scans)[targetDir]
+	diffScans := (*scans)[rootPath]
 	if len(diffScans.Records) < 2 {
-		return fmt.Errorf("cannot get difference between scans for directory '%s', not enough scan to perform diff, have: %d, need: 2", targetDir, len(diffScans.Records))
+		return fmt.Errorf("cannot get difference between scans for directory '%s', not enough scan to perform diff, have: %d, need: 2", rootPath, len(diffScans.Records))
 
 	}
 
 	// Take the difference of the first and last scans
 	// TODO: Allow this to take the difference of ANY two prior scans
-	first, err := tree.ReadBinary(config.GetScansOutputDir() + records.GetScanFilename(targetDir, 0, false))
+	var (
+		firstFilename = records.GetScanFilename(rootPath, 0, false)
+		lastFilename  = records.GetLastScanFilename(rootPath, false)
+		first, last   *tree.FileTree
+	)
+	if rootPath == targetDir {
+		firstTree, err := tree.ReadBinary(config.GetScansOutputDir() + firstFilename)
+		if err != nil {
+			return err
+		}
+		lastTree, err := tree.ReadBinary(config.GetScansOutputDir() + lastFilename)
+		if err != nil {
+			return err
+		}
+		first, last = &firstTree, &lastTree
+	} else {
+		// Only read the sub-path from each scan, it's nil in a scan if it didn't exist then
+		firstTree, err := readScanSubtree(rootPath, targetDir, firstFilename, -1)
+		if err == nil {
+			first = &firstTree
+		} else if !errors.Is(err, tree.ErrNotInTree) {
+			return err
+		}
+		lastTree, err := readScanSubtree(rootPath, targetDir, lastFilename, -1)
+		if err == nil {
+			last = &lastTree
+		} else if !errors.Is(err, tree.ErrNotInTree) {
+			return err
+		}
+		if first == nil && last == nil {
+			return fmt.Errorf("directory '%s' isn't in the first or last scan of '%s'", targetDir, rootPath)
+		}
+	}
+
+	// Finally print the largest 10 differences
+	// TODO: Allow this parameter to be user specified in the future
+	sdiff := diff.CompareTrees(first, last)
+	sdiff = sdiff.WithoutTypes(ignoreTypes)
+	if outputJSON {
+		return diff.WriteJSON(os.Stdout, sdiff)
+	}
+	diff.PrintLargestDiffs(10, sdiff)
+	diff.PrintCopies(10, sdiff)
+	diff.PrintRenames(sdiff)
+	diff.PrintLinkTargetChanges(sdiff)
+	diff.PrintMetadataChanges(sdiff)
+	diff.PrintErrorChanges(sdiff)
+
+	return nil
+}
+
+/*
+Rewrites the scan files of every root in the records, written by older versions of seye, in the
+current format (i.e. with a header, see `utility.FileHeader`). Roots recorded by older versions of
+seye as they were provided, rather than as canonical paths, are moved to their canonical paths first
+*/
+func Migrate() error {
+	moved, conflicts, err := records.CanonicaliseRoots()
+	movedRoots := []string{}
+	for k := range moved {
+		movedRoots = append(movedRoots, k)
+	}
+	sort.Strings(movedRoots)
+	for _, oldRoot := range movedRoots {
+		fmt.Printf("Moved the scans of '%s' to '%s'\n", oldRoot, moved[oldRoot])
+	}
 	if err != nil {
 		return err
 	}
+	for _, rootPath := range conflicts {
+		fmt.Printf("WARNING: The scans of '%s' weren't moved to its canonical path, since it already has scans, run `seye relocate` to move them elsewhere\n", rootPath)
+	}
 
-	last, err := tree.ReadBinary(config.GetScansOutputDir() + records.GetLastScanFilename(targetDir, false))
+	var (
+		scans       = records.GetAllScansFull()
+		diffs       = records.GetAllScansDiff()
+		roots       = []string{}
+		numMigrated = 0
+	)
+	for k := range *scans {
+		roots = append(roots, k)
+	}
+	sort.Strings(roots)
+
+	for _, rootPath := range roots {
+		for i := range (*scans)[rootPath].Records {
+			filename := records.GetFullScanRecordFilename(rootPath, i)
+			migrated, err := tree.MigrateTree(config.GetScansOutputDir() + filename)
+			if err != nil {
+				return errorx.Decorate(err, "failed to migrate '%s'", filename)
+			} else if migrated {
+				fmt.Printf("Migrated '%s'\n", filename)
+				numMigrated++
+			}
+		}
+
+		for i, r := range (*diffs)[rootPath].Records {
+			filename := records.GetScanFilename(rootPath, i, true)
+			migrated, err := diff.MigrateDiff(config.GetScansOutputDir()+filename, rootPath, r.TimeCompleted, r.IsComprehensive)
+			if err != nil {
+				return errorx.Decorate(err, "failed to migrate '%s'", filename)
+			} else if migrated {
+				fmt.Printf("Migrated '%s'\n", filename)
+				numMigrated++
+			}
+		}
+	}
+	fmt.Printf("Migrated %d files\n", numMigrated)
+
+	return nil
+}
+
+/*
+Moves the scans of a directory, OLD, to NEW so its history continues there, e.g. after the directory
+is moved
+*/
+func Relocate(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("'relocate' expects 2 arguments (OLD NEW), got: %d", len(args))
+	}
+
+	// OLD may have been recorded before roots were canonical, or no longer exist
+	oldRoot := args[0]
+	_, hasScan := (*records.GetAllScansFull())[oldRoot]
+	_, hasDiff := (*records.GetAllScansDiff())[oldRoot]
+	if !hasScan && !hasDiff {
+		canonical, err := utility.CanonicalPath(oldRoot)
+		if err != nil {
+			return errorx.Decorate(err, "failed to resolve directory '%s'", oldRoot)
+		}
+		oldRoot = canonical
+	}
+	newRoot, err := utility.CanonicalPath(args[1])
 	if err != nil {
+		return errorx.Decorate(err, "failed to resolve directory '%s'", args[1])
+	}
+
+	if err = records.Relocate(oldRoot, newRoot); err != nil {
 		return err
 	}
+	fmt.Printf("Moved the scans of '%s' to '%s'\n", oldRoot, newRoot)
+	return nil
+}
 
-	// Finally print the largest 10 differences
-	// TODO: Allow this parameter to be user specified in the future
-	sdiff := diff.CompareTrees(&first, &last)
-	diff.PrintLargestDiffs(10, sdiff)
+/*
+Checks the scan files in the scans output directory, and their records, for problems. With
+'--repair' the problems that can be are repaired
+*/
+func Fsck(args []string) error {
+	repair := false
+	for _, v := range args {
+		if v == "--repair" {
+			repair = true
+		} else {
+			return fmt.Errorf("invalid argument '%s' provided, must be '--repair'", v)
+		}
+	}
+
+	problems, err := records.Fsck(repair)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+		return nil
+	}
+
+	numRepairable := 0
+	for _, p := range problems {
+		fmt.Printf("'%s': %s\n", p.Name, p.Problem)
+		if p.Repair == "" {
+			continue
+		}
+		numRepairable++
+		if repair {
+			fmt.Printf("\trepaired: %s\n", p.Repair)
+		} else {
+			fmt.Printf("\trepair: %s\n", p.Repair)
+		}
+	}
+
+	if !repair {
+		if numRepairable > 0 {
+			fmt.Printf("Run 'seye fsck --repair' to repair %d of them\n", numRepairable)
+		}
+		return fmt.Errorf("found %d problems", len(problems))
+	}
+	fmt.Printf("Repaired %d problems\n", numRepairable)
+	if numRemaining := len(problems) - numRepairable; numRemaining > 0 {
+		return fmt.Errorf("found %d problems that can't be repaired", numRemaining)
+	}
+	return nil
+}
+
+/*
+Lists the contents of a directory in the last scan that contains it, with the size of each of its
+subdirectories and files
+*/
+func Browse(args []string) error {
+	if len(args) < 1 {
+		return errors.New("you must provide a PATH to browse")
+	}
+	targetDir, err := utility.CanonicalPath(args[0])
+	if err != nil {
+		return errorx.Decorate(err, "failed to resolve directory '%s'", args[0])
+	}
+	for _, v := range args[1:] {
+		if !parseSizeModeArg(v) {
+			return fmt.Errorf("invalid argument '%s' provided, must be one of '--apparent-size' or '--disk-usage'", v)
+		}
+	}
+
+	rootPath, ok := findScanRoot(targetDir)
+	if !ok {
+		return fmt.Errorf("no scans exist that contain directory '%s'", targetDir)
+	}
+	t, err := readScanSubtree(rootPath, targetDir, records.GetLastScanFilename(rootPath, false), 1)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("'%s': %d bytes in %d files, last modified %s\n", t.BasePath, t.ReportedSizeBelow(), t.NumFilesBelow, t.LastModifiedBelow.Format(time.DateTime))
+	sort.SliceStable(t.SubTrees, func(i, j int) bool {
+		return t.SubTrees[i].ReportedSizeBelow() > t.SubTrees[j].ReportedSizeBelow()
+	})
+	for _, st := range t.SubTrees {
+		fmt.Printf("\t%12d  %s/ (%d files)\n", st.ReportedSizeBelow(), filepath.Base(st.BasePath), st.NumFilesBelow)
+	}
+	sort.SliceStable(t.Files, func(i, j int) bool {
+		return t.Files[i].ReportedSize() > t.Files[j].ReportedSize()
+	})
+	for _, f := range t.Files {
+		fmt.Printf("\t%12d  %s\n", f.ReportedSize(), filepath.Base(f.Name))
+	}
 
 	return nil
 }
 
+/*
+Lists the scan files recorded for each root (or only `args[0]`, if provided), with their
+compressed and uncompressed sizes
+*/
+func History(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments provided to 'history', expected at most 1 (PATH), got: %d", len(args))
+	}
+
+	var (
+		scans = records.GetAllScansFull()
+		diffs = records.GetAllScansDiff()
+		roots = []string{}
+	)
+	if len(args) == 1 {
+		rootPath, err := utility.CanonicalPath(args[0])
+		if err != nil {
+			return errorx.Decorate(err, "failed to resolve directory '%s'", args[0])
+		} else if _, ok := (*scans)[rootPath]; !ok {
+			return fmt.Errorf("no scans exist for directory '%s'", rootPath)
+		}
+		roots = append(roots, rootPath)
+	} else {
+		for k := range *scans {
+			roots = append(roots, k)
+		}
+		sort.Strings(roots)
+	}
+
+	var totalCompressed, totalUncompressed int64
+	printScanFile := func(filename string, r records.Record) error {
+		path := config.GetScansOutputDir() + filename
+		st, err := os.Stat(path)
+		if err != nil {
+			return errorx.Decorate(err, "failed to stat scan file '%s'", path)
+		}
+		uncompressed, err := utility.GetUncompressedSize(path)
+		if err != nil {
+			return errorx.Decorate(err, "failed to read scan file '%s'", path)
+		}
+		totalCompressed += st.Size()
+		totalUncompressed += uncompressed
+
+		scanType := "shallow"
+		if r.IsComprehensive {
+			scanType = "comprehensive"
+		}
+		fmt.Printf("\t%s %-13s '%s': %d bytes (%d bytes uncompressed)\n", r.TimeCompleted.Format(time.DateTime), scanType, filename, st.Size(), uncompressed)
+		return nil
+	}
+
+	for _, rootPath := range roots {
+		fmt.Printf("'%s':\n", rootPath)
+		for i, r := range (*scans)[rootPath].Records {
+			if err := printScanFile(records.GetFullScanRecordFilename(rootPath, i), r); err != nil {
+				return err
+			}
+		}
+		for i, r := range (*diffs)[rootPath].Records {
+			if err := printScanFile(records.GetScanFilename(rootPath, i, true), r); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Printf("Total: %d bytes (%d bytes uncompressed)\n", totalCompressed, totalUncompressed)
+
+	return nil
+}
+
+/*
+Gets the root of the scans that contain `p`, i.e. the longest scanned path that's `p` or one of
+its parent directories
+*/
+func findScanRoot(p string) (string, bool) {
+	var (
+		rootPath = ""
+		found    = false
+	)
+	for k := range *records.GetAllScansFull() {
+		prefix := strings.TrimSuffix(k, "/") + "/"
+		if (k == p || strings.HasPrefix(p, prefix)) && len(k) >= len(rootPath) {
+			rootPath, found = k, true
+		}
+	}
+	return rootPath, found
+}
+
+/*
+Reads the directory `p` from the scan of `rootPath` in `filename`, down to `maxDepth` levels below it
+(all of them if negative), without reading the rest of the scan (see `tree.OpenIndexed`)
+*/
+func readScanSubtree(rootPath, p, filename string, maxDepth int) (tree.FileTree, error) {
+	it, err := tree.OpenIndexed(config.GetScansOutputDir() + filename)
+	if err != nil {
+		return tree.FileTree{}, errorx.Decorate(err, "failed to open the scan of '%s'", rootPath)
+	}
+	return it.SubtreeToDepth(p, maxDepth)
+}
+
+/*
+Adds the files in `t` to the largest files of `ws`, as if they were found while walking
+*/
+func addLargestFiles(ws *stats.WalkStats, t *tree.FileTree) {
+	for _, f := range t.Files {
+		ws.UpdateLargestFiles(stats.BasicFile{Path: f.Name, Size: f.ReportedSize()})
+	}
+	for i := range t.SubTrees {
+		addLargestFiles(ws, &t.SubTrees[i])
+	}
+}
+
+/*
+Sets the `tree.SizeMode` used by reports and diffs, if `arg` is one of the size
+flags. Returns false otherwise
+*/
+func parseSizeModeArg(arg string) bool {
+	switch arg {
+	case "--apparent-size":
+		tree.SetSizeMode(tree.SizeApparent)
+	case "--disk-usage":
+		tree.SetSizeMode(tree.SizeDiskUsage)
+	default:
+		return false
+	}
+	return true
+}
+
+/*
+Sets the options of the walk shared by `scan` and `report`, if `arg` is one of their flags. Returns
+false otherwise
+*/
+func parseWalkArg(arg string) (bool, error) {
+	if arg == "--one-file-system" {
+		tree.SetOneFileSystem(true)
+	} else if arg == "--count-links" {
+		tree.SetCountLinks(true)
+	} else if arg == "--no-cache-friendly" {
+		tree.SetCacheFriendly(false)
+	} else if strings.HasPrefix(arg, "--chunk-threshold=") {
+		threshold, err := utility.ParseByteSize(strings.TrimPrefix(arg, "--chunk-threshold="))
+		if err != nil {
+			return true, err
+		}
+		tree.SetChunkedHashing(threshold, 0)
+	} else if strings.HasPrefix(arg, "--symlinks=") {
+		policy, err := tree.ParseSymlinkPolicy(strings.TrimPrefix(arg, "--symlinks="))
+		if err != nil {
+			return true, err
+		}
+		tree.SetSymlinkPolicy(policy)
+	} else {
+		return parseThrottleArg(arg)
+	}
+	return true, nil
+}
+
+/*
+Sets the throttling of the walk, if `arg` is one of the throttling flags. Returns false otherwise
+*/
+func parseThrottleArg(arg string) (bool, error) {
+	if strings.HasPrefix(arg, "--max-read-rate=") {
+		rate, err := utility.ParseByteRate(strings.TrimPrefix(arg, "--max-read-rate="))
+		if err != nil {
+			return true, err
+		}
+		tree.SetMaxReadRate(rate)
+	} else if strings.HasPrefix(arg, "--max-iops=") {
+		iops, err := strconv.ParseInt(strings.TrimPrefix(arg, "--max-iops="), 10, 64)
+		if err != nil {
+			return true, errorx.Decorate(err, "invalid value for '--max-iops'")
+		} else if iops < 0 {
+			return true, fmt.Errorf("invalid value for '--max-iops', must be 0 (unlimited) or more, got %d", iops)
+		}
+		tree.SetMaxIOPS(iops)
+	} else if arg == "--idle" {
+		return true, tree.SetIdlePriority()
+	} else {
+		return false, nil
+	}
+	return true, nil
+}
+
+/*
+Walks the tree at `targetDir` with the requested walk algorithm, or the fastest one for the type of scan if
+`walkAlgorithm` is empty. For a "recursive" walk, the `previous` scan of the tree (if there is one) is used to
+decide where to split the walk between threads
+*/
+func walkTree(targetDir string, isComprehensive bool, walkStats *stats.WalkStats, walkAlgorithm string, previous *tree.FileTree) *tree.FileTree {
+	if walkAlgorithm == "" {
+		walkAlgorithm = "dir"
+		if isComprehensive {
+			walkAlgorithm = "file"
+		}
+	}
+
+	switch walkAlgorithm {
+	case "recursive":
+		splitPoints := tree.GetTopLevelSplitPoints(targetDir)
+		if previous != nil {
+			splitPoints = tree.GetSplitPoints(previous, tree.GetNumThreads(isComprehensive), isComprehensive)
+		}
+		return tree.WalkTreeRecursiveSplit(targetDir, isComprehensive, walkStats, splitPoints)
+	case "file":
+		return tree.WalkTreeIterativeFile(targetDir, 0, isComprehensive, walkStats)
+	default:
+		return tree.WalkTreeIterativeDir(targetDir, isComprehensive, walkStats)
+	}
+}
+
 func promptNewOutputDir() (string, error) {
 	var (
 		newOutputDir   string
@@ -17,3 +17,13 @@ func SetScansOutputDir(newVal string) {
 	cfg.ScansOutputDir = newVal
 	cfg.Flush()
 }
+
+/*
+Gets the `gzip` level to write scan files at, returns false if it isn't set
+*/
+func GetCompressionLevel() (int, bool) {
+	if cfg.CompressionLevel == nil {
+		return 0, false
+	}
+	return *cfg.CompressionLevel, true
+}
@@ -2,9 +2,11 @@ package config
 
 import (
 	"encoding/json"
+	"io"
 	"os"
 
 	"github.com/joomcode/errorx"
+	"github.com/pericles-tpt/seye/utility"
 )
 
 var (
@@ -13,24 +15,16 @@ var (
 )
 
 func Load() error {
-	f, err := os.Open(configPath)
+	b, err := os.ReadFile(configPath)
 	if os.IsNotExist(err) {
-		f, err = os.Create(configPath)
-		if err != nil {
-			return errorx.Decorate(err, "unable to create file `%s`, after not detected", configPath)
-		}
+		cfg = &Config{}
+		return nil
 	} else if err != nil {
 		return errorx.Decorate(err, "unable to open file `%s`", configPath)
 	}
 
-	st, err := f.Stat()
-	if err != nil {
-		return errorx.Decorate(err, "unable to stat file `%s`", configPath)
-	}
-
-	if st.Size() > 0 {
-		jd := json.NewDecoder(f)
-		return jd.Decode(&cfg)
+	if len(b) > 0 {
+		return json.Unmarshal(b, &cfg)
 	} else {
 		cfg = &Config{}
 	}
@@ -38,12 +32,16 @@ func Load() error {
 	return nil
 }
 
+/*
+Writes the config atomically, see `utility.WriteFileAtomic`
+*/
 func (s *Config) Flush() error {
-	f, err := os.OpenFile(configPath, os.O_TRUNC|os.O_WRONLY, 0600)
+	err := utility.WriteFileAtomic(configPath, 0600, func(w io.Writer) error {
+		je := json.NewEncoder(w)
+		return je.Encode(*cfg)
+	})
 	if err != nil {
-		return errorx.Decorate(err, "unable to open file '%s' for 'flush'", configPath)
+		return errorx.Decorate(err, "unable to write file '%s' for 'flush'", configPath)
 	}
-
-	je := json.NewEncoder(f)
-	return je.Encode(*cfg)
+	return nil
 }
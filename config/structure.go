@@ -1,6 +1,7 @@
 package config
 
 type Config struct {
-	ScansOutputDir string `json:"scansOutputDir"`
-	RunPreviously  bool   `json:"runPreviously"`
+	ScansOutputDir   string `json:"scansOutputDir"`
+	RunPreviously    bool   `json:"runPreviously"`
+	CompressionLevel *int   `json:"compressionLevel,omitempty"` // `gzip` level of scan files, nil is the default
 }
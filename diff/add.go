@@ -27,16 +27,37 @@ func WalkAddTreeDiff(t *tree.FileTree, d *ScanDiff, newTreeAllHash *[]byte, adde
 		}
 
 		for _, f := range d.Files {
-			if f.Type == added {
+			if f.Type == added || f.Type == copied {
 				addedFiles = append(addedFiles, f)
 			}
 		}
 	}
 
-	// Check if we can add any NEW trees or files, to the current tree `t`
+	// Check if there are any `TreeDiff`s that apply to the current tree `t`, before it's looked for as
+	// the parent of NEW trees or files, since it may have been renamed
+	diff, ok := d.Trees[t.BasePath]
+	removeTree := false
+	if ok {
+		oldPath := t.BasePath
+		removeTree = addDiffToTree(t, &diff)
+		if diff.Type == renamed {
+			// Nothing below a renamed tree is diffed, so its paths are renamed with it
+			renameBelow(t, oldPath)
+		}
+		d.Trees[oldPath] = TreeDiff{}
+	}
+	// If we need to remove the tree, signal the previous level of recursion
+	if removeTree {
+		return true
+	}
+
+	// Check if we can add any NEW trees or files, to the current tree `t`. A NEW tree is walked with
+	// the rest of the subtrees of `t` below, which adds the NEW trees and files inside it
 	for _, at := range addedTrees {
 		if t.BasePath == path.Dir(at.NewerPath) {
-			addDiffToTree(t, &at)
+			nt := tree.FileTree{}
+			addDiffToTree(&nt, &at)
+			t.SubTrees = addFileTreeInAlphaOrder(t.SubTrees, nt)
 			d.Trees[at.NewerPath] = TreeDiff{}
 		}
 	}
@@ -53,26 +74,22 @@ func WalkAddTreeDiff(t *tree.FileTree, d *ScanDiff, newTreeAllHash *[]byte, adde
 		}
 	}
 
-	// Check if there are any `TreeDiff`s that apply to the current tree `t`
-	diff, ok := d.Trees[t.BasePath]
-	removeTree := false
-	if ok {
-		removeTree = addDiffToTree(t, &diff)
-		d.Trees[t.BasePath] = TreeDiff{}
-	}
-	// If we need to remove the tree, signal the previous level of recursion
-	if removeTree {
-		return true
-	}
-
 	// Go through this tree `t`'s `File`s, apply any diffs, assign the modified files
 	filesAfterAddingDiff := []tree.File{}
 	for _, f := range t.Files {
 		removeFile := false
+		// The diffs of NEW files, and those already applied, are left empty
 		fDiff, ok := d.Files[f.Name]
-		if ok {
-			removeFile, _ = addDiffToFile(&f, &fDiff, &d.AllHash, newTreeAllHash)
+		if ok && !fDiff.Empty() {
 			d.Files[f.Name] = FileDiff{}
+			if fDiff.Type != removed && path.Dir(fDiff.NewerName) != path.Dir(f.Name) {
+				// Moved to another directory (and maybe changed), it's added there once the whole tree is walked
+				addDiffToFile(&f, &fDiff, &d.AllHash, newTreeAllHash)
+				f.Name = fDiff.NewerName
+				d.movedFiles = append(d.movedFiles, f)
+				continue
+			}
+			removeFile, _ = addDiffToFile(&f, &fDiff, &d.AllHash, newTreeAllHash)
 		}
 
 		if !removeFile {
@@ -84,6 +101,8 @@ func WalkAddTreeDiff(t *tree.FileTree, d *ScanDiff, newTreeAllHash *[]byte, adde
 	// Go through this tree `t`'s Subtrees, apply any diffs, assign the modified trees
 	t.SizeDirect = 0
 	t.SizeBelow = 0
+	t.AllocatedDirect = 0
+	t.AllocatedBelow = 0
 	t.LastModifiedDirect = time.Time{}
 	t.LastModifiedBelow = time.Time{}
 	t.NumFilesDirect = int64(len(t.Files))
@@ -92,25 +111,81 @@ func WalkAddTreeDiff(t *tree.FileTree, d *ScanDiff, newTreeAllHash *[]byte, adde
 		t.LastModifiedDirect = utility.GetNewestTime(t.LastModifiedDirect, f.LastModified)
 		t.LastModifiedBelow = utility.GetNewestTime(t.LastModifiedBelow, f.LastModified)
 		t.SizeDirect += f.Size
+		t.AllocatedDirect += f.Allocated
 	}
 	t.SizeBelow = t.SizeDirect
+	t.AllocatedBelow = t.AllocatedDirect
 
 	newSubTrees := []tree.FileTree{}
 	for _, st := range t.SubTrees {
 		removeTree = false
 		removeTree = WalkAddTreeDiff(&st, d, newTreeAllHash, addedTrees, addedFiles)
-		if !removeTree {
-			newSubTrees = addFileTreeInAlphaOrder(newSubTrees, st)
+		if removeTree {
+			continue
 		}
+		newSubTrees = addFileTreeInAlphaOrder(newSubTrees, st)
 		t.LastModifiedBelow = utility.GetNewestTime(t.LastModifiedBelow, st.LastModifiedBelow)
 		t.NumFilesBelow += st.NumFilesBelow
 		t.SizeBelow += st.SizeBelow
+		t.AllocatedBelow += st.AllocatedBelow
 	}
 	t.SubTrees = newSubTrees
 
+	if t.Depth == 0 {
+		for _, mf := range d.movedFiles {
+			insertMovedFile(t, mf)
+		}
+		d.movedFiles = nil
+	}
+
 	return false
 }
 
+/*
+Renames the paths of the files and subtrees below `t`, from below `oldPath` to below its `BasePath`
+*/
+func renameBelow(t *tree.FileTree, oldPath string) {
+	for i := range t.Files {
+		t.Files[i].Name = t.BasePath + strings.TrimPrefix(t.Files[i].Name, oldPath)
+	}
+	for i := range t.SubTrees {
+		stOldPath := t.SubTrees[i].BasePath
+		t.SubTrees[i].BasePath = t.BasePath + strings.TrimPrefix(stOldPath, oldPath)
+		renameBelow(&t.SubTrees[i], stOldPath)
+	}
+}
+
+/*
+Adds a file, moved from another directory, to the tree for its directory and
+updates the size (and allocated size) of that tree and those above it
+*/
+func insertMovedFile(t *tree.FileTree, f tree.File) bool {
+	if t.BasePath == path.Dir(f.Name) {
+		t.Files = addFileInAlphaOrder(t.Files, f)
+		t.SizeDirect += f.Size
+		t.AllocatedDirect += f.Allocated
+		t.NumFilesDirect++
+		t.LastModifiedDirect = utility.GetNewestTime(t.LastModifiedDirect, f.LastModified)
+	} else {
+		found := false
+		for i := range t.SubTrees {
+			if strings.HasPrefix(f.Name, t.SubTrees[i].BasePath+"/") && insertMovedFile(&t.SubTrees[i], f) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	t.SizeBelow += f.Size
+	t.AllocatedBelow += f.Allocated
+	t.NumFilesBelow++
+	t.LastModifiedBelow = utility.GetNewestTime(t.LastModifiedBelow, f.LastModified)
+	return true
+}
+
 /*
 Add a `TreeDiff` to a `FileTree`
 */
@@ -124,13 +199,20 @@ func addDiffToTree(t *tree.FileTree, d *TreeDiff) bool {
 		t.BasePath = d.NewerPath
 	case removed:
 		return true
-	case modified:
+	case metadataModified:
+		fallthrough
+	case contentModified:
 		fallthrough
 	case added:
 		t.Comprehensive = d.Comprehensive
 		t.BasePath = d.NewerPath
 		t.Depth += d.DepthDiff
-		t.ErrStrings = append(t.ErrStrings, d.ErrStringsDiff...)
+		t.Dev += uint64(d.DevDiff)
+		t.Uid = d.NewerUid
+		t.Gid = d.NewerGid
+		t.Mode = d.NewerMode
+		t.Errors, _ = tree.DiffWalkErrors(d.ResolvedErrors, t.Errors)
+		t.Errors = append(t.Errors, d.NewErrors...)
 		if t.LastVisited.Equal(time.Time{}) {
 			t.LastVisited = utility.GoSpecialTime.Add(d.LastVisitedDiff)
 		} else {
@@ -142,6 +224,7 @@ func addDiffToTree(t *tree.FileTree, d *TreeDiff) bool {
 			t.LastModifiedDirect = t.LastModifiedDirect.Add(d.LastVisitedDiff)
 		}
 		t.SizeDirect += d.SizeDiffDirect
+		t.AllocatedDirect += d.AllocatedDiffDirect
 		t.NumFilesDirect += d.NumFilesTotalDiffDirect
 		t.AllHashOffset = d.AllHashOffset
 		t.TimeTaken += d.TimeTakenDiff
@@ -168,17 +251,40 @@ func addDiffToFile(f *tree.File, d *FileDiff, diffAllHash, newTreeAllHash *[]byt
 		f.Name = d.NewerName
 	case removed:
 		return true, false
-	case modified:
+	case metadataModified:
+		// The contents, and so the hash, are unchanged
+		f.Name = d.NewerName
+		f.WalkErr = d.NewerWalkErr
+		f.LastModified = f.LastModified.Add(d.LastModifiedDiff)
+		f.Allocated += d.AllocatedDiff
+		f.Uid = d.NewerUid
+		f.Gid = d.NewerGid
+		f.Mode = d.NewerMode
+		f.Dev = d.NewerDev
+		f.Ino = d.NewerIno
+		f.Nlink = d.NewerNlink
+	case contentModified:
+		fallthrough
+	case copied:
 		fallthrough
 	case added:
 		f.Name = d.NewerName
-		f.Err = d.NewerErr
+		f.WalkErr = d.NewerWalkErr
+		f.LinkTarget = d.NewerLinkTarget
+		f.LinkBroken = d.NewerLinkBroken
+		f.Dev = d.NewerDev
+		f.Ino = d.NewerIno
+		f.Nlink = d.NewerNlink
+		f.Uid = d.NewerUid
+		f.Gid = d.NewerGid
+		f.Mode = d.NewerMode
 		if f.LastModified.Equal(time.Time{}) {
 			f.LastModified = utility.GoSpecialTime.Add(d.LastModifiedDiff)
 		} else {
 			f.LastModified = f.LastModified.Add(d.LastModifiedDiff)
 		}
 		f.Size += d.SizeDiff
+		f.Allocated += d.AllocatedDiff
 		f.Hash = utility.InitialiseHashLocation()
 		if d.HashDiff.HashOffset > -1 {
 			f.Hash = utility.CopyHashToNewArray(d.HashDiff, diffAllHash, newTreeAllHash)
@@ -22,8 +22,17 @@ func CompareTrees(a, b *tree.FileTree) ScanDiff {
 	} else if b == nil {
 		_, ret = diffTrees([]tree.FileTree{*a}, []tree.FileTree{}, &a.AllHash, &([]byte{}), nil, false, &ret)
 	} else {
+		if (*a).Comprehensive && (*b).Comprehensive {
+			ret.oldHashIndex = buildHashIndex(a)
+		}
+		ret.chunkSizesDiffer = a.HashChunkSize != b.HashChunkSize
 		_, ret = diffTrees([]tree.FileTree{*a}, []tree.FileTree{*b}, &a.AllHash, &b.AllHash, nil, (*a).Comprehensive && (*b).Comprehensive, &ret)
+		detectMoves(&ret)
+		ret.NewErrors, ret.ResolvedErrors = tree.GetErrorChanges(a, b)
 	}
 
+	ret.oldHashIndex = nil
+	ret.chunkSizesDiffer = false
+
 	return ret
 }
@@ -0,0 +1,60 @@
+package diff
+
+import (
+	"sort"
+
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+type hashKey struct {
+	Type utility.HashType
+	Hash string
+}
+
+/*
+Indexes the paths of every hashed file in `t` by their hash, so new files can be
+matched to existing files with the same contents
+*/
+func buildHashIndex(t *tree.FileTree) map[hashKey][]string {
+	index := map[hashKey][]string{}
+	var addFiles func(st *tree.FileTree)
+	addFiles = func(st *tree.FileTree) {
+		for _, f := range st.Files {
+			if f.Hash.HashOffset < 0 || f.Hash.HashOffset+f.Hash.HashLength > len(t.AllHash) {
+				continue
+			}
+			k := hashKey{Type: f.Hash.Type, Hash: string(t.AllHash[f.Hash.HashOffset : f.Hash.HashOffset+f.Hash.HashLength])}
+			index[k] = append(index[k], f.Name)
+		}
+		for i := range st.SubTrees {
+			addFiles(&st.SubTrees[i])
+		}
+	}
+	addFiles(t)
+
+	for _, paths := range index {
+		sort.Strings(paths)
+	}
+	return index
+}
+
+/*
+Gets the paths of the files in the older tree with the same contents as a file
+with the hash at `hl`
+*/
+func (s *ScanDiff) getCopySources(hl utility.HashLocation, allHashes *[]byte) []string {
+	if s.oldHashIndex == nil || hl.HashOffset < 0 {
+		return nil
+	}
+	return s.oldHashIndex[hashKey{Type: hl.Type, Hash: string((*allHashes)[hl.HashOffset : hl.HashOffset+hl.HashLength])}]
+}
+
+/*
+Checks the hashes at `a` and `b` were hashed the same way, so comparing them tells if the files have
+the same contents. A file's hash type changes when the chunked hashing threshold does, and chunked
+hashes depend on the chunk size, see `tree.SetChunkedHashing`
+*/
+func (s *ScanDiff) hashesComparable(a, b utility.HashLocation) bool {
+	return a.Type == b.Type && (a.Type != utility.SHA256Chunked || !s.chunkSizesDiffer)
+}
@@ -8,6 +8,14 @@ import (
 	"github.com/pericles-tpt/seye/utility"
 )
 
+/*
+Checks the inode and link count of two files are the same, i.e. a change to its hardlinks (or a file
+replaced by another with the same contents) is a metadata change, since `File.Equal` compares them
+*/
+func linksSame(a, b tree.File) bool {
+	return a.Dev == b.Dev && a.Ino == b.Ino && a.Nlink == b.Nlink
+}
+
 /*
 Find and returns differences (renamed, removed, added or changed) between two File arrays
 */
@@ -24,6 +32,9 @@ func diffFiles(a, b []tree.File, allHashesA, allHashesB, allHashDiff *[]byte, sD
 			fileUnchanged = -1
 			fileRenamed   = -1
 			fileChanged   = -1
+			metadataOnly  = false
+
+			renameConfidence float64
 		)
 
 		// 1a. Compare THIS file in `a`, to each file in `b`, try to find one it matches with for the conditions listed in `var`
@@ -32,31 +43,59 @@ func diffFiles(a, b []tree.File, allHashesA, allHashesB, allHashDiff *[]byte, sD
 				nameSame        = fa.Name == fb.Name
 				modSame         = time.Time.Equal(fa.LastModified, fb.LastModified)
 				sizeSame        = fa.Size == fb.Size
-				isComprehensive = fa.Hash.HashOffset > -1 && fb.Hash.HashOffset > -1
+				allocSame       = fa.Allocated == fb.Allocated
+				linkSame        = fa.LinkTarget == fb.LinkTarget && fa.LinkBroken == fb.LinkBroken
+				metaSame        = fa.Uid == fb.Uid && fa.Gid == fb.Gid && fa.Mode == fb.Mode && linksSame(fa, fb)
+				isComprehensive = fa.Hash.HashOffset > -1 && fb.Hash.HashOffset > -1 && sDiff.hashesComparable(fa.Hash, fb.Hash)
+
+				// When both inodes are known, they tell us for certain if it's the same file
+				inodesKnown = fa.Ino != 0 && fb.Ino != 0
+				inodeSame   = inodesKnown && fa.Dev == fb.Dev && fa.Ino == fb.Ino
 			)
 
 			if isComprehensive {
 				hashesSame := utility.HashesEqual(fa.Hash, fb.Hash, allHashesA, allHashesB)
 				if hashesSame {
 					if !nameSame {
-						fileRenamed = j
-					} else {
+						confidence := renameConfidenceHash
+						if inodeSame {
+							confidence = renameConfidenceInode
+						}
+						if confidence > renameConfidence {
+							fileRenamed = j
+							renameConfidence = confidence
+						}
+					} else if linkSame && metaSame && modSame && allocSame {
 						fileUnchanged = j
 						break
+					} else {
+						fileChanged = j
+						metadataOnly = linkSame
 					}
 				} else if nameSame {
 					fileChanged = j
 				}
 			} else {
 				if nameSame {
-					if modSame && sizeSame {
+					if modSame && sizeSame && allocSame && linkSame && metaSame {
 						fileUnchanged = j
 						break
 					} else {
 						fileChanged = j
+						metadataOnly = sizeSame && linkSame
+					}
+				} else if !nameSame && modSame && sizeSame && linkSame {
+					confidence := renameConfidenceHeuristic
+					if inodeSame {
+						confidence = renameConfidenceInode
+					} else if inodesKnown {
+						// A different file that happens to have the same size and mtime
+						continue
+					}
+					if confidence > renameConfidence {
+						fileRenamed = j
+						renameConfidence = confidence
 					}
-				} else if !nameSame && modSame && sizeSame {
-					fileRenamed = j
 				}
 			}
 		}
@@ -67,8 +106,9 @@ func diffFiles(a, b []tree.File, allHashesA, allHashesB, allHashDiff *[]byte, sD
 			continue
 		} else if fileRenamed >= 0 {
 			sDiff.Files[fa.Name] = FileDiff{
-				NewerName: b[fileRenamed].Name,
-				Type:      renamed,
+				NewerName:        b[fileRenamed].Name,
+				Type:             renamed,
+				RenameConfidence: renameConfidence,
 			}
 
 			differentFiles = append(differentFiles, sDiff.Files[fa.Name])
@@ -77,19 +117,37 @@ func diffFiles(a, b []tree.File, allHashesA, allHashesB, allHashDiff *[]byte, sD
 		} else if fileChanged >= 0 {
 			newer := b[fileChanged]
 			older := fa
-			lastAllHashByte := len(*allHashDiff) - 1
+			hashOffset := len(*allHashDiff)
+
+			diffType := contentModified
+			if metadataOnly {
+				diffType = metadataModified
+			}
 
 			fDiff := FileDiff{
-				Type:             modified,
-				NewerName:        newer.Name,
-				SizeDiff:         newer.Size - older.Size,
-				NewerErr:         newer.Err,
-				LastModifiedDiff: newer.LastModified.Sub(older.LastModified),
-				HashDiff:         utility.InitialiseHashLocation(),
+				Type:              diffType,
+				NewerName:         newer.Name,
+				SizeDiff:          newer.Size - older.Size,
+				AllocatedDiff:     newer.Allocated - older.Allocated,
+				NewerWalkErr:      newer.WalkErr,
+				LastModifiedDiff:  newer.LastModified.Sub(older.LastModified),
+				HashDiff:          utility.InitialiseHashLocation(),
+				NewerLinkTarget:   newer.LinkTarget,
+				NewerLinkBroken:   newer.LinkBroken,
+				LinkTargetChanged: newer.LinkTarget != older.LinkTarget,
+				NewerDev:          newer.Dev,
+				NewerIno:          newer.Ino,
+				NewerNlink:        newer.Nlink,
+				NewerUid:          newer.Uid,
+				NewerGid:          newer.Gid,
+				NewerMode:         newer.Mode,
+				OwnerChanged:      newer.Uid != older.Uid || newer.Gid != older.Gid,
+				ModeChanged:       newer.Mode != older.Mode,
 			}
 
-			if newer.Hash.HashOffset > -1 {
-				fDiff.HashDiff = utility.HashLocation{Type: newer.Hash.Type, HashOffset: lastAllHashByte, HashLength: newer.Hash.HashLength}
+			// The hash is unchanged when only the metadata changed
+			if newer.Hash.HashOffset > -1 && !metadataOnly {
+				fDiff.HashDiff = utility.HashLocation{Type: newer.Hash.Type, HashOffset: hashOffset, HashLength: newer.Hash.HashLength}
 				*allHashDiff = append(*allHashDiff, (*allHashesB)[newer.Hash.HashOffset:newer.Hash.HashOffset+newer.Hash.HashLength]...)
 			}
 
@@ -102,9 +160,12 @@ func diffFiles(a, b []tree.File, allHashesA, allHashesB, allHashDiff *[]byte, sD
 				NewerName:        fa.Name,
 				Type:             removed,
 				SizeDiff:         -fa.Size,
-				NewerErr:         fa.Err,
+				AllocatedDiff:    -fa.Allocated,
+				NewerWalkErr:     fa.WalkErr,
 				HashDiff:         utility.InitialiseHashLocation(),
 				LastModifiedDiff: utility.GoSpecialTime.Sub(fa.LastModified),
+				NewerDev:         fa.Dev,
+				NewerIno:         fa.Ino,
 			}
 			differentFiles = append(differentFiles, sDiff.Files[fa.Name])
 		}
@@ -115,22 +176,36 @@ func diffFiles(a, b []tree.File, allHashesA, allHashesB, allHashDiff *[]byte, sD
 
 	// 2. Loop through `b` again, to find files in `b` but not in `a`, i.e. ADDED files
 	for i, fb := range b {
-		lastAllHashByte := len(*allHashDiff) - 1
+		hashOffset := len(*allHashDiff)
 		_, ok := changesFoundB[i]
 		// File NOT recorded in `changesFoundB` -> it's an ADDED file
 		if !ok {
 			fDiff := FileDiff{
 				Type:             added,
 				NewerName:        fb.Name,
-				NewerErr:         fb.Err,
+				NewerWalkErr:     fb.WalkErr,
 				SizeDiff:         fb.Size,
+				AllocatedDiff:    fb.Allocated,
 				LastModifiedDiff: fb.LastModified.Sub(utility.GoSpecialTime),
 				HashDiff:         utility.InitialiseHashLocation(),
+				NewerLinkTarget:  fb.LinkTarget,
+				NewerLinkBroken:  fb.LinkBroken,
+				NewerDev:         fb.Dev,
+				NewerIno:         fb.Ino,
+				NewerNlink:       fb.Nlink,
+				NewerUid:         fb.Uid,
+				NewerGid:         fb.Gid,
+				NewerMode:        fb.Mode,
 			}
 
 			if fb.Hash.HashOffset > -1 {
-				fDiff.HashDiff = utility.HashLocation{Type: fb.Hash.Type, HashOffset: lastAllHashByte, HashLength: fb.Hash.HashLength}
+				fDiff.HashDiff = utility.HashLocation{Type: fb.Hash.Type, HashOffset: hashOffset, HashLength: fb.Hash.HashLength}
 				*allHashDiff = append(*allHashDiff, (*allHashesB)[fb.Hash.HashOffset:fb.Hash.HashOffset+fb.Hash.HashLength]...)
+
+				if sources := sDiff.getCopySources(fb.Hash, allHashesB); len(sources) > 0 {
+					fDiff.Type = copied
+					fDiff.CopiedFrom = sources
+				}
 			}
 
 			sDiff.Files[fb.Name] = fDiff
@@ -172,9 +247,11 @@ func diffTrees(a, b []tree.FileTree, aHashes, bHashes, allHashDiff *[]byte, isCo
 				nameSame = ta.BasePath == tb.BasePath
 				sizeSame = ta.SizeDirect == tb.SizeDirect
 				modSame  = time.Time.Equal(ta.LastModifiedDirect, tb.LastModifiedDirect)
+				devSame  = ta.Dev == tb.Dev
+				metaSame = ta.Uid == tb.Uid && ta.Gid == tb.Gid && ta.Mode == tb.Mode
 			)
 
-			if nameSame && sizeSame && modSame {
+			if nameSame && sizeSame && modSame && devSame && metaSame {
 				treeUnchanged = j
 
 				if changedFileIndices[i] == nil || changedFiles[i] == nil {
@@ -190,7 +267,7 @@ func diffTrees(a, b []tree.FileTree, aHashes, bHashes, allHashDiff *[]byte, isCo
 					treeChanged = j
 					break
 				}
-			} else if !nameSame && sizeSame && modSame {
+			} else if !nameSame && sizeSame && modSame && devSame {
 				treeRenamed = j
 
 				if changedFileIndices[i] == nil || changedFiles[i] == nil {
@@ -208,7 +285,8 @@ func diffTrees(a, b []tree.FileTree, aHashes, bHashes, allHashDiff *[]byte, isCo
 				}
 				// TODO: For some reason, when adding a file to a directory, that "shifts" the position of other files/directory down, the
 				// LastModified time seems to be changed on MacOS, idk why this happens, needs further investigation
-			} else if nameSame && ((runtime.GOOS == "darwin" && !sizeSame) || (runtime.GOOS != "darwin" && !modSame)) {
+			} else if nameSame && (!devSame || !metaSame || !sizeSame || (runtime.GOOS != "darwin" && !modSame)) {
+				// A file removed from the directory changes its size, but not always its newest mtime
 				treeChanged = j
 			}
 
@@ -253,10 +331,17 @@ func diffTrees(a, b []tree.FileTree, aHashes, bHashes, allHashDiff *[]byte, isCo
 				blm = newer.LastModifiedDirect
 			}
 
+			// None of the directory's files changed, only its own metadata
+			diffType := contentModified
+			if len(changedFiles[i][treeChanged]) == 0 && older.SizeDirect == newer.SizeDirect && older.Dev == newer.Dev {
+				diffType = metadataModified
+			}
+			newErrors, resolvedErrors := tree.DiffWalkErrors(older.Errors, newer.Errors)
+
 			sDiff.Trees[ta.BasePath] = TreeDiff{
 				DiffCompleted: time.Now(),
 				Comprehensive: newer.Comprehensive,
-				Type:          modified,
+				Type:          diffType,
 
 				NewerPath:              newer.BasePath,
 				FilesDiff:              changedFiles[i][treeChanged],
@@ -265,11 +350,20 @@ func diffTrees(a, b []tree.FileTree, aHashes, bHashes, allHashDiff *[]byte, isCo
 				TimeTakenDiff:          newer.TimeTaken - older.TimeTaken,
 				LastModifiedDiffDirect: blm.Sub(alm),
 				DepthDiff:              newer.Depth - older.Depth,
-				ErrStringsDiff:         utility.AdditionalStringsInB(older.ErrStrings, newer.ErrStrings),
+				DevDiff:                int64(newer.Dev - older.Dev),
+				NewErrors:              newErrors,
+				ResolvedErrors:         resolvedErrors,
 
 				SubTreesDiffIndices:     stDiffIdx,
 				SizeDiffDirect:          newer.SizeDirect - older.SizeDirect,
+				AllocatedDiffDirect:     newer.AllocatedDirect - older.AllocatedDirect,
 				NumFilesTotalDiffDirect: newer.NumFilesDirect - older.NumFilesDirect,
+
+				NewerUid:     newer.Uid,
+				NewerGid:     newer.Gid,
+				NewerMode:    newer.Mode,
+				OwnerChanged: newer.Uid != older.Uid || newer.Gid != older.Gid,
+				ModeChanged:  newer.Mode != older.Mode,
 			}
 
 			changesFoundB[treeChanged] = struct{}{}
@@ -289,9 +383,11 @@ func diffTrees(a, b []tree.FileTree, aHashes, bHashes, allHashDiff *[]byte, isCo
 				TimeTakenDiff:          -ta.TimeTaken,
 				LastModifiedDiffDirect: utility.GoSpecialTime.Sub(lm),
 				DepthDiff:              -ta.Depth,
-				ErrStringsDiff:         []string{},
+				DevDiff:                -int64(ta.Dev),
+				ResolvedErrors:         ta.Errors,
 
 				SizeDiffDirect:          -ta.SizeDirect,
+				AllocatedDiffDirect:     -ta.AllocatedDirect,
 				NumFilesTotalDiffDirect: -ta.NumFilesDirect,
 			}
 		}
@@ -325,11 +421,17 @@ func diffTrees(a, b []tree.FileTree, aHashes, bHashes, allHashDiff *[]byte, isCo
 				TimeTakenDiff:          tb.TimeTaken,
 				LastModifiedDiffDirect: lm.Sub(utility.GoSpecialTime),
 				DepthDiff:              tb.Depth,
-				ErrStringsDiff:         tb.ErrStrings,
+				DevDiff:                int64(tb.Dev),
+				NewErrors:              tb.Errors,
 
 				SubTreesDiffIndices:     stDiffIdx,
 				SizeDiffDirect:          tb.SizeDirect,
+				AllocatedDiffDirect:     tb.AllocatedDirect,
 				NumFilesTotalDiffDirect: tb.NumFilesDirect,
+
+				NewerUid:  tb.Uid,
+				NewerGid:  tb.Gid,
+				NewerMode: tb.Mode,
 			}
 		}
 	}
@@ -1,33 +1,141 @@
 package diff
 
 import (
+	"bufio"
 	"encoding/gob"
+	"fmt"
+	"io"
 	"os"
+	"time"
 
 	"github.com/joomcode/errorx"
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
 )
 
-func (d *ScanDiff) WriteBinary(path string) error {
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+/*
+The version of the format of `.diff` files, after their header. Must be incremented when the fields
+of `ScanDiff` (or its fields' types) change, since they're written as a `gob`
+*/
+const diffFormatVersion = 1
+
+/*
+The header of the `.diff` file of a diff resulting in `newer`
+*/
+func NewHeader(newer *tree.FileTree, isComprehensive bool) utility.FileHeader {
+	h := newer.NewHeader()
+	h.Kind = utility.FileKindDiff
+	h.FormatVersion = diffFormatVersion
+	h.Comprehensive = isComprehensive
+	return h
+}
+
+/*
+Writes the header `h` then the diff to `path` as a `gob`, compressed at the `utility.GetCompressionLevel`.
+It's written atomically, see `utility.WriteFileAtomic`
+*/
+func (d *ScanDiff) WriteBinary(path string, h utility.FileHeader) error {
+	err := utility.WriteFileAtomic(path, 0600, func(w io.Writer) error {
+		cw, err := utility.NewCompressedWriter(w)
+		if err != nil {
+			return errorx.Decorate(err, "failed to create compressor for ScanDiff data")
+		}
+		if _, err = utility.WriteFileHeader(cw, h); err != nil {
+			return errorx.Decorate(err, "failed to write ScanDiff header")
+		}
+		ge := gob.NewEncoder(cw)
+		if err = ge.Encode(&d); err != nil {
+			return err
+		}
+		return cw.Close()
+	})
 	if err != nil {
-		return errorx.Decorate(err, "failed to open/create file for writing ScanDiff data")
+		return errorx.Decorate(err, "failed to write ScanDiff data")
 	}
-	defer f.Close()
-
-	ge := gob.NewEncoder(f)
-	return ge.Encode(&d)
+	return nil
 }
 
-func ReadBinary(path string) (ScanDiff, error) {
+/*
+Reads a diff written by `WriteBinary`, or by older versions of seye (without a header, or
+uncompressed). The header is nil for the latter
+*/
+func ReadBinaryWithHeader(path string) (ScanDiff, *utility.FileHeader, error) {
 	scanDiff := ScanDiff{}
 	f, err := os.OpenFile(path, os.O_RDONLY, 0400)
 	if err != nil {
-		return scanDiff, errorx.Decorate(err, "failed to open file for readgin FileTree data")
+		return scanDiff, nil, errorx.Decorate(err, "failed to open file for readgin FileTree data")
 	}
 	defer f.Close()
 
-	gd := gob.NewDecoder(f)
+	// Diffs written by older versions of seye are uncompressed
+	br, err := utility.NewDecompressedReader(bufio.NewReader(f))
+	if err != nil {
+		return scanDiff, nil, errorx.Decorate(err, "failed to decompress ScanDiff data")
+	}
+	h, _, err := utility.ReadFileHeader(br)
+	if err == nil && h != nil {
+		err = h.Check(utility.FileKindDiff, diffFormatVersion)
+	}
+	if err != nil {
+		return scanDiff, nil, fmt.Errorf("failed to read '%s': %w", path, err)
+	}
+	gd := gob.NewDecoder(br)
 	err = gd.Decode(&scanDiff)
+	scanDiff.upgradeLegacyErrors()
+
+	return scanDiff, h, err
+}
 
+/*
+Moves the `NewerErr` of each file diff, decoded from an older `gob` diff, to its `NewerWalkErr`
+*/
+func (s *ScanDiff) upgradeLegacyErrors() {
+	for k, fd := range s.Files {
+		fd.upgradeLegacyError()
+		s.Files[k] = fd
+	}
+	for k, td := range s.Trees {
+		td.upgradeLegacyErrors()
+		s.Trees[k] = td
+	}
+}
+
+func (t *TreeDiff) upgradeLegacyErrors() {
+	for i := range t.FilesDiff {
+		t.FilesDiff[i].upgradeLegacyError()
+	}
+	for i := range t.SubTreesDiff {
+		t.SubTreesDiff[i].upgradeLegacyErrors()
+	}
+}
+
+func (f *FileDiff) upgradeLegacyError() {
+	if f.NewerWalkErr == nil {
+		f.NewerWalkErr = tree.LegacyWalkError(f.NewerName, f.NewerErr)
+	}
+	f.NewerErr = ""
+}
+
+func ReadBinary(path string) (ScanDiff, error) {
+	scanDiff, _, err := ReadBinaryWithHeader(path)
 	return scanDiff, err
 }
+
+/*
+Rewrites a `.diff` file written by an older version of seye (i.e. without a header) in the current
+format. Returns false if it's already in the current format.
+
+The scan the diff resulted in may no longer be stored, so the header's totals are left as 0
+*/
+func MigrateDiff(path, rootPath string, scanTime time.Time, isComprehensive bool) (bool, error) {
+	d, oldHeader, err := ReadBinaryWithHeader(path)
+	if err != nil {
+		return false, err
+	} else if oldHeader != nil && oldHeader.FormatVersion == diffFormatVersion {
+		return false, nil
+	}
+
+	h := NewHeader(&tree.FileTree{BasePath: rootPath, LastVisited: scanTime}, isComprehensive)
+	h.NumDirs = 0
+	return true, d.WriteBinary(path, h)
+}
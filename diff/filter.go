@@ -0,0 +1,115 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// Names used for a `DiffType` in JSON output and arguments
+	diffTypeToName = map[DiffType]string{
+		contentModified:  "contentModified",
+		same:             "same",
+		renamed:          "renamed",
+		removed:          "removed",
+		added:            "added",
+		metadataModified: "metadataModified",
+		copied:           "copied",
+	}
+)
+
+func (d DiffType) MarshalJSON() ([]byte, error) {
+	name, ok := diffTypeToName[d]
+	if !ok {
+		return nil, fmt.Errorf("unknown diff type %d", d)
+	}
+	return json.Marshal(name)
+}
+
+func (d *DiffType) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	parsed, err := ParseDiffTypes(name)
+	if err != nil {
+		return err
+	}
+	*d = parsed[0]
+	return nil
+}
+
+/*
+Parses a comma separated list of `DiffType` names, e.g. "metadataModified,renamed"
+*/
+func ParseDiffTypes(names string) ([]DiffType, error) {
+	types := []DiffType{}
+	for _, n := range strings.Split(names, ",") {
+		found := false
+		for dt, dtn := range diffTypeToName {
+			if dtn == n {
+				types = append(types, dt)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid diff type '%s', must be one of: contentModified, metadataModified, renamed, removed, added, copied", n)
+		}
+	}
+	return types, nil
+}
+
+/*
+Gets a copy of the `ScanDiff` without any file or tree diffs of the `ignored` types,
+e.g. to ignore files that were only touched. Only intended for output, the result
+can't be added to a tree
+*/
+func (s *ScanDiff) WithoutTypes(ignored []DiffType) ScanDiff {
+	isIgnored := func(dt DiffType) bool {
+		for _, it := range ignored {
+			if dt == it {
+				return true
+			}
+		}
+		return false
+	}
+
+	ret := ScanDiff{
+		AllHash:        s.AllHash,
+		NewErrors:      s.NewErrors,
+		ResolvedErrors: s.ResolvedErrors,
+		Trees:          map[string]TreeDiff{},
+		Files:          map[string]FileDiff{},
+	}
+	for k, v := range s.Trees {
+		if isIgnored(v.Type) {
+			continue
+		}
+		filesDiff := []FileDiff{}
+		for _, fd := range v.FilesDiff {
+			if !isIgnored(fd.Type) {
+				filesDiff = append(filesDiff, fd)
+			}
+		}
+		v.FilesDiff = filesDiff
+		ret.Trees[k] = v
+	}
+	for k, v := range s.Files {
+		if !isIgnored(v.Type) {
+			ret.Files[k] = v
+		}
+	}
+	return ret
+}
+
+/*
+Writes the file and tree diffs as JSON, the hashes aren't included
+*/
+func WriteJSON(w io.Writer, sf ScanDiff) error {
+	je := json.NewEncoder(w)
+	je.SetIndent("", "  ")
+	return je.Encode(sf)
+}
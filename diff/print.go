@@ -8,25 +8,39 @@ import (
 
 var (
 	diffTypeToString = map[DiffType]string{
-		modified: "modified",
-		same:     "same",
-		renamed:  "renamed",
-		removed:  "removed",
-		added:    "added",
+		contentModified: "content modified",
+		same:            "same",
+		renamed:         "renamed",
+		removed:         "removed",
+		added:           "added",
+
+		metadataModified: "metadata modified",
+		copied:           "copied",
 	}
 )
 
 func PrintLargestDiffs(limit int, sf ScanDiff) {
-	diffArray := make([]FileDiff, len(sf.Files))
-	i := 0
+	// Metadata changes don't change the size, they're only counted
+	var (
+		diffArray        = make([]FileDiff, 0, len(sf.Files))
+		numContentDiffs  = 0
+		numMetadataDiffs = 0
+	)
 	for _, v := range sf.Files {
-		diffArray[i] = v
-		i++
+		switch v.Type {
+		case metadataModified:
+			numMetadataDiffs++
+			continue
+		case contentModified:
+			numContentDiffs++
+		}
+		diffArray = append(diffArray, v)
 	}
+	fmt.Printf("Found %d files with CONTENT changes and %d with only METADATA changes\n", numContentDiffs, numMetadataDiffs)
 
 	totalSizeIncrease := 0
 	for _, v := range sf.Files {
-		totalSizeIncrease += int(v.SizeDiff)
+		totalSizeIncrease += int(v.ReportedSizeDiff())
 	}
 	changeDirection := "increase"
 	if totalSizeIncrease < 0 {
@@ -36,20 +50,127 @@ func PrintLargestDiffs(limit int, sf ScanDiff) {
 	deepestDirs := diffArray
 
 	sort.SliceStable(deepestDirs, func(i, j int) bool {
-		return deepestDirs[i].SizeDiff > deepestDirs[j].SizeDiff
+		return deepestDirs[i].ReportedSizeDiff() > deepestDirs[j].ReportedSizeDiff()
 	})
 
 	fmt.Println("Biggest disk usage INCREASES")
 	for i := 0; i < limit && i < len(deepestDirs); i++ {
-		if deepestDirs[i].SizeDiff > 0 {
-			fmt.Printf("'%s' +%d bytes (%s)\n", deepestDirs[i].NewerName, deepestDirs[i].SizeDiff, strings.ToUpper(diffTypeToString[deepestDirs[i].Type]))
+		if deepestDirs[i].ReportedSizeDiff() > 0 {
+			fmt.Printf("'%s' +%d bytes (%s)\n", deepestDirs[i].NewerName, deepestDirs[i].ReportedSizeDiff(), strings.ToUpper(diffTypeToString[deepestDirs[i].Type]))
 		}
 	}
 
 	fmt.Println("\nBiggest disk usage DECREASES")
 	for i := 0; i < limit && i < len(deepestDirs); i++ {
-		if deepestDirs[len(deepestDirs)-1-i].SizeDiff <= 0 {
-			fmt.Printf("'%s' %d bytes (%s)\n", deepestDirs[len(deepestDirs)-1-i].NewerName, deepestDirs[len(deepestDirs)-1-i].SizeDiff, strings.ToUpper(diffTypeToString[deepestDirs[len(deepestDirs)-1-i].Type]))
+		if deepestDirs[len(deepestDirs)-1-i].ReportedSizeDiff() <= 0 {
+			fmt.Printf("'%s' %d bytes (%s)\n", deepestDirs[len(deepestDirs)-1-i].NewerName, deepestDirs[len(deepestDirs)-1-i].ReportedSizeDiff(), strings.ToUpper(diffTypeToString[deepestDirs[len(deepestDirs)-1-i].Type]))
+		}
+	}
+}
+
+/*
+Prints the symlinks that point somewhere different to where they did before
+*/
+func PrintLinkTargetChanges(sf ScanDiff) {
+	changedLinks := []FileDiff{}
+	for _, v := range sf.Files {
+		if v.LinkTargetChanged {
+			changedLinks = append(changedLinks, v)
+		}
+	}
+	if len(changedLinks) == 0 {
+		return
+	}
+
+	sort.SliceStable(changedLinks, func(i, j int) bool {
+		return changedLinks[i].NewerName < changedLinks[j].NewerName
+	})
+
+	fmt.Println("\nSymlinks with CHANGED targets")
+	for _, v := range changedLinks {
+		broken := ""
+		if v.NewerLinkBroken {
+			broken = " (BROKEN)"
+		}
+		fmt.Printf("'%s' -> '%s'%s\n", v.NewerName, v.NewerLinkTarget, broken)
+	}
+}
+
+/*
+Prints the files and directories whose owner or permissions changed
+*/
+func PrintMetadataChanges(sf ScanDiff) {
+	changedMetadata := []string{}
+	for _, v := range sf.Files {
+		if v.OwnerChanged || v.ModeChanged {
+			changedMetadata = append(changedMetadata, fmt.Sprintf("'%s' owner %d:%d, mode %s", v.NewerName, v.NewerUid, v.NewerGid, v.NewerMode))
+		}
+	}
+	for _, v := range sf.Trees {
+		if v.OwnerChanged || v.ModeChanged {
+			changedMetadata = append(changedMetadata, fmt.Sprintf("'%s' owner %d:%d, mode %s", v.NewerPath, v.NewerUid, v.NewerGid, v.NewerMode))
+		}
+	}
+	if len(changedMetadata) == 0 {
+		return
+	}
+	sort.Strings(changedMetadata)
+
+	fmt.Println("\nCHANGED owners or permissions")
+	for _, v := range changedMetadata {
+		fmt.Println(v)
+	}
+}
+
+/*
+Prints the space consumed by files copied from existing files, and the `limit` largest copies
+*/
+func PrintCopies(limit int, sf ScanDiff) {
+	var (
+		copies     = []FileDiff{}
+		copiedSize int64
+	)
+	for _, v := range sf.Files {
+		if v.Type == copied {
+			copies = append(copies, v)
+			copiedSize += v.ReportedSizeDiff()
+		}
+	}
+	if len(copies) == 0 {
+		return
+	}
+
+	sort.SliceStable(copies, func(i, j int) bool {
+		if copies[i].ReportedSizeDiff() == copies[j].ReportedSizeDiff() {
+			return copies[i].NewerName < copies[j].NewerName
+		}
+		return copies[i].ReportedSizeDiff() > copies[j].ReportedSizeDiff()
+	})
+
+	fmt.Printf("\n%d COPIED files consume %d bytes, the largest copies are\n", len(copies), copiedSize)
+	for i := 0; i < limit && i < len(copies); i++ {
+		others := ""
+		if len(copies[i].CopiedFrom) > 1 {
+			others = fmt.Sprintf(" (and %d others)", len(copies[i].CopiedFrom)-1)
+		}
+		fmt.Printf("'%s' +%d bytes, copied from '%s'%s\n", copies[i].NewerName, copies[i].ReportedSizeDiff(), copies[i].CopiedFrom[0], others)
+	}
+}
+
+/*
+Prints the errors that appeared, or were resolved, since the older scan
+*/
+func PrintErrorChanges(sf ScanDiff) {
+	if len(sf.NewErrors) > 0 {
+		fmt.Printf("\n%d NEW errors\n", len(sf.NewErrors))
+		for _, e := range sf.NewErrors {
+			fmt.Printf("'%s' %s failed (%s): %s\n", e.Path, e.Op, e.Class, e.Message)
+		}
+	}
+	if len(sf.ResolvedErrors) > 0 {
+		fmt.Printf("\n%d RESOLVED errors\n", len(sf.ResolvedErrors))
+		for _, e := range sf.ResolvedErrors {
+			fmt.Printf("'%s' %s failed (%s)\n", e.Path, e.Op, e.Class)
 		}
 	}
 }
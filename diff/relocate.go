@@ -0,0 +1,76 @@
+package diff
+
+import (
+	"time"
+
+	"github.com/joomcode/errorx"
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+/*
+Moves every path in the diff from under `oldRoot` to under `newRoot`, see `utility.RebasePath`
+*/
+func (s *ScanDiff) Rebase(oldRoot, newRoot string) {
+	trees := make(map[string]TreeDiff, len(s.Trees))
+	for k, td := range s.Trees {
+		td.rebase(oldRoot, newRoot)
+		trees[utility.RebasePath(k, oldRoot, newRoot)] = td
+	}
+	s.Trees = trees
+
+	files := make(map[string]FileDiff, len(s.Files))
+	for k, fd := range s.Files {
+		fd.rebase(oldRoot, newRoot)
+		files[utility.RebasePath(k, oldRoot, newRoot)] = fd
+	}
+	s.Files = files
+
+	tree.RebaseWalkErrors(s.NewErrors, oldRoot, newRoot)
+	tree.RebaseWalkErrors(s.ResolvedErrors, oldRoot, newRoot)
+}
+
+func (t *TreeDiff) rebase(oldRoot, newRoot string) {
+	t.OriginalPath = utility.RebasePath(t.OriginalPath, oldRoot, newRoot)
+	t.NewerPath = utility.RebasePath(t.NewerPath, oldRoot, newRoot)
+	tree.RebaseWalkErrors(t.NewErrors, oldRoot, newRoot)
+	tree.RebaseWalkErrors(t.ResolvedErrors, oldRoot, newRoot)
+	for i := range t.FilesDiff {
+		t.FilesDiff[i].rebase(oldRoot, newRoot)
+	}
+	for i := range t.SubTreesDiff {
+		t.SubTreesDiff[i].rebase(oldRoot, newRoot)
+	}
+}
+
+func (f *FileDiff) rebase(oldRoot, newRoot string) {
+	f.NewerName = utility.RebasePath(f.NewerName, oldRoot, newRoot)
+	if f.NewerWalkErr != nil {
+		f.NewerWalkErr.Path = utility.RebasePath(f.NewerWalkErr.Path, oldRoot, newRoot)
+	}
+	for i, p := range f.CopiedFrom {
+		f.CopiedFrom[i] = utility.RebasePath(p, oldRoot, newRoot)
+	}
+}
+
+/*
+Rewrites the `.diff` file at `path`, of scans of `oldRoot`, to `newPath` as a diff of scans of
+`newRoot` (see `Rebase`). The file at `path` is left as it was.
+
+Diffs written by older versions of seye don't have a header, one is added like `MigrateDiff`
+*/
+func RelocateDiff(path, newPath, oldRoot, newRoot string, scanTime time.Time, isComprehensive bool) error {
+	d, h, err := ReadBinaryWithHeader(path)
+	if err != nil {
+		return errorx.Decorate(err, "failed to read ScanDiff to relocate")
+	}
+	d.Rebase(oldRoot, newRoot)
+
+	if h == nil {
+		newHeader := NewHeader(&tree.FileTree{LastVisited: scanTime}, isComprehensive)
+		newHeader.NumDirs = 0
+		h = &newHeader
+	}
+	h.RootPath = newRoot
+	return d.WriteBinary(newPath, *h)
+}
@@ -0,0 +1,154 @@
+package diff
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+const (
+	// The file has the same (dev, inode), i.e. it's definitely the same file
+	renameConfidenceInode = 1.0
+	// The file has the same contents
+	renameConfidenceHash = 0.9
+	// The file has the same size and mtime, only used when the inodes aren't known
+	renameConfidenceHeuristic = 0.5
+)
+
+/*
+`diffFiles` only finds renames within the same directory, a file moved to another
+directory is seen as `removed` from one and `added` (or `copied`) to the other.
+
+Pairs these up by their (dev, inode) and replaces them with a single `renamed`
+diff or, if the size or mtime also changed, a `contentModified` diff to the new
+path
+*/
+func detectMoves(sDiff *ScanDiff) {
+	type devIno struct {
+		dev uint64
+		ino uint64
+	}
+
+	removedByInode := map[devIno]string{}
+	for k, v := range sDiff.Files {
+		if v.Type == removed && v.NewerIno != 0 {
+			removedByInode[devIno{v.NewerDev, v.NewerIno}] = k
+		}
+	}
+	if len(removedByInode) == 0 {
+		return
+	}
+
+	// Old path -> rename diff, and the new paths that are no longer `added`
+	var (
+		moves      = map[string]FileDiff{}
+		movedPaths = map[string]struct{}{}
+	)
+	for k, v := range sDiff.Files {
+		if (v.Type != added && v.Type != copied) || v.NewerIno == 0 {
+			continue
+		}
+		removedPath, ok := removedByInode[devIno{v.NewerDev, v.NewerIno}]
+		if !ok {
+			continue
+		}
+		r := sDiff.Files[removedPath]
+		if r.SizeDiff == -v.SizeDiff && r.LastModifiedDiff == -v.LastModifiedDiff && r.AllocatedDiff == -v.AllocatedDiff {
+			moves[removedPath] = FileDiff{
+				NewerName:        v.NewerName,
+				Type:             renamed,
+				RenameConfidence: renameConfidenceInode,
+			}
+		} else {
+			moves[removedPath] = movedAndChanged(r, v)
+		}
+		movedPaths[k] = struct{}{}
+		delete(removedByInode, devIno{v.NewerDev, v.NewerIno})
+	}
+
+	for k, v := range moves {
+		sDiff.Files[k] = v
+	}
+	for k := range movedPaths {
+		delete(sDiff.Files, k)
+	}
+
+	// Keep the per-directory lists consistent with `sDiff.Files`
+	for tk, td := range sDiff.Trees {
+		if len(td.FilesDiff) == 0 {
+			continue
+		}
+		filesDiff := []FileDiff{}
+		for _, fd := range td.FilesDiff {
+			if _, ok := movedPaths[fd.NewerName]; ok && (fd.Type == added || fd.Type == copied) {
+				continue
+			} else if move, ok := moves[fd.NewerName]; ok && fd.Type == removed {
+				fd = move
+			}
+			filesDiff = append(filesDiff, fd)
+		}
+		td.FilesDiff = filesDiff
+		sDiff.Trees[tk] = td
+	}
+}
+
+/*
+The diff of a file that was moved and changed, from the diffs of it being `removed` from its old
+path and `added` (or `copied`) to its new path. The differences are between the two, rather than
+from nothing
+*/
+func movedAndChanged(removedDiff, addedDiff FileDiff) FileDiff {
+	ret := addedDiff
+	ret.Type = contentModified
+	ret.SizeDiff += removedDiff.SizeDiff
+	ret.AllocatedDiff += removedDiff.AllocatedDiff
+	ret.LastModifiedDiff += removedDiff.LastModifiedDiff
+	ret.CopiedFrom = nil
+	ret.RenameConfidence = renameConfidenceInode
+	return ret
+}
+
+/*
+Prints renamed and moved files, those matched by their inode or contents first,
+then those only matched by their size and mtime (which may be wrong)
+*/
+func PrintRenames(sf ScanDiff) {
+	var (
+		certain   = []string{}
+		heuristic = []string{}
+	)
+	for k, v := range sf.Files {
+		if v.Type != renamed && v.RenameConfidence == 0 {
+			continue
+		}
+
+		verb := "RENAMED"
+		if path.Dir(k) != path.Dir(v.NewerName) {
+			verb = "MOVED"
+		}
+		if v.Type != renamed {
+			verb += " (AND CHANGED)"
+		}
+		line := fmt.Sprintf("'%s' %s to '%s' (confidence %.1f)", k, verb, v.NewerName, v.RenameConfidence)
+		if v.RenameConfidence > renameConfidenceHeuristic {
+			certain = append(certain, line)
+		} else {
+			heuristic = append(heuristic, line)
+		}
+	}
+	sort.Strings(certain)
+	sort.Strings(heuristic)
+
+	if len(certain) > 0 {
+		fmt.Println("\nRENAMED or MOVED files")
+		for _, v := range certain {
+			fmt.Println(v)
+		}
+	}
+	if len(heuristic) > 0 {
+		fmt.Println("\nPossibly RENAMED files (only the size and modification time match)")
+		for _, v := range heuristic {
+			fmt.Println(v)
+		}
+	}
+}
@@ -1,19 +1,23 @@
 package diff
 
 import (
+	"os"
 	"time"
 
+	"github.com/pericles-tpt/seye/tree"
 	"github.com/pericles-tpt/seye/utility"
 )
 
 type DiffType int64
 
 const (
-	modified DiffType = iota
+	contentModified DiffType = iota // The contents (hash or size) changed
 	same
 	renamed
 	removed
 	added
+	metadataModified // Only the mtime, owner or mode changed, the contents (hash or size) are the same
+	copied           // An added file with the same contents as a file in the older tree
 )
 
 /*
@@ -22,9 +26,20 @@ file/tree differences, their paths (as map keys)
 and an array containing all new file hash values
 */
 type ScanDiff struct {
-	AllHash []byte // Only populated at depth == 0
+	AllHash []byte `json:"-"` // Only populated at depth == 0
 	Trees   map[string]TreeDiff
 	Files   map[string]FileDiff
+
+	// Errors from every directory and file in the tree, see `tree.GetErrorChanges`
+	NewErrors      []tree.WalkError
+	ResolvedErrors []tree.WalkError
+
+	// Only populated while comparing "comprehensive" trees, see `buildHashIndex`
+	oldHashIndex map[hashKey][]string
+	// Only set while comparing trees hashed in chunks of different sizes, see `hashesComparable`
+	chunkSizesDiffer bool
+	// Only populated while adding the diff to a tree, files moved to another directory
+	movedFiles []tree.File
 }
 
 func (s *ScanDiff) Empty() bool {
@@ -119,7 +134,9 @@ type TreeDiff struct {
 	OriginalPath     string
 	NewerPath        string
 	DepthDiff        int
-	ErrStringsDiff   []string
+	DevDiff          int64 // Wraps around, apply with `uint64(Dev) += uint64(DevDiff)`
+	NewErrors        []tree.WalkError
+	ResolvedErrors   []tree.WalkError
 	FilesDiff        []FileDiff
 	FilesDiffIndices []int
 	LastVisitedDiff  time.Duration
@@ -127,23 +144,33 @@ type TreeDiff struct {
 
 	LastModifiedDiffDirect  time.Duration
 	SizeDiffDirect          int64
+	AllocatedDiffDirect     int64
 	NumFilesTotalDiffDirect int64
 
+	NewerUid     uint32
+	NewerGid     uint32
+	NewerMode    os.FileMode
+	OwnerChanged bool
+	ModeChanged  bool
+
 	// Recursive data
 	SubTreesDiff        []TreeDiff
 	SubTreesDiffIndices []int
 
-	AllHash       []byte // Only populated at depth == 0
+	AllHash       []byte `json:"-"` // Only populated at depth == 0
 	AllHashOffset int64
 }
 
 func (t *TreeDiff) Equals(b TreeDiff) bool {
-	if len(t.ErrStringsDiff) != len(b.ErrStringsDiff) {
+	if len(t.NewErrors) != len(b.NewErrors) || len(t.ResolvedErrors) != len(b.ResolvedErrors) {
 		return false
 	}
-	allErrStringsEqual := true
-	for i, e := range t.ErrStringsDiff {
-		allErrStringsEqual = allErrStringsEqual && (e == b.ErrStringsDiff[i])
+	allErrorsEqual := true
+	for i, e := range t.NewErrors {
+		allErrorsEqual = allErrorsEqual && (e == b.NewErrors[i])
+	}
+	for i, e := range t.ResolvedErrors {
+		allErrorsEqual = allErrorsEqual && (e == b.ResolvedErrors[i])
 	}
 
 	if len(t.FilesDiff) != len(b.FilesDiff) {
@@ -183,13 +210,20 @@ func (t *TreeDiff) Equals(b TreeDiff) bool {
 		t.OriginalPath == b.OriginalPath &&
 		t.NewerPath == b.NewerPath &&
 		t.DepthDiff == b.DepthDiff &&
+		t.DevDiff == b.DevDiff &&
 		t.LastVisitedDiff == b.LastVisitedDiff &&
 		t.TimeTakenDiff == b.TimeTakenDiff &&
 		t.LastModifiedDiffDirect == b.LastModifiedDiffDirect &&
 		t.SizeDiffDirect == b.SizeDiffDirect &&
+		t.AllocatedDiffDirect == b.AllocatedDiffDirect &&
+		t.NewerUid == b.NewerUid &&
+		t.NewerGid == b.NewerGid &&
+		t.NewerMode == b.NewerMode &&
+		t.OwnerChanged == b.OwnerChanged &&
+		t.ModeChanged == b.ModeChanged &&
 		t.NumFilesTotalDiffDirect == b.NumFilesTotalDiffDirect &&
 		t.AllHashOffset == b.AllHashOffset &&
-		allErrStringsEqual &&
+		allErrorsEqual &&
 		allFilesDiffEqual &&
 		allFilesDiffIndicesEqual &&
 		allSubTreesDiffEqual &&
@@ -203,13 +237,21 @@ func (t *TreeDiff) Empty() bool {
 		len(t.AllHash) == 0 &&
 		t.AllHashOffset == empty.AllHashOffset &&
 		t.DepthDiff == empty.DepthDiff &&
+		t.DevDiff == empty.DevDiff &&
 		time.Time.Equal(t.DiffCompleted, empty.DiffCompleted) &&
-		len(t.ErrStringsDiff) == 0 &&
+		len(t.NewErrors) == 0 &&
+		len(t.ResolvedErrors) == 0 &&
 		t.LastModifiedDiffDirect == empty.LastModifiedDiffDirect &&
 		t.LastVisitedDiff == empty.LastVisitedDiff &&
 		t.NewerPath == empty.NewerPath &&
 		t.NumFilesTotalDiffDirect == empty.NumFilesTotalDiffDirect &&
 		t.SizeDiffDirect == empty.SizeDiffDirect &&
+		t.AllocatedDiffDirect == empty.AllocatedDiffDirect &&
+		t.NewerUid == empty.NewerUid &&
+		t.NewerGid == empty.NewerGid &&
+		t.NewerMode == empty.NewerMode &&
+		t.OwnerChanged == empty.OwnerChanged &&
+		t.ModeChanged == empty.ModeChanged &&
 		t.TimeTakenDiff == empty.TimeTakenDiff &&
 		t.Type == empty.Type
 }
@@ -225,17 +267,32 @@ func (t *TreeDiff) addDiff(new *TreeDiff) {
 	}
 
 	switch new.Type {
-	case modified:
+	case metadataModified:
+		fallthrough
+	case contentModified:
 		t.Comprehensive = new.Comprehensive
 		t.NewerPath = new.NewerPath
 		t.DepthDiff += new.DepthDiff
-		t.ErrStringsDiff = append(t.ErrStringsDiff, new.ErrStringsDiff...)
+		t.DevDiff += new.DevDiff
+		// An error that appeared then was resolved (or vice versa) cancels out
+		keptNew, _ := tree.DiffWalkErrors(new.ResolvedErrors, t.NewErrors)
+		keptResolved, _ := tree.DiffWalkErrors(new.NewErrors, t.ResolvedErrors)
+		addedNew, _ := tree.DiffWalkErrors(t.ResolvedErrors, new.NewErrors)
+		addedResolved, _ := tree.DiffWalkErrors(t.NewErrors, new.ResolvedErrors)
+		t.NewErrors = append(keptNew, addedNew...)
+		t.ResolvedErrors = append(keptResolved, addedResolved...)
 		t.LastVisitedDiff += new.LastVisitedDiff
 		t.LastModifiedDiffDirect = new.LastModifiedDiffDirect
 		t.SizeDiffDirect += new.SizeDiffDirect
 		t.NumFilesTotalDiffDirect += new.NumFilesTotalDiffDirect
+		t.AllocatedDiffDirect += new.AllocatedDiffDirect
 		t.AllHashOffset = new.AllHashOffset
 		t.SizeDiffDirect += new.SizeDiffDirect
+		t.NewerUid = new.NewerUid
+		t.NewerGid = new.NewerGid
+		t.NewerMode = new.NewerMode
+		t.OwnerChanged = t.OwnerChanged || new.OwnerChanged
+		t.ModeChanged = t.ModeChanged || new.ModeChanged
 	case renamed:
 		t.NewerPath = new.NewerPath
 	case removed:
@@ -249,31 +306,101 @@ Contains the differences between two `File`s
 */
 type FileDiff struct {
 	NewerName        string
-	NewerErr         string
+	NewerWalkErr     *tree.WalkError
+	NewerErr         string // Only set in older `gob` diffs, moved to `NewerWalkErr` when they're read
 	Type             DiffType
 	HashDiff         utility.HashLocation
 	SizeDiff         int64
+	AllocatedDiff    int64
 	LastModifiedDiff time.Duration
+
+	// Only populated for symlinks
+	NewerLinkTarget   string
+	NewerLinkBroken   bool
+	LinkTargetChanged bool
+
+	// Identifies the newer file's inode, used to account for hardlinks
+	NewerDev   uint64
+	NewerIno   uint64
+	NewerNlink uint64
+
+	NewerUid     uint32
+	NewerGid     uint32
+	NewerMode    os.FileMode
+	OwnerChanged bool
+	ModeChanged  bool
+
+	// Only populated for `copied` files, the files in the older tree with the same contents
+	CopiedFrom []string
+
+	// Only populated for `renamed` files, how certain it is that it's the same file, see `renameConfidenceInode`
+	RenameConfidence float64
+}
+
+/*
+The size difference for reports, depending on the `tree.SizeMode`
+*/
+func (f *FileDiff) ReportedSizeDiff() int64 {
+	if tree.GetSizeMode() == tree.SizeDiskUsage {
+		return f.AllocatedDiff
+	}
+	return f.SizeDiff
 }
 
 func (f *FileDiff) Empty() bool {
 	empty := FileDiff{}
 	return f.Type == empty.Type &&
 		f.HashDiff == empty.HashDiff &&
-		f.NewerErr == empty.NewerErr &&
+		f.NewerWalkErr == nil &&
 		f.NewerName == empty.NewerName &&
 		f.SizeDiff == empty.SizeDiff &&
-		f.LastModifiedDiff == empty.LastModifiedDiff
+		f.AllocatedDiff == empty.AllocatedDiff &&
+		f.LastModifiedDiff == empty.LastModifiedDiff &&
+		f.NewerLinkTarget == empty.NewerLinkTarget &&
+		f.NewerLinkBroken == empty.NewerLinkBroken &&
+		f.LinkTargetChanged == empty.LinkTargetChanged &&
+		f.NewerDev == empty.NewerDev &&
+		f.NewerIno == empty.NewerIno &&
+		f.NewerNlink == empty.NewerNlink &&
+		f.NewerUid == empty.NewerUid &&
+		f.NewerGid == empty.NewerGid &&
+		f.NewerMode == empty.NewerMode &&
+		f.OwnerChanged == empty.OwnerChanged &&
+		f.ModeChanged == empty.ModeChanged &&
+		len(f.CopiedFrom) == 0 &&
+		f.RenameConfidence == empty.RenameConfidence
 }
 
 func (f *FileDiff) Equals(b FileDiff) bool {
+	if len(f.CopiedFrom) != len(b.CopiedFrom) {
+		return false
+	}
+	copiedFromEqual := true
+	for i, cf := range f.CopiedFrom {
+		copiedFromEqual = copiedFromEqual && (cf == b.CopiedFrom[i])
+	}
+
 	return f.HashDiff.HashLength == b.HashDiff.HashLength &&
 		f.HashDiff.Type == b.HashDiff.Type &&
 		f.LastModifiedDiff == b.LastModifiedDiff &&
-		f.NewerErr == b.NewerErr &&
+		tree.WalkErrorsEqual(f.NewerWalkErr, b.NewerWalkErr) &&
 		f.NewerName == b.NewerName &&
 		f.SizeDiff == b.SizeDiff &&
-		f.Type == b.Type
+		f.AllocatedDiff == b.AllocatedDiff &&
+		f.Type == b.Type &&
+		f.NewerLinkTarget == b.NewerLinkTarget &&
+		f.NewerLinkBroken == b.NewerLinkBroken &&
+		f.LinkTargetChanged == b.LinkTargetChanged &&
+		f.NewerDev == b.NewerDev &&
+		f.NewerIno == b.NewerIno &&
+		f.NewerNlink == b.NewerNlink &&
+		f.NewerUid == b.NewerUid &&
+		f.NewerGid == b.NewerGid &&
+		f.NewerMode == b.NewerMode &&
+		f.OwnerChanged == b.OwnerChanged &&
+		f.ModeChanged == b.ModeChanged &&
+		f.RenameConfidence == b.RenameConfidence &&
+		copiedFromEqual
 }
 
 /*
@@ -287,11 +414,25 @@ func (f *FileDiff) addDiff(new *FileDiff, thisAllHash *[]byte, allHashNew *[]byt
 	}
 
 	switch new.Type {
-	case modified:
+	case metadataModified:
+		fallthrough
+	case contentModified:
 		f.NewerName = new.NewerName
-		f.NewerErr = new.NewerErr
+		f.NewerWalkErr = new.NewerWalkErr
+		f.NewerLinkTarget = new.NewerLinkTarget
+		f.NewerLinkBroken = new.NewerLinkBroken
+		f.LinkTargetChanged = f.LinkTargetChanged || new.LinkTargetChanged
+		f.NewerDev = new.NewerDev
+		f.NewerIno = new.NewerIno
+		f.NewerNlink = new.NewerNlink
+		f.NewerUid = new.NewerUid
+		f.NewerGid = new.NewerGid
+		f.NewerMode = new.NewerMode
+		f.OwnerChanged = f.OwnerChanged || new.OwnerChanged
+		f.ModeChanged = f.ModeChanged || new.ModeChanged
 		f.LastModifiedDiff = new.LastModifiedDiff
 		f.SizeDiff += new.SizeDiff
+		f.AllocatedDiff += new.AllocatedDiff
 		new.HashDiff = utility.InitialiseHashLocation()
 		if new.HashDiff.HashOffset > -1 {
 			// Put the new hash in the old location for `f` in `allHash`
@@ -299,6 +440,7 @@ func (f *FileDiff) addDiff(new *FileDiff, thisAllHash *[]byte, allHashNew *[]byt
 		}
 	case renamed:
 		f.NewerName = new.NewerName
+		f.RenameConfidence = new.RenameConfidence
 	case removed:
 		f.Type = new.Type
 	default:
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"compress/gzip"
 	"log"
 	"os"
 	"strings"
@@ -8,10 +9,24 @@ import (
 	"github.com/pericles-tpt/seye/command"
 	"github.com/pericles-tpt/seye/config"
 	"github.com/pericles-tpt/seye/records"
+	"github.com/pericles-tpt/seye/utility"
 )
 
 var (
-	validCommands = []string{"scan", "report", "diff", "help"}
+	// "convert" is an alias of "migrate", which does everything it used to
+	validCommands = []string{"scan", "report", "diff", "convert", "migrate", "relocate", "fsck", "browse", "history", "help"}
+	// Whether each command takes an exclusive (i.e. writes to the scans) or shared lock on the scans
+	lockModes = map[string]bool{
+		"scan":     true,
+		"convert":  true,
+		"migrate":  true,
+		"relocate": true,
+		"fsck":     true,
+		"report":   false,
+		"diff":     false,
+		"browse":   false,
+		"history":  false,
+	}
 )
 
 func main() {
@@ -21,10 +36,11 @@ func main() {
 		log.Fatal("[Fiye] failed to load config", err)
 	}
 	runPreviously := config.GetRunPreviously()
-
-	err = records.Load()
-	if err != nil {
-		log.Fatal("[Fiye] failed to load scan records", err)
+	if level, ok := config.GetCompressionLevel(); ok {
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			log.Fatalf("[Fiye] invalid 'compressionLevel' in config, must be between %d and %d", gzip.HuffmanOnly, gzip.BestCompression)
+		}
+		utility.SetCompressionLevel(level)
 	}
 
 	// Commands
@@ -38,12 +54,43 @@ func main() {
 
 	var (
 		cmd    = os.Args[1]
-		params = os.Args[2:]
+		params = []string{}
+		wait   = false
 	)
+	for _, v := range os.Args[2:] {
+		if v == "--wait" {
+			wait = true
+		} else if v == "--no-wait" {
+			wait = false
+		} else {
+			params = append(params, v)
+		}
+	}
+
+	// The output directory is chosen on the first scan, it has to be known before the scans in it are locked
+	if cmd == "scan" && !runPreviously {
+		if err = command.SetupOutputDir(); err != nil {
+			log.Fatal("[Fiye] failed to set up the scans output directory", err)
+		}
+	}
+
+	// Lock the scans before reading the records, so another process can't change them underneath this one.
+	// A report only reads the scans with '--from-scan', otherwise it walks the directory
+	if exclusive, ok := lockModes[cmd]; ok && (cmd != "report" || utility.Contains(params, "--from-scan")) {
+		lock, err := records.LockScans(exclusive, wait)
+		if err != nil {
+			log.Fatal("[Fiye] failed to lock the scans: ", err)
+		}
+		defer lock.Unlock()
+	}
+	err = records.Load()
+	if err != nil {
+		log.Fatal("[Fiye] failed to load scan records", err)
+	}
 
 	switch cmd {
 	case "scan":
-		err = command.Scan(params, runPreviously)
+		err = command.Scan(params)
 		if err != nil {
 			log.Fatal("[Fiye] failed to run scan", err)
 		}
@@ -57,6 +104,31 @@ func main() {
 		if err != nil {
 			log.Fatal("[Fiye] failed to run changes", err)
 		}
+	case "migrate", "convert":
+		err = command.Migrate()
+		if err != nil {
+			log.Fatal("[Fiye] failed to migrate scans", err)
+		}
+	case "relocate":
+		err = command.Relocate(params)
+		if err != nil {
+			log.Fatal("[Fiye] failed to relocate scans", err)
+		}
+	case "fsck":
+		err = command.Fsck(params)
+		if err != nil {
+			log.Fatal("[Fiye] failed to run fsck", err)
+		}
+	case "browse":
+		err = command.Browse(params)
+		if err != nil {
+			log.Fatal("[Fiye] failed to run browse", err)
+		}
+	case "history":
+		err = command.History(params)
+		if err != nil {
+			log.Fatal("[Fiye] failed to run history", err)
+		}
 	case "help":
 		command.Help()
 	default:
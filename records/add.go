@@ -8,117 +8,190 @@ import (
 	"github.com/pericles-tpt/seye/config"
 	"github.com/pericles-tpt/seye/diff"
 	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
 )
 
 /*
-Record that a new diff has been generated (i.e. .diff file)
+The steps of `RecordScan` that can fail, in order, see `SetRecordStepHook`
 */
-func AddFullScanRecord(t tree.FileTree) error {
-	// Check for existing scans for this tree
-	scanRootPath := t.BasePath
-	existingScans, ok := recs.Scans[scanRootPath]
-	if !ok {
-		recs.Scans[scanRootPath] = ScanRecords{}
-	} else if len(existingScans.Records) == 2 {
-		os.Remove(config.GetScansOutputDir() + GetLastScanFilename(scanRootPath, false))
-		existingScans.Records = existingScans.Records[:1]
-		recs.Scans[scanRootPath] = existingScans
-	}
+const (
+	StepWriteTree    = "writeTree"
+	StepWriteDiff    = "writeDiff"
+	StepRenameFiles  = "renameFiles"
+	StepFlushRecords = "flushRecords"
+)
 
-	// Write the new tree to a file
-	err := t.WriteBinary(config.GetScansOutputDir() + GetNewScanFilename(scanRootPath, false))
-	if err != nil {
-		return errorx.Decorate(err, "failed to write FileTree to local file")
-	}
+var (
+	recordStepHook func(step string) error
+)
 
-	// Create and append the new scan record
-	newRecord := Record{
-		t.Comprehensive,
-		time.Now(),
+/*
+Sets a function called before each step of `RecordScan`, which fails the step if it returns an
+error. Used by tests to check a failed scan leaves the previous scans as they were
+*/
+func SetRecordStepHook(hook func(step string) error) {
+	recordStepHook = hook
+}
+
+func runRecordStep(step string, f func() error) error {
+	if recordStepHook != nil {
+		if err := recordStepHook(step); err != nil {
+			return err
+		}
 	}
-	tmp := recs.Scans[scanRootPath]
-	tmp.Records = append(tmp.Records, newRecord)
-	recs.Scans[scanRootPath] = tmp
+	return f()
+}
+
+/*
+The temporary name a scan file is written under until it's recorded, `fsck` treats it as left by
+an interrupted write
+*/
+func stagedName(name string) string {
+	return "." + name + ".tmp"
+}
 
-	err = recs.Flush()
+/*
+The path to write the next full scan of `rootPath` to, for `RecordStagedScan` to record. Its index
+is written next to it, see `tree.IndexPath`
+*/
+func StagedTreePath(rootPath string) string {
+	scans := recs.Scans[rootPath]
+	return config.GetScansOutputDir() + stagedName(GetScanFilename(rootPath, scans.CurrScanNum, false))
+}
+
+/*
+Records a new full scan, `newTree`, and its diff from the last full scan, `d` (nil if there isn't
+one), see `RecordStagedScan`
+*/
+func RecordScan(newTree *tree.FileTree, d *diff.ScanDiff, isDiffComprehensive bool) error {
+	stagedPath := StagedTreePath(newTree.BasePath)
+	err := runRecordStep(StepWriteTree, func() error {
+		return newTree.WriteBinary(stagedPath)
+	})
 	if err != nil {
-		return errorx.Decorate(err, "failed to flush new `ScansRecord` data after adding new scan")
+		os.Remove(stagedPath)
+		os.Remove(tree.IndexPath(stagedPath))
+		return errorx.Decorate(err, "failed to write FileTree to local file")
 	}
-	return nil
+	return RecordStagedScan(newTree, stagedPath, d, isDiffComprehensive)
 }
 
 /*
-Revert the record of the LAST generated diff (i.e. .diff file)
+Records a new full scan, already written (with its index) to `stagedPath` (see `StagedTreePath`), and
+its diff from the last full scan, `d` (nil if there isn't one). `newTree` only needs its root, unless
+there's a diff. Either all of it is recorded or the records and scan files are left as they were:
+
+ 1. The diff is written (atomically) under a temporary name, like the tree
+ 2. The tree, its index and the diff are renamed to their names, which no record refers to yet
+ 3. The records are updated in a single atomic write, the scan is recorded once this succeeds
+ 4. The file of the previous last full scan is removed, since only the first and last are kept
+
+If any of the first three steps fail, the files written are removed. If seye is killed before the
+records are written, they're left unrecorded for `fsck` to remove
 */
-func RevertFullScanRecord(t tree.FileTree) error {
-	// Check for existing scans for this tree
-	scanRootPath := t.BasePath
-	existingScans, ok := recs.Scans[scanRootPath]
-	if !ok {
-		return nil
+func RecordStagedScan(newTree *tree.FileTree, stagedPath string, d *diff.ScanDiff, isDiffComprehensive bool) error {
+	var (
+		rootPath  = newTree.BasePath
+		outDir    = config.GetScansOutputDir()
+		completed = time.Now()
+		newRecs   = recs.clone()
+		scans     = newRecs.Scans[rootPath]
+		diffs     = newRecs.Diffs[rootPath]
+		treePath  = outDir + GetScanFilename(rootPath, scans.CurrScanNum, false)
+
+		// Each file written, under its temporary name and its name once it's renamed
+		staged         = []string{stagedPath, tree.IndexPath(stagedPath)}
+		final          = []string{treePath, tree.IndexPath(treePath)}
+		numRenamed     = 0
+		supersededPath = ""
+	)
+	rollback := func() {
+		for _, p := range staged[numRenamed:] {
+			os.Remove(p)
+		}
+		for _, p := range final[:numRenamed] {
+			os.Remove(p)
+		}
 	}
 
-	// Last scan completed in last 10s, assume it's the failed one, revert actions above
-	if len(existingScans.Records) > 0 {
-		lastScan := existingScans.Records[len(existingScans.Records)-1]
-		if time.Since(lastScan.TimeCompleted) < time.Duration(10*time.Second) {
-			os.Remove(config.GetScansOutputDir() + GetLastScanFilename(t.BasePath, false))
-			existingScans.Records = existingScans.Records[:len(existingScans.Records)-1]
-			recs.Scans[scanRootPath] = existingScans
+	if d != nil {
+		diffName := GetScanFilename(rootPath, len(diffs.Records), true)
+		staged = append(staged, outDir+stagedName(diffName))
+		final = append(final, outDir+diffName)
+		err := runRecordStep(StepWriteDiff, func() error {
+			return d.WriteBinary(staged[len(staged)-1], diff.NewHeader(newTree, isDiffComprehensive))
+		})
+		if err != nil {
+			rollback()
+			return errorx.Decorate(err, "failed to write ScanDiff to local file")
 		}
-		return recs.Flush()
+		diffs.Records = append(diffs.Records, Record{isDiffComprehensive, completed, getThrottle()})
+		newRecs.Diffs[rootPath] = diffs
 	}
 
-	return nil
-}
-
-/*
-Record that a new scan has been generated (i.e. .tree file)
-*/
-func AddDiffScanRecord(rootPath string, isComprehensive bool, d diff.ScanDiff) error {
-	// Write the new tree to a file
-	err := d.WriteBinary(config.GetScansOutputDir() + GetNewScanFilename(rootPath, true))
+	err := runRecordStep(StepRenameFiles, func() error {
+		for ; numRenamed < len(staged); numRenamed++ {
+			if err := os.Rename(staged[numRenamed], final[numRenamed]); err != nil {
+				return err
+			}
+		}
+		dir := outDir
+		if dir == "" {
+			dir = "."
+		}
+		return utility.SyncDir(dir)
+	})
 	if err != nil {
-		return errorx.Decorate(err, "failed to write ScanDiff to local file")
+		rollback()
+		return errorx.Decorate(err, "failed to move scan files to their names")
 	}
 
-	// Create and append the new scan record
-	newRecord := Record{
-		isComprehensive,
-		time.Now(),
+	// Only the first and last full scans are kept, this one replaces the last
+	if len(scans.Records) == 2 {
+		supersededPath = outDir + GetScanFilename(rootPath, scans.CurrScanNum-1, false)
+		scans.Records = scans.Records[:1]
 	}
-	tmp := recs.Diffs[rootPath]
-	tmp.Records = append(tmp.Records, newRecord)
-	recs.Diffs[rootPath] = tmp
+	scans.Records = append(scans.Records, Record{newTree.Comprehensive, completed, getThrottle()})
+	scans.CurrScanNum++
+	newRecs.Scans[rootPath] = scans
 
-	err = recs.Flush()
+	err = runRecordStep(StepFlushRecords, newRecs.Flush)
 	if err != nil {
+		rollback()
 		return errorx.Decorate(err, "failed to flush new `ScansRecord` data after adding new scan")
 	}
+	recs = newRecs
 
+	if supersededPath != "" {
+		os.Remove(supersededPath)
+		os.Remove(tree.IndexPath(supersededPath))
+	}
 	return nil
 }
 
 /*
-Revert the record of the LAST generated scan (i.e. .tree file)
+Copies the records, so they can be modified without changing `recs` until they're flushed
 */
-func RevertDiffScanRecord(rootPath string, isComprehensive bool, d diff.ScanDiff) error {
-	// Check for existing scans for this tree
-	existingDiffs, ok := recs.Diffs[rootPath]
-	if !ok {
-		return nil
+func (s *AllRecords) clone() *AllRecords {
+	ret := &AllRecords{
+		Scans: make(map[string]ScanRecords, len(s.Scans)),
+		Diffs: make(map[string]DiffRecords, len(s.Diffs)),
 	}
-
-	// Last scan completed in last 10s, assume it's the failed one, revert actions above
-	if len(existingDiffs.Records) > 0 {
-		lastScan := existingDiffs.Records[len(existingDiffs.Records)-1]
-		if time.Since(lastScan.TimeCompleted) < time.Duration(10*time.Second) {
-			os.Remove(config.GetScansOutputDir() + GetLastScanFilename(rootPath, false))
-			existingDiffs.Records = existingDiffs.Records[:len(existingDiffs.Records)-1]
-			recs.Diffs[rootPath] = existingDiffs
-		}
-		return recs.Flush()
+	for k, v := range s.Scans {
+		v.Records = append([]Record{}, v.Records...)
+		ret.Scans[k] = v
+	}
+	for k, v := range s.Diffs {
+		v.Records = append([]Record{}, v.Records...)
+		ret.Diffs[k] = v
 	}
+	return ret
+}
 
-	return nil
+func getThrottle() Throttle {
+	return Throttle{
+		MaxReadRate: tree.GetMaxReadRate(),
+		MaxIOPS:     tree.GetMaxIOPS(),
+		Idle:        tree.GetIdlePriority(),
+	}
 }
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package records
+
+import "os"
+
+/*
+`flock` isn't available on this platform, so the scans aren't locked
+*/
+func flock(f *os.File, exclusive, wait bool) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package records
+
+import (
+	"os"
+	"syscall"
+)
+
+func flock(f *os.File, exclusive, wait bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err == syscall.EINTR {
+			continue
+		} else if err == syscall.EWOULDBLOCK {
+			return errWouldBlock
+		}
+		return err
+	}
+}
@@ -0,0 +1,491 @@
+package records
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joomcode/errorx"
+	"github.com/pericles-tpt/seye/config"
+	"github.com/pericles-tpt/seye/diff"
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+/*
+A problem `Fsck` found with the scan files in the scans output directory, or their records
+*/
+type FsckProblem struct {
+	Name    string // The scan file, in the scans output directory, or the root path the problem is with
+	Problem string
+	Repair  string // What `Fsck` does about the problem when repairing, empty if it can't be repaired
+}
+
+var (
+	scanFilePattern = regexp.MustCompile(`^[0-9a-f]+_([0-9]+)\.(tree|diff)(\.idx)?$`)
+	// Left by `utility.WriteFileAtomic`, or `RecordScan` (with the index of a tree), if it's interrupted
+	tempFilePattern = regexp.MustCompile(`^\.(.+)\.tmp[0-9]*(\.idx)?$`)
+)
+
+type fsck struct {
+	outDir  string
+	onDisk  map[string]bool
+	newRecs *AllRecords
+	changed bool
+
+	problems []FsckProblem
+	// Run in order when repairing, before the repaired records are flushed
+	repairs []func() error
+	// Removed when repairing, after the repaired records are flushed
+	removals []string
+	// Files the repairs move or remove, so they aren't orphans
+	claimed map[string]bool
+}
+
+/*
+Checks the scan files in the scans output directory against their records:
+
+  - Every file the records refer to exists and can be read, and no other scan files exist
+  - `CurrScanNum` of each directory matches its last full scan on disk
+  - Every tree passes `tree.CheckInvariants` and has an up to date index (see `tree.CheckIndex`)
+  - Replaying the diffs of each directory onto its first full scan reproduces its last
+
+If `repair` is set, the problems that can be are repaired and the records are flushed. Files that
+can't be read are kept, renamed with a ".corrupt" suffix, rather than removed
+*/
+func Fsck(repair bool) ([]FsckProblem, error) {
+	c := &fsck{
+		outDir:  config.GetScansOutputDir(),
+		onDisk:  map[string]bool{},
+		newRecs: recs.clone(),
+		claimed: map[string]bool{},
+	}
+
+	dir := c.outDir
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errorx.Decorate(err, "failed to read scans output directory '%s'", dir)
+	}
+	for _, e := range entries {
+		if !e.IsDir() && (scanFilePattern.MatchString(e.Name()) || isScanTempFile(e.Name())) {
+			c.onDisk[e.Name()] = true
+		}
+	}
+
+	roots := []string{}
+	for k := range recs.Scans {
+		roots = append(roots, k)
+	}
+	for k := range recs.Diffs {
+		if _, ok := recs.Scans[k]; !ok {
+			roots = append(roots, k)
+		}
+	}
+	sort.Strings(roots)
+	for _, rootPath := range roots {
+		first, last := c.checkScans(rootPath)
+		diffs := c.checkDiffs(rootPath)
+		if first != nil && last != nil && len(diffs) > 0 {
+			c.checkReplay(rootPath, first, last, diffs)
+		}
+	}
+	c.checkOrphans()
+
+	if !repair {
+		return c.problems, nil
+	}
+	for _, r := range c.repairs {
+		if err = r(); err != nil {
+			return c.problems, errorx.Decorate(err, "failed to repair scan files")
+		}
+	}
+	if c.changed {
+		if err = c.newRecs.Flush(); err != nil {
+			return c.problems, errorx.Decorate(err, "failed to flush repaired records")
+		}
+		recs = c.newRecs
+	}
+	for _, name := range c.removals {
+		if err = os.Remove(c.outDir + name); err != nil && !os.IsNotExist(err) {
+			return c.problems, errorx.Decorate(err, "failed to remove '%s'", name)
+		}
+	}
+
+	return c.problems, nil
+}
+
+func (c *fsck) problem(name, problem, repair string) {
+	c.problems = append(c.problems, FsckProblem{name, problem, repair})
+}
+
+/*
+Checks the full scans of `rootPath`, returning its first and last trees for `checkReplay` (nil if it
+doesn't have both, or they had to be repaired)
+*/
+func (c *fsck) checkScans(rootPath string) (*tree.FileTree, *tree.FileTree) {
+	scans, ok := c.newRecs.Scans[rootPath]
+	if !ok || len(scans.Records) == 0 {
+		return nil, nil
+	}
+	repaired := false
+
+	if len(scans.Records) > 2 {
+		c.problem(rootPath, fmt.Sprintf("has %d full scans recorded, only the first and last are kept", len(scans.Records)), "drop the records of the full scans between them")
+		scans.Records = append(scans.Records[:1], scans.Records[len(scans.Records)-1])
+		repaired = true
+	}
+
+	firstName := scans.recordFilename(rootPath, 0)
+	first, firstErr := c.readTree(firstName)
+
+	var (
+		lastName string
+		last     *tree.FileTree
+	)
+	if len(scans.Records) == 2 {
+		var (
+			lastErr     error
+			problemName = rootPath
+			problem     string
+		)
+		if scans.CurrScanNum < 2 {
+			problem = fmt.Sprintf("`CurrScanNum` is %d, but it has 2 full scans", scans.CurrScanNum)
+		} else {
+			lastName = scans.recordFilename(rootPath, 1)
+			if last, lastErr = c.readTree(lastName); lastErr != nil {
+				problemName, problem = lastName, c.describeUnreadable(lastName, lastErr)
+				c.dropTree(lastName)
+			}
+		}
+
+		if last == nil {
+			// `CurrScanNum` may have drifted from the number of the last full scan on disk
+			if name, t := c.findLastTree(rootPath, lastName); t != nil {
+				num := scanFileNum(name)
+				c.problem(problemName, problem, fmt.Sprintf("set `CurrScanNum` to %d, so the last full scan is '%s'", num+1, name))
+				scans.CurrScanNum = num + 1
+				lastName, last = name, t
+			} else {
+				c.problem(problemName, problem, "drop the record of the last full scan")
+				scans.Records = scans.Records[:1]
+				scans.CurrScanNum = 1
+			}
+			repaired = true
+		}
+	}
+
+	if firstErr != nil {
+		c.dropTree(firstName)
+		repaired = true
+		if last == nil {
+			c.problem(firstName, c.describeUnreadable(firstName, firstErr), fmt.Sprintf("drop the records of the full scans of '%s'", rootPath))
+			delete(c.newRecs.Scans, rootPath)
+			c.changed = true
+			return nil, nil
+		}
+
+		c.problem(firstName, c.describeUnreadable(firstName, firstErr), fmt.Sprintf("make the last full scan, '%s', the first", lastName))
+		c.move(lastName, firstName)
+		c.checkTree(lastName, firstName, last)
+		scans.Records = scans.Records[1:]
+		scans.CurrScanNum = 1
+		c.newRecs.Scans[rootPath] = scans
+		c.changed = true
+		return nil, nil
+	} else if len(scans.Records) == 1 && scans.CurrScanNum != 1 {
+		c.problem(rootPath, fmt.Sprintf("`CurrScanNum` is %d, but it has 1 full scan", scans.CurrScanNum), "set `CurrScanNum` to 1")
+		scans.CurrScanNum = 1
+		repaired = true
+	}
+
+	c.checkTree(firstName, firstName, first)
+	if last != nil {
+		c.checkTree(lastName, lastName, last)
+	}
+	if repaired {
+		c.newRecs.Scans[rootPath] = scans
+		c.changed = true
+		return nil, nil
+	}
+	return first, last
+}
+
+func (c *fsck) readTree(name string) (*tree.FileTree, error) {
+	if !c.onDisk[name] {
+		return nil, os.ErrNotExist
+	}
+	t, err := tree.ReadBinary(c.outDir + name)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (c *fsck) describeUnreadable(name string, err error) string {
+	if !c.onDisk[name] {
+		return "is referenced by the records but doesn't exist"
+	}
+	return fmt.Sprintf("can't be read: %v", err)
+}
+
+/*
+Finds the tree with the highest number (other than 0, the first full scan, and `exclude`) of
+`rootPath` on disk that can be read
+*/
+func (c *fsck) findLastTree(rootPath, exclude string) (string, *tree.FileTree) {
+	var (
+		prefix   = utility.HashFilePath(rootPath) + "_"
+		bestName string
+		bestTree *tree.FileTree
+	)
+	for name := range c.onDisk {
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".tree") || name == exclude {
+			continue
+		}
+		num := scanFileNum(name)
+		if num < 1 || (bestTree != nil && num < scanFileNum(bestName)) {
+			continue
+		}
+		if t, err := c.readTree(name); err == nil {
+			bestName, bestTree = name, t
+		}
+	}
+	return bestName, bestTree
+}
+
+/*
+Checks the tree read from `name`, which `checkScans` repairs to `finalName`
+*/
+func (c *fsck) checkTree(name, finalName string, t *tree.FileTree) {
+	for _, err := range t.CheckInvariants() {
+		c.problem(name, err.Error(), "")
+	}
+
+	if err := tree.CheckIndex(c.outDir+name, t); err != nil {
+		problem := fmt.Sprintf("its index is out of date: %v", err)
+		if os.IsNotExist(err) {
+			problem = "has no index"
+		}
+		c.problem(name, problem, "rewrite it with a new index")
+		c.repairs = append(c.repairs, func() error {
+			return t.WriteBinary(c.outDir + finalName)
+		})
+	}
+}
+
+/*
+Checks the diffs of `rootPath`, returning them for `checkReplay` (nil if they had to be repaired)
+*/
+func (c *fsck) checkDiffs(rootPath string) []diff.ScanDiff {
+	diffs, ok := c.newRecs.Diffs[rootPath]
+	if !ok {
+		return nil
+	}
+
+	ret := make([]diff.ScanDiff, 0, len(diffs.Records))
+	for i := range diffs.Records {
+		name := GetScanFilename(rootPath, i, true)
+		var (
+			d   diff.ScanDiff
+			err = error(os.ErrNotExist)
+		)
+		if c.onDisk[name] {
+			d, err = diff.ReadBinary(c.outDir + name)
+		}
+		if err == nil {
+			ret = append(ret, d)
+			continue
+		}
+
+		// Later diffs can't be replayed without this one, so they're dropped too
+		repair := "drop its record"
+		if numLater := len(diffs.Records) - i - 1; numLater > 0 {
+			repair = fmt.Sprintf("drop its record, and remove the %d diffs after it, which can't be replayed without it", numLater)
+		}
+		c.problem(name, c.describeUnreadable(name, err), repair)
+		c.dropFile(name)
+		for j := i + 1; j < len(diffs.Records); j++ {
+			if later := GetScanFilename(rootPath, j, true); c.onDisk[later] {
+				c.remove(later)
+			}
+		}
+
+		diffs.Records = diffs.Records[:i]
+		if len(diffs.Records) == 0 {
+			delete(c.newRecs.Diffs, rootPath)
+		} else {
+			c.newRecs.Diffs[rootPath] = diffs
+		}
+		c.changed = true
+		return nil
+	}
+	return ret
+}
+
+/*
+Checks replaying `diffs` onto `first` reproduces `last`, `first` is modified in the process. A
+mismatch can't be repaired, since the history of the directory can't be rebuilt
+*/
+func (c *fsck) checkReplay(rootPath string, first, last *tree.FileTree, diffs []diff.ScanDiff) {
+	err := replayDiffs(first, diffs)
+	if err == nil {
+		err = compareReplayed(first, last)
+	}
+	if err != nil {
+		c.problem(rootPath, fmt.Sprintf("replaying its %d diffs onto its first full scan doesn't reproduce its last: %v", len(diffs), err), "")
+	}
+}
+
+func replayDiffs(t *tree.FileTree, diffs []diff.ScanDiff) (err error) {
+	// A diff that doesn't match the tree shouldn't stop the other checks
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to replay diffs: %v", r)
+		}
+	}()
+	for i := range diffs {
+		diff.WalkAddTreeDiff(t, &diffs[i], &t.AllHash, []diff.TreeDiff{}, []diff.FileDiff{})
+	}
+	return nil
+}
+
+/*
+Compares the directories and files of a replayed tree with the tree it should match. Unlike
+`FileTree.Equal`, hashes aren't compared, since a diff of a "shallow" scan doesn't have them
+*/
+func compareReplayed(replayed, expected *tree.FileTree) error {
+	if replayed.BasePath != expected.BasePath {
+		return fmt.Errorf("expected directory '%s', found '%s'", expected.BasePath, replayed.BasePath)
+	} else if replayed.SizeBelow != expected.SizeBelow || replayed.NumFilesBelow != expected.NumFilesBelow {
+		return fmt.Errorf("'%s' has %d bytes in %d files, expected %d bytes in %d files", replayed.BasePath, replayed.SizeBelow, replayed.NumFilesBelow, expected.SizeBelow, expected.NumFilesBelow)
+	} else if len(replayed.Files) != len(expected.Files) || len(replayed.SubTrees) != len(expected.SubTrees) {
+		return fmt.Errorf("'%s' has %d files and %d subdirectories, expected %d and %d", replayed.BasePath, len(replayed.Files), len(replayed.SubTrees), len(expected.Files), len(expected.SubTrees))
+	}
+
+	for i, f := range replayed.Files {
+		if !f.Equal(expected.Files[i]) {
+			return fmt.Errorf("'%s' doesn't match", f.Name)
+		}
+	}
+	for i := range replayed.SubTrees {
+		if err := compareReplayed(&replayed.SubTrees[i], &expected.SubTrees[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Reports the scan files on disk that no record refers to, before or after repairing
+*/
+func (c *fsck) checkOrphans() {
+	referenced := map[string]bool{}
+	for _, r := range []*AllRecords{recs, c.newRecs} {
+		for rootPath, scans := range r.Scans {
+			for i := range scans.Records {
+				name := scans.recordFilename(rootPath, i)
+				referenced[name] = true
+				referenced[tree.IndexPath(name)] = true
+			}
+		}
+		for rootPath, diffs := range r.Diffs {
+			for i := range diffs.Records {
+				referenced[GetScanFilename(rootPath, i, true)] = true
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range c.onDisk {
+		if !referenced[name] && !c.claimed[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if isScanTempFile(name) {
+			c.problem(name, "was left by an interrupted write", "remove it")
+		} else {
+			c.problem(name, "isn't referenced by any record", "remove it")
+		}
+		c.remove(name)
+	}
+}
+
+/*
+Stops using the tree `name`, see `dropFile`, and removes its index. The index is removed before the
+other repairs, since another tree may be moved to `name`
+*/
+func (c *fsck) dropTree(name string) {
+	c.dropFile(name)
+	if idx := tree.IndexPath(name); c.onDisk[idx] {
+		c.claimed[idx] = true
+		c.repairs = append(c.repairs, func() error {
+			return os.Remove(c.outDir + idx)
+		})
+	}
+}
+
+/*
+Stops using the scan file `name`, it's renamed rather than removed in case it's only unreadable by
+this version of seye
+*/
+func (c *fsck) dropFile(name string) {
+	if !c.onDisk[name] {
+		return
+	}
+	c.claimed[name] = true
+	c.repairs = append(c.repairs, func() error {
+		return os.Rename(c.outDir+name, c.outDir+name+".corrupt")
+	})
+}
+
+func (c *fsck) move(from, to string) {
+	c.claimed[from] = true
+	c.claimed[tree.IndexPath(from)] = true
+	c.repairs = append(c.repairs, func() error {
+		if err := os.Rename(c.outDir+from, c.outDir+to); err != nil {
+			return err
+		}
+		err := os.Rename(c.outDir+tree.IndexPath(from), c.outDir+tree.IndexPath(to))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (c *fsck) remove(name string) {
+	c.claimed[name] = true
+	c.removals = append(c.removals, name)
+}
+
+/*
+Whether `name` is a temporary scan file, including one being written atomically under its own
+temporary name (see `stagedName`)
+*/
+func isScanTempFile(name string) bool {
+	m := tempFilePattern.FindStringSubmatch(name)
+	return m != nil && (scanFilePattern.MatchString(m[1]) || isScanTempFile(m[1]))
+}
+
+/*
+The number of the scan file `name`, -1 if it isn't one
+*/
+func scanFileNum(name string) int {
+	m := scanFilePattern.FindStringSubmatch(name)
+	if m == nil {
+		return -1
+	}
+	num, err := strconv.Atoi(m[1])
+	if err != nil {
+		return -1
+	}
+	return num
+}
@@ -2,9 +2,12 @@ package records
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 
 	"github.com/joomcode/errorx"
+	"github.com/pericles-tpt/seye/utility"
 )
 
 var (
@@ -12,41 +15,93 @@ var (
 	scansPath = "./records.json"
 )
 
-func Load() error {
-	f, err := os.Open(scansPath)
-	if os.IsNotExist(err) {
-		f, err = os.Create(scansPath)
-		if err != nil {
-			return errorx.Decorate(err, "unable to create file `%s`, after not detected", scansPath)
-		}
-	} else if err != nil {
-		return errorx.Decorate(err, "unable to open file `%s`", scansPath)
-	}
+/*
+The previous generation of the records, kept by `Flush` for `Load` to fall back to
+*/
+func backupPath() string {
+	return scansPath + ".bak"
+}
 
-	st, err := f.Stat()
-	if err != nil {
-		return errorx.Decorate(err, "unable to stat file `%s`", scansPath)
+func Load() error {
+	loaded, err := readRecords(scansPath)
+	if err == nil {
+		recs = loaded
+		return nil
 	}
 
-	if st.Size() > 0 {
-		jd := json.NewDecoder(f)
-		return jd.Decode(&recs)
-	} else {
+	// Fall back to the last generation if the records are missing or corrupt, e.g. after a crash
+	backup, bakErr := readRecords(backupPath())
+	if os.IsNotExist(err) && os.IsNotExist(bakErr) {
 		recs = &AllRecords{
 			Scans: map[string]ScanRecords{},
 			Diffs: map[string]DiffRecords{},
 		}
+		return nil
+	} else if bakErr != nil {
+		return errorx.Decorate(err, "unable to read file `%s`, and its backup `%s` is unusable (%v)", scansPath, backupPath(), bakErr)
 	}
+	fmt.Printf("WARNING: Unable to read file `%s` (%v), using its backup `%s`, the latest scans may be missing\n", scansPath, err, backupPath())
+	recs = backup
 
 	return nil
 }
 
+/*
+Reads the records at `path`, an empty file is empty records
+*/
+func readRecords(path string) (*AllRecords, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &AllRecords{}
+	if len(b) > 0 {
+		if err = json.Unmarshal(b, ret); err != nil {
+			return nil, err
+		}
+	}
+	if ret.Scans == nil {
+		ret.Scans = map[string]ScanRecords{}
+	}
+	if ret.Diffs == nil {
+		ret.Diffs = map[string]DiffRecords{}
+	}
+	return ret, nil
+}
+
+/*
+Writes the loaded records, see `AllRecords.Flush`
+*/
+func Flush() error {
+	return recs.Flush()
+}
+
+/*
+Writes the records atomically (see `utility.WriteFileAtomic`), then keeps the previous records (if
+they were readable) as a backup
+*/
 func (s *AllRecords) Flush() error {
-	f, err := os.OpenFile(scansPath, os.O_TRUNC|os.O_WRONLY, 0600)
+	prev, err := os.ReadFile(scansPath)
+	hasPrev := err == nil && json.Valid(prev)
+
+	err = utility.WriteFileAtomic(scansPath, 0600, func(w io.Writer) error {
+		je := json.NewEncoder(w)
+		return je.Encode(*s)
+	})
 	if err != nil {
-		return errorx.Decorate(err, "unable to open file '%s' for 'flush'", scansPath)
+		return errorx.Decorate(err, "unable to write file '%s' for 'flush'", scansPath)
 	}
 
-	je := json.NewEncoder(f)
-	return je.Encode(*recs)
+	// The records are already written, so only warn if the backup can't be
+	if hasPrev {
+		err = utility.WriteFileAtomic(backupPath(), 0600, func(w io.Writer) error {
+			_, err := w.Write(prev)
+			return err
+		})
+		if err != nil {
+			fmt.Printf("WARNING: Unable to write backup file '%s': %v\n", backupPath(), err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,102 @@
+package records
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pericles-tpt/seye/config"
+)
+
+/*
+The file locked in the scans output directory, so concurrent seye processes (e.g. a scheduled scan
+and a manual one) don't race on the records and the scan file names. It contains the PID of the
+process holding it exclusively, if one is
+*/
+const lockFilename = ".seye.lock"
+
+var (
+	ErrLocked = errors.New("the scans are locked by another seye process")
+	// Returned by `flock` when the lock is held and it was asked not to wait
+	errWouldBlock = errors.New("lock is held")
+)
+
+type ScansLock struct {
+	f         *os.File
+	exclusive bool
+}
+
+/*
+Locks the scans output directory, `exclusive` for commands that write scans or records, otherwise
+shared with other readers. If `wait` is false and the lock is held, returns `ErrLocked` naming who
+holds it
+*/
+func LockScans(exclusive, wait bool) (*ScansLock, error) {
+	path := config.GetScansOutputDir() + lockFilename
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open lock file '%s': %w", path, err)
+	}
+
+	err = flock(f, exclusive, false)
+	if errors.Is(err, errWouldBlock) {
+		if !wait {
+			f.Close()
+			return nil, fmt.Errorf("%w (%s holds '%s'), run with '--wait' to wait for it to finish", ErrLocked, describeLockHolder(path), path)
+		}
+		fmt.Printf("Waiting for another seye process (%s) to release the lock on the scans...\n", describeLockHolder(path))
+		err = flock(f, exclusive, true)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to lock '%s': %w", path, err)
+	}
+
+	// Name this process to any others that fail to take the lock, only one process can hold it
+	// exclusively, so the PID is only written then
+	if exclusive {
+		if err = f.Truncate(0); err == nil {
+			_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("unable to write lock file '%s': %w", path, err)
+		}
+	}
+
+	return &ScansLock{f: f, exclusive: exclusive}, nil
+}
+
+/*
+Releases the lock, closing the file releases it even if the process exits without calling this
+*/
+func (l *ScansLock) Unlock() error {
+	if l.exclusive {
+		l.f.Truncate(0)
+	}
+	return l.f.Close()
+}
+
+/*
+Describes the processes holding the lock on `path`, that this process failed to take. If it can be
+shared, it's only held by readers, otherwise it's held exclusively by the process named in it
+*/
+func describeLockHolder(path string) string {
+	if f, err := os.Open(path); err == nil {
+		defer f.Close()
+		if flock(f, false, false) == nil {
+			return "other seye processes reading the scans"
+		}
+	}
+	return "PID " + readLockPid(path)
+}
+
+func readLockPid(path string) string {
+	b, err := os.ReadFile(path)
+	if pid := strings.TrimSpace(string(b)); err == nil && pid != "" {
+		return pid
+	}
+	return "unknown"
+}
@@ -0,0 +1,180 @@
+package records
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/joomcode/errorx"
+	"github.com/pericles-tpt/seye/config"
+	"github.com/pericles-tpt/seye/diff"
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+/*
+Moves the records and scan files of `oldRoot` to `newRoot`, rebasing every path in them, so the
+history of `oldRoot` continues under `newRoot` (e.g. after the directory is moved). Like
+`RecordScan`, either all of it is moved or, if it fails, the records and scan files are left as they
+were:
+
+ 1. The scan files are rewritten for `newRoot`, to files that no record refers to yet
+ 2. The records are updated in a single atomic write, the scans are moved once this succeeds
+ 3. The scan files of `oldRoot` are removed
+*/
+func Relocate(oldRoot, newRoot string) error {
+	var (
+		outDir         = config.GetScansOutputDir()
+		newRecs        = recs.clone()
+		scans, hasScan = newRecs.Scans[oldRoot]
+		diffs, hasDiff = newRecs.Diffs[oldRoot]
+
+		written = []string{}
+		old     = []string{}
+	)
+	if !hasScan && !hasDiff {
+		return fmt.Errorf("no scans exist for directory '%s'", oldRoot)
+	} else if oldRoot == newRoot {
+		return fmt.Errorf("the scans of '%s' are already there", oldRoot)
+	}
+	if _, ok := newRecs.Scans[newRoot]; ok {
+		return fmt.Errorf("scans already exist for directory '%s'", newRoot)
+	} else if _, ok = newRecs.Diffs[newRoot]; ok {
+		return fmt.Errorf("scans already exist for directory '%s'", newRoot)
+	}
+	rollback := func() {
+		for _, p := range written {
+			os.Remove(p)
+		}
+	}
+
+	for i := range scans.Records {
+		var (
+			oldPath = outDir + scans.recordFilename(oldRoot, i)
+			newPath = outDir + scans.recordFilename(newRoot, i)
+		)
+		written = append(written, newPath, tree.IndexPath(newPath))
+		old = append(old, oldPath, tree.IndexPath(oldPath))
+		if err := tree.RelocateTree(oldPath, newPath, oldRoot, newRoot); err != nil {
+			rollback()
+			return errorx.Decorate(err, "failed to relocate FileTree '%s'", oldPath)
+		}
+	}
+	for i, r := range diffs.Records {
+		var (
+			oldPath = outDir + GetScanFilename(oldRoot, i, true)
+			newPath = outDir + GetScanFilename(newRoot, i, true)
+		)
+		written = append(written, newPath)
+		old = append(old, oldPath)
+		if err := diff.RelocateDiff(oldPath, newPath, oldRoot, newRoot, r.TimeCompleted, r.IsComprehensive); err != nil {
+			rollback()
+			return errorx.Decorate(err, "failed to relocate ScanDiff '%s'", oldPath)
+		}
+	}
+
+	if hasScan {
+		delete(newRecs.Scans, oldRoot)
+		newRecs.Scans[newRoot] = scans
+	}
+	if hasDiff {
+		delete(newRecs.Diffs, oldRoot)
+		newRecs.Diffs[newRoot] = diffs
+	}
+	if err := newRecs.Flush(); err != nil {
+		rollback()
+		return errorx.Decorate(err, "failed to flush records after relocating scans")
+	}
+	recs = newRecs
+
+	for _, p := range old {
+		os.Remove(p)
+	}
+	return nil
+}
+
+/*
+Moves the scans of each root that isn't a canonical path (see `utility.CanonicalPath`), e.g. a
+relative path recorded by an older version of seye, to its canonical path. Returns the roots that
+were moved, mapped to their canonical paths, and those that weren't because their canonical path
+already has scans (the histories can't be merged)
+*/
+func CanonicaliseRoots() (map[string]string, []string, error) {
+	var (
+		roots     = sortedRoots()
+		isRoot    = map[string]bool{}
+		moved     = map[string]string{}
+		conflicts = []string{}
+	)
+	for _, k := range roots {
+		isRoot[k] = true
+	}
+
+	for _, rootPath := range roots {
+		canonical, err := utility.CanonicalPath(rootPath)
+		if err != nil {
+			return moved, conflicts, errorx.Decorate(err, "failed to get the canonical path of '%s'", rootPath)
+		} else if canonical == rootPath {
+			continue
+		} else if isRoot[canonical] {
+			conflicts = append(conflicts, rootPath)
+			continue
+		}
+
+		if err = Relocate(rootPath, canonical); err != nil {
+			return moved, conflicts, err
+		}
+		isRoot[canonical] = true
+		moved[rootPath] = canonical
+	}
+	return moved, conflicts, nil
+}
+
+/*
+Moves the scans of `canonical` recorded by another path, like `CanonicaliseRoots`, so a scan of it
+continues their history. Returns the root that was moved (empty if none were), and those that
+weren't because `canonical` already has scans
+*/
+func CanonicaliseRoot(canonical string) (string, []string, error) {
+	var (
+		moved      = ""
+		conflicts  = []string{}
+		_, hasScan = recs.Scans[canonical]
+		_, hasDiff = recs.Diffs[canonical]
+	)
+	for _, rootPath := range sortedRoots() {
+		if rootPath == canonical {
+			continue
+		} else if c, err := utility.CanonicalPath(rootPath); err != nil || c != canonical {
+			continue
+		} else if hasScan || hasDiff || moved != "" {
+			conflicts = append(conflicts, rootPath)
+			continue
+		}
+
+		if err := Relocate(rootPath, canonical); err != nil {
+			return moved, conflicts, err
+		}
+		moved = rootPath
+	}
+	return moved, conflicts, nil
+}
+
+/*
+The roots with scans or diffs recorded, sorted
+*/
+func sortedRoots() []string {
+	isRoot := map[string]bool{}
+	for k := range recs.Scans {
+		isRoot[k] = true
+	}
+	for k := range recs.Diffs {
+		isRoot[k] = true
+	}
+	roots := make([]string, 0, len(isRoot))
+	for k := range isRoot {
+		roots = append(roots, k)
+	}
+	sort.Strings(roots)
+	return roots
+}
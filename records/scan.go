@@ -52,29 +52,6 @@ func GetLastScanFilename(rootPath string, isDiff bool) string {
 	return path
 }
 
-/*
-Get the filename for the NEXT scan (for either a 'diff' or 'full' scan)
-*/
-func GetNewScanFilename(rootPath string, isDiff bool) string {
-	path := fmt.Sprintf("%s_", utility.HashFilePath(rootPath))
-	newNum := 0
-	if isDiff {
-		existingDiffs, ok := recs.Diffs[rootPath]
-		if ok {
-			newNum = len(existingDiffs.Records)
-		}
-		path += fmt.Sprintf("%d.diff", newNum)
-	} else {
-		scans, ok := recs.Scans[rootPath]
-		if ok {
-			newNum = scans.CurrScanNum
-		}
-		path += fmt.Sprintf("%d.tree", newNum)
-		recs.incrementCurrScanNum(rootPath)
-	}
-	return path
-}
-
 /*
 Get the filename for a scan at an index (for either a 'diff' or 'full' scan)
 */
@@ -88,11 +65,18 @@ func GetScanFilename(rootPath string, index int, isDiff bool) string {
 	return path
 }
 
-func (s *AllRecords) incrementCurrScanNum(path string) {
-	curr, ok := recs.Scans[path]
-	if ok {
-		curr.CurrScanNum++
-		recs.Scans[path] = curr
+/*
+Get the filename of the full scan of the record at `recordIndex`, only the first and last full scans
+are kept so the last record's file is numbered by `CurrScanNum`
+*/
+func GetFullScanRecordFilename(rootPath string, recordIndex int) string {
+	return recs.Scans[rootPath].recordFilename(rootPath, recordIndex)
+}
+
+func (s ScanRecords) recordFilename(rootPath string, recordIndex int) string {
+	index := 0
+	if recordIndex > 0 && s.CurrScanNum > 0 {
+		index = s.CurrScanNum - 1
 	}
-	recs.Flush()
+	return GetScanFilename(rootPath, index, false)
 }
@@ -19,4 +19,14 @@ type DiffRecords struct {
 type Record struct {
 	IsComprehensive bool
 	TimeCompleted   time.Time
+	Throttle        Throttle
+}
+
+/*
+The throttling a scan ran with, see `tree.SetMaxReadRate`, `tree.SetMaxIOPS` and `tree.SetIdlePriority`
+*/
+type Throttle struct {
+	MaxReadRate int64 // bytes per second, 0 is unlimited
+	MaxIOPS     int64 // 0 is unlimited
+	Idle        bool
 }
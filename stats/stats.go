@@ -63,20 +63,30 @@ func (w *WalkStats) UpdateLargestFiles(file BasicFile) {
 }
 
 /*
-Add a `BasicFile` record to the `DuplicateMap`
+Add a `BasicFile` record to the `DuplicateMap`, hardlinks to an inode that's
+already recorded (i.e. same `dev` and `ino`) aren't duplicates, so are skipped
 */
-func (w *WalkStats) UpdateDuplicates(fileHashBytes []byte, fileSize int64, filePath string) {
+func (w *WalkStats) UpdateDuplicates(fileHashBytes []byte, fileSize int64, filePath string, dev, ino uint64) {
 	if w.DuplicateMap == nil {
 		return
 	}
 
-	_, ok := (*w.DuplicateMap)[string(fileHashBytes)]
+	existing, ok := (*w.DuplicateMap)[string(fileHashBytes)]
 	if !ok {
-		(*w.DuplicateMap)[string(fileHashBytes)] = []BasicFile{{Path: filePath, Size: fileSize}}
+		(*w.DuplicateMap)[string(fileHashBytes)] = []BasicFile{{Path: filePath, Size: fileSize, Dev: dev, Ino: ino}}
 	} else {
-		(*w.DuplicateMap)[string(fileHashBytes)] = append((*w.DuplicateMap)[string(fileHashBytes)], BasicFile{
+		if ino != 0 {
+			for _, e := range existing {
+				if e.Dev == dev && e.Ino == ino {
+					return
+				}
+			}
+		}
+		(*w.DuplicateMap)[string(fileHashBytes)] = append(existing, BasicFile{
 			Path: filePath,
 			Size: fileSize,
+			Dev:  dev,
+			Ino:  ino,
 		})
 	}
 }
@@ -13,4 +13,8 @@ type WalkStats struct {
 type BasicFile struct {
 	Path string
 	Size int64
+
+	// Only used to exclude hardlinks from duplicates
+	Dev uint64
+	Ino uint64
 }
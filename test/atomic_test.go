@@ -0,0 +1,84 @@
+package test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/records"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+// A failed atomic write leaves the original file, and no temporary files, behind
+func TestWriteFileAtomic(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		path = filepath.Join(dir, "file")
+	)
+	if err := os.WriteFile(path, []byte("original"), 0600); err != nil {
+		t.Fatal("failed to write file", err)
+	}
+
+	err := utility.WriteFileAtomic(path, 0600, func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return errors.New("failed mid-write")
+	})
+	if err == nil {
+		t.Error("expected the write to fail")
+	}
+	if b, _ := os.ReadFile(path); string(b) != "original" {
+		t.Errorf("expected the original contents after a failed write, got: '%s'", b)
+	}
+
+	err = utility.WriteFileAtomic(path, 0600, func(w io.Writer) error {
+		_, err := w.Write([]byte("new"))
+		return err
+	})
+	if err != nil {
+		t.Fatal("failed to write file", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "new" {
+		t.Errorf("expected the new contents, got: '%s'", b)
+	}
+	if ents, _ := os.ReadDir(dir); len(ents) != 1 {
+		t.Errorf("expected only the written file in the directory, got %d entries", len(ents))
+	}
+}
+
+// Corrupt records fall back to the backup kept by the last flush
+func TestRecordsLoadFallsBackToBackup(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory", err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal("failed to change directory", err)
+	}
+	defer os.Chdir(wd)
+
+	if err = records.Load(); err != nil {
+		t.Fatal("failed to load empty records", err)
+	}
+	(*records.GetAllScansFull())["/a"] = records.ScanRecords{CurrScanNum: 1}
+	if err = records.Flush(); err != nil {
+		t.Fatal("failed to flush records", err)
+	}
+	(*records.GetAllScansFull())["/b"] = records.ScanRecords{CurrScanNum: 1}
+	if err = records.Flush(); err != nil {
+		t.Fatal("failed to flush records", err)
+	}
+
+	// Simulate a torn write of the records
+	if err = os.WriteFile("records.json", []byte(`{"scans": {"/a": {`), 0600); err != nil {
+		t.Fatal("failed to corrupt records", err)
+	}
+	if err = records.Load(); err != nil {
+		t.Fatal("expected records to load from the backup, err: ", err)
+	}
+	scans := *records.GetAllScansFull()
+	if _, ok := scans["/a"]; !ok || len(scans) != 1 {
+		t.Errorf("expected the records of the previous flush, got: %v", scans)
+	}
+}
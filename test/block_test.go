@@ -0,0 +1,108 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+// Reading a subtree through the index only reads the block it's in, the others can be unreadable
+func TestIndexedSubtreeReadsOnlyItsBlock(t *testing.T) {
+	defer tree.SetDirsPerBlock(tree.GetDirsPerBlock())
+	tree.SetDirsPerBlock(2)
+	defer utility.SetCompressionLevel(utility.GetCompressionLevel())
+
+	root := tree.FileTree{BasePath: "/r"}
+	for i := 0; i < 10; i++ {
+		st := tree.FileTree{BasePath: fmt.Sprintf("/r/d%d", i), Depth: 1, NumFilesDirect: 1, NumFilesBelow: 1}
+		st.Files = []tree.File{{Name: st.BasePath + "/f", Hash: utility.InitialiseHashLocation(), Size: int64(i)}}
+		root.SubTrees = append(root.SubTrees, st)
+	}
+	// Directories are written in pre-order, 2 to a block: "/r" and "d0", "d1" and "d2", ...
+	var (
+		target      = "/r/d5"
+		targetBlock = 3
+	)
+
+	// Uncompressed trees have no blocks to skip decompressing
+	for _, level := range []int{gzip.BestSpeed, gzip.DefaultCompression} {
+		utility.SetCompressionLevel(level)
+		path := filepath.Join(t.TempDir(), "scan.tree")
+		if err := root.WriteBinary(path); err != nil {
+			t.Fatal("failed to write tree", err)
+		}
+		it, err := tree.OpenIndexed(path)
+		if err != nil {
+			t.Fatal("failed to open indexed tree", err)
+		}
+
+		// The header is compressed on its own, before the blocks
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal("failed to read tree file", err)
+		}
+		members := gzipMembers(t, b)
+		if len(members) != 7 {
+			t.Fatalf("level %d: expected the tree to be written as a header and 6 blocks, got %d compressed members", level, len(members))
+		}
+		blocks := members[1:]
+
+		// Overwrite every block but the target's, leaving the header
+		for i, start := range blocks {
+			if i == targetBlock {
+				continue
+			}
+			end := int64(len(b))
+			if i+1 < len(blocks) {
+				end = blocks[i+1]
+			}
+			for j := start; j < end; j++ {
+				b[j] = 0xff
+			}
+		}
+		if err = os.WriteFile(path, b, 0600); err != nil {
+			t.Fatal("failed to overwrite tree file", err)
+		}
+
+		st, err := it.Subtree(target)
+		if err != nil {
+			t.Fatalf("level %d: failed to read subtree from its block: %v", level, err)
+		}
+		if st.BasePath != target || len(st.Files) != 1 || st.Files[0].Name != target+"/f" || st.Files[0].Size != 5 {
+			t.Errorf("level %d: subtree read NOT as expected: %+v", level, st)
+		}
+		if _, err = tree.ReadBinary(path); err == nil {
+			t.Errorf("level %d: expected the overwritten blocks to be unreadable", level)
+		}
+	}
+}
+
+/*
+Gets the offset of each `gzip` member in `b`
+*/
+func gzipMembers(t *testing.T, b []byte) []int64 {
+	var (
+		offsets = []int64{}
+		r       = bytes.NewReader(b)
+	)
+	// A `bytes.Reader` is read a byte at a time, so it's left at the end of each member
+	for r.Len() > 0 {
+		offsets = append(offsets, int64(len(b)-r.Len()))
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal("failed to read compressed member", err)
+		}
+		zr.Multistream(false)
+		if _, err = io.Copy(io.Discard, zr); err != nil {
+			t.Fatal("failed to decompress member", err)
+		}
+	}
+	return offsets
+}
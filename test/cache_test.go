@@ -0,0 +1,39 @@
+//go:build linux
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pericles-tpt/seye/tree"
+)
+
+func getAtime(t *testing.T, path string) time.Time {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		t.Fatal("failed to stat file", err)
+	}
+	return time.Unix(st.Atim.Unix())
+}
+
+// Hashing a file doesn't update its atime, even when it would be with "relatime" (i.e. atime < mtime)
+func TestHashingKeepsAtime(t *testing.T) {
+	root := t.TempDir()
+	filePath := filepath.Join(root, "f")
+	if err := os.WriteFile(filePath, []byte("contents"), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	oldAtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(filePath, oldAtime, time.Now()); err != nil {
+		t.Fatal("failed to set file times", err)
+	}
+
+	tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	if atime := getAtime(t, filePath); !atime.Equal(oldAtime) {
+		t.Errorf("expected atime of '%s' to still be %s, got %s", filePath, oldAtime, atime)
+	}
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/diff"
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+// Files above the threshold get a root hash of their chunks' hashes, smaller files are hashed as usual
+func TestChunkedHash(t *testing.T) {
+	root := t.TempDir()
+	contents := make([]byte, 1000)
+	for i := range contents {
+		contents[i] = byte(i)
+	}
+	if err := os.WriteFile(filepath.Join(root, "large"), contents, 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "small"), contents[:100], 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+
+	tree.SetChunkedHashing(500, 300)
+	defer tree.SetChunkedHashing(1<<30, 64<<20)
+	scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+
+	expChunkHashes := []byte{}
+	for offset := 0; offset < len(contents); offset += 300 {
+		end := offset + 300
+		if end > len(contents) {
+			end = len(contents)
+		}
+		chunkHash := sha256.Sum256(contents[offset:end])
+		expChunkHashes = append(expChunkHashes, chunkHash[:]...)
+	}
+	expRoot := sha256.Sum256(expChunkHashes)
+	expSmall := sha256.Sum256(contents[:100])
+
+	for _, f := range scanned.Files {
+		var (
+			hash    = scanned.AllHash[f.Hash.HashOffset : f.Hash.HashOffset+f.Hash.HashLength]
+			expType = utility.SHA256
+			expHash = expSmall[:]
+		)
+		if filepath.Base(f.Name) == "large" {
+			expType = utility.SHA256Chunked
+			expHash = expRoot[:]
+		}
+		if f.Hash.Type != expType || !bytes.Equal(hash, expHash) {
+			t.Errorf("expected '%s' to have hash type %d, %x, got %d, %x", f.Name, expType, expHash, f.Hash.Type, hash)
+		}
+	}
+}
+
+// The chunked hashing parameters are stored with the scan, and hashes are only compared between scans
+// hashed with the same parameters
+func TestChunkedHashParams(t *testing.T) {
+	root := t.TempDir()
+	filePath := filepath.Join(root, "large")
+	contents := make([]byte, 1000)
+	if err := os.WriteFile(filePath, contents, 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal("failed to stat file", err)
+	}
+	defer tree.SetChunkedHashing(1<<30, 64<<20)
+
+	tree.SetChunkedHashing(500, 300)
+	scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	path := filepath.Join(t.TempDir(), "scan.tree")
+	if err = scanned.WriteBinary(path); err != nil {
+		t.Fatal("failed to write tree", err)
+	}
+	read, err := tree.ReadBinary(path)
+	if err != nil {
+		t.Fatal("failed to read tree", err)
+	}
+	if read.HashChunkThreshold != 500 || read.HashChunkSize != 300 {
+		t.Errorf("expected the chunked hashing parameters to be read back, got %d, %d", read.HashChunkThreshold, read.HashChunkSize)
+	}
+
+	// The same contents hashed in different chunks has a different hash, but isn't a change
+	tree.SetChunkedHashing(500, 200)
+	rechunked := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	if d := diff.CompareTrees(&read, rechunked); len(d.Files) != 0 {
+		t.Errorf("expected no changes between scans with different chunk sizes, got %+v", d.Files)
+	}
+
+	// With the same chunks, the hashes are compared, so a change that keeps the size and mtime is found
+	contents[0] = 1
+	if err = os.WriteFile(filePath, contents, 0600); err != nil {
+		t.Fatal("failed to change file", err)
+	}
+	if err = os.Chtimes(filePath, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal("failed to touch file", err)
+	}
+	changed := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	if d := diff.CompareTrees(rechunked, changed); len(d.Files) != 1 {
+		t.Errorf("expected '%s' to be changed, got %+v", filePath, d.Files)
+	}
+}
+
+// Scans from before chunked hashing hashed every file whole, so they're read with it disabled
+func TestChunkedHashParamsLegacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.tree")
+	writeLegacyGob(t, path, legacyFileTree{BasePath: "/root"})
+
+	read, err := tree.ReadBinary(path)
+	if err != nil {
+		t.Fatal("failed to read tree", err)
+	}
+	if read.HashChunkThreshold != 0 {
+		t.Errorf("expected chunked hashing to be disabled for a scan without a header, got a threshold of %d", read.HashChunkThreshold)
+	}
+}
@@ -0,0 +1,62 @@
+package test
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+// Trees are written compressed, and trees written uncompressed (by older versions) still read back
+func TestCompressedTreeRoundTrip(t *testing.T) {
+	root := makeFormatTestDir(t)
+	scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	defer utility.SetCompressionLevel(utility.GetCompressionLevel())
+
+	var (
+		dir    = t.TempDir()
+		levels = map[string]int{
+			"compressed.tree":   gzip.BestCompression,
+			"uncompressed.tree": gzip.NoCompression,
+		}
+	)
+	for name, level := range levels {
+		utility.SetCompressionLevel(level)
+		path := filepath.Join(dir, name)
+		if err := scanned.WriteBinary(path); err != nil {
+			t.Fatal("failed to write tree", err)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal("failed to read file", err)
+		}
+		isGzip := len(b) > 2 && b[0] == 0x1f && b[1] == 0x8b
+		if isGzip != (level != gzip.NoCompression) {
+			t.Errorf("'%s' compressed: %v, expected: %v", name, isGzip, level != gzip.NoCompression)
+		}
+
+		read, err := tree.ReadBinary(path)
+		if err != nil {
+			t.Fatal("failed to read tree", err)
+		}
+		if notEqualReason := scanned.Equal(read); notEqualReason != nil {
+			t.Errorf("'%s' read back NOT equal to tree written, reason: %v", name, notEqualReason)
+		}
+	}
+
+	uncompressedSize, err := utility.GetUncompressedSize(filepath.Join(dir, "compressed.tree"))
+	if err != nil {
+		t.Fatal("failed to get uncompressed size", err)
+	}
+	st, err := os.Stat(filepath.Join(dir, "uncompressed.tree"))
+	if err != nil {
+		t.Fatal("failed to stat file", err)
+	}
+	if uncompressedSize != st.Size() {
+		t.Errorf("expected uncompressed size %d, got %d", st.Size(), uncompressedSize)
+	}
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/pericles-tpt/seye/diff"
@@ -336,3 +337,159 @@ func TestAddDiffToMatchScan(t *testing.T) {
 		t.Error("changed found between same `originalPlusDiff` and `fileAddedTree` when there should be none: ", err)
 	}
 }
+
+// Touching a file, without changing its contents, is only a metadata change
+func TestDiffTouchIsMetadataOnly(t *testing.T) {
+	root := t.TempDir()
+	filePath := root + "/f"
+	if err := os.WriteFile(filePath, []byte("contents"), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+
+	originalTree := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	if err := os.Chtimes(filePath, time.Unix(0, 0), time.Unix(0, 0)); err != nil {
+		t.Fatal("failed to touch file", err)
+	}
+	touchedTree := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+
+	metadataTypes, err := diff.ParseDiffTypes("metadataModified")
+	if err != nil {
+		t.Fatal("failed to parse diff type", err)
+	}
+	d := diff.CompareTrees(originalTree, touchedTree)
+	if fd, ok := d.Files[filePath]; !ok || fd.Type != metadataTypes[0] {
+		t.Errorf("expected a metadata change for '%s', got %+v", filePath, d.Files)
+	}
+
+	filtered := d.WithoutTypes(metadataTypes)
+	if len(filtered.Files) != 0 {
+		t.Errorf("expected no file diffs when ignoring metadata changes, got %+v", filtered.Files)
+	}
+}
+
+// A new file with the same contents as an existing file is a copy of it
+func TestDiffCopiedFile(t *testing.T) {
+	root := t.TempDir()
+	sourcePath := root + "/source"
+	if err := os.WriteFile(sourcePath, []byte("contents"), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+
+	originalTree := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	copyPath := root + "/copy"
+	if err := os.WriteFile(copyPath, []byte("contents"), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	copiedTree := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+
+	copiedTypes, err := diff.ParseDiffTypes("copied")
+	if err != nil {
+		t.Fatal("failed to parse diff type", err)
+	}
+	d := diff.CompareTrees(originalTree, copiedTree)
+	fd, ok := d.Files[copyPath]
+	if !ok || fd.Type != copiedTypes[0] || len(fd.CopiedFrom) != 1 || fd.CopiedFrom[0] != sourcePath {
+		t.Errorf("expected '%s' to be copied from '%s', got %+v", copyPath, sourcePath, d.Files)
+	}
+}
+
+// A file moved to another directory is matched by its inode, rather than being removed and added (or
+// copied)
+func TestDiffMovedFileShallow(t *testing.T) {
+	root := createMoveTestDir(t)
+	originalTree := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	if err := os.Rename(root+"/x/a", root+"/y/a"); err != nil {
+		t.Fatal("failed to move file", err)
+	}
+	movedTree := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+
+	renamedTypes, err := diff.ParseDiffTypes("renamed")
+	if err != nil {
+		t.Fatal("failed to parse diff type", err)
+	}
+	d := diff.CompareTrees(originalTree, movedTree)
+	if fd, ok := d.Files[root+"/x/a"]; !ok || fd.Type != renamedTypes[0] || fd.NewerName != root+"/y/a" || fd.RenameConfidence != 1.0 {
+		t.Errorf("expected '%s' to be moved to '%s', got %+v", root+"/x/a", root+"/y/a", d.Files)
+	}
+	if _, ok := d.Files[root+"/y/a"]; ok {
+		t.Errorf("expected moved file '%s' not to be added or copied, got %+v", root+"/y/a", d.Files)
+	}
+	if len(d.Files) != 1 {
+		t.Errorf("expected only the move, `x/b` shouldn't be a rename of `x/a`, got %+v", d.Files)
+	}
+	checkMovedFileReplayed(t, root, originalTree, movedTree, &d)
+}
+
+// A file moved to another directory and changed is still matched by its inode
+func TestDiffMovedAndChangedFile(t *testing.T) {
+	root := createMoveTestDir(t)
+	originalTree := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	if err := os.Rename(root+"/x/a", root+"/y/a"); err != nil {
+		t.Fatal("failed to move file", err)
+	}
+	f, err := os.OpenFile(root+"/y/a", os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal("failed to open file", err)
+	}
+	if _, err = f.WriteString(", changed"); err != nil {
+		t.Fatal("failed to change file", err)
+	}
+	f.Close()
+	movedTree := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+
+	modifiedTypes, err := diff.ParseDiffTypes("contentModified")
+	if err != nil {
+		t.Fatal("failed to parse diff type", err)
+	}
+	d := diff.CompareTrees(originalTree, movedTree)
+	if fd, ok := d.Files[root+"/x/a"]; !ok || fd.Type != modifiedTypes[0] || fd.NewerName != root+"/y/a" || fd.RenameConfidence != 1.0 || fd.SizeDiff != int64(len(", changed")) {
+		t.Errorf("expected '%s' to be moved to '%s' and changed, got %+v", root+"/x/a", root+"/y/a", d.Files)
+	}
+	if _, ok := d.Files[root+"/y/a"]; ok {
+		t.Errorf("expected moved file '%s' not to be added or copied, got %+v", root+"/y/a", d.Files)
+	}
+	if len(d.Files) != 1 {
+		t.Errorf("expected only the move, got %+v", d.Files)
+	}
+	checkMovedFileReplayed(t, root, originalTree, movedTree, &d)
+}
+
+// Creates `x/a` and `x/b`, with different contents, and an empty directory `y` to move `x/a` to
+func createMoveTestDir(t *testing.T) string {
+	root := t.TempDir()
+	for _, d := range []string{"/x", "/y"} {
+		if err := os.Mkdir(root+d, 0700); err != nil {
+			t.Fatal("failed to create dir", err)
+		}
+	}
+	for _, f := range []string{"/x/a", "/x/b"} {
+		if err := os.WriteFile(root+f, []byte("contents of "+f), 0600); err != nil {
+			t.Fatal("failed to create file", err)
+		}
+	}
+	return root
+}
+
+// Checks the file `x/a`, moved to `y/a`, is only in `y` after replaying the diff, and is the same as
+// in the newer tree
+func checkMovedFileReplayed(t *testing.T, root string, originalTree, movedTree *tree.FileTree, d *diff.ScanDiff) {
+	var movedFile tree.File
+	for _, st := range movedTree.SubTrees {
+		if st.BasePath == root+"/y" && len(st.Files) == 1 {
+			movedFile = st.Files[0]
+		}
+	}
+
+	originalPlusDiff := originalTree.DeepCopy()
+	_ = diff.WalkAddTreeDiff(&originalPlusDiff, d, &originalPlusDiff.AllHash, []diff.TreeDiff{}, []diff.FileDiff{})
+	for _, st := range originalPlusDiff.SubTrees {
+		if st.BasePath == root+"/y" && (len(st.Files) != 1 || !st.Files[0].Equal(movedFile)) {
+			t.Errorf("expected moved file in '%s' to be %+v, got %+v", st.BasePath, movedFile, st.Files)
+		} else if st.BasePath == root+"/x" && (len(st.Files) != 1 || st.Files[0].Name != root+"/x/b") {
+			t.Errorf("expected moved file to be removed from '%s', got %+v", st.BasePath, st.Files)
+		}
+	}
+	if originalPlusDiff.SizeBelow != movedTree.SizeBelow {
+		t.Errorf("expected replayed size %d, got %d", movedTree.SizeBelow, originalPlusDiff.SizeBelow)
+	}
+}
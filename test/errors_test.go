@@ -0,0 +1,55 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/diff"
+	"github.com/pericles-tpt/seye/tree"
+)
+
+// An unreadable directory is recorded as a "readdir" error with the "permission" class
+func TestUnreadableDirError(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions aren't enforced for root")
+	}
+
+	root := t.TempDir()
+	dirPath := filepath.Join(root, "locked")
+	if err := os.Mkdir(dirPath, 0000); err != nil {
+		t.Fatal("failed to create directory", err)
+	}
+	defer os.Chmod(dirPath, 0755)
+
+	errs := tree.WalkGenerateTreeRecursive(root, 0, false, nil).GetErrors()
+	if len(errs) != 1 || errs[0].Path != dirPath || errs[0].Op != tree.OpReadDir || errs[0].Class != tree.ClassPermission {
+		t.Errorf("expected a permission error reading '%s', got %+v", dirPath, errs)
+	}
+}
+
+// Errors only in the newer tree have appeared, errors only in the older tree were resolved
+func TestDiffErrorChanges(t *testing.T) {
+	var (
+		stillFailing = tree.WalkError{Path: "/root/a", Op: tree.OpReadDir, Class: tree.ClassPermission, Message: "permission denied"}
+		fixed        = tree.WalkError{Path: "/root/b", Op: tree.OpReadDir, Class: tree.ClassPermission, Message: "permission denied"}
+		broken       = tree.WalkError{Path: "/root/c", Op: tree.OpOpen, Class: tree.ClassIO, Message: "input/output error"}
+	)
+	older := tree.FileTree{
+		BasePath: "/root",
+		Errors:   []tree.WalkError{stillFailing, fixed},
+	}
+	newer := tree.FileTree{
+		BasePath: "/root",
+		Errors:   []tree.WalkError{stillFailing},
+		Files:    []tree.File{{Name: "/root/c", WalkErr: &broken}},
+	}
+
+	d := diff.CompareTrees(&older, &newer)
+	if len(d.NewErrors) != 1 || d.NewErrors[0] != broken {
+		t.Errorf("expected only '%s' to be a new error, got %+v", broken.Path, d.NewErrors)
+	}
+	if len(d.ResolvedErrors) != 1 || d.ResolvedErrors[0] != fixed {
+		t.Errorf("expected only '%s' to be a resolved error, got %+v", fixed.Path, d.ResolvedErrors)
+	}
+}
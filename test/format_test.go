@@ -0,0 +1,237 @@
+package test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pericles-tpt/seye/command"
+	"github.com/pericles-tpt/seye/config"
+	"github.com/pericles-tpt/seye/records"
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+func makeFormatTestDir(t *testing.T) string {
+	root := t.TempDir()
+	for _, d := range []string{"a/b", "a/c", "d"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal("failed to create directory", err)
+		}
+	}
+	for i, f := range []string{"f", "a/f", "a/b/f", "a/b/g", "d/f"} {
+		if err := os.WriteFile(filepath.Join(root, f), make([]byte, i*100), 0600); err != nil {
+			t.Fatal("failed to create file", err)
+		}
+	}
+	return root
+}
+
+// A tree written in the compact format reads back the same, and can be read one node at a time
+func TestCompactFormatRoundTrip(t *testing.T) {
+	root := makeFormatTestDir(t)
+	scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+
+	path := filepath.Join(t.TempDir(), "scan.tree")
+	if err := scanned.WriteBinary(path); err != nil {
+		t.Fatal("failed to write tree", err)
+	}
+	read, err := tree.ReadBinary(path)
+	if err != nil {
+		t.Fatal("failed to read tree", err)
+	}
+	if notEqualReason := scanned.Equal(read); notEqualReason != nil {
+		t.Error("tree read back NOT equal to tree written, reason: ", notEqualReason)
+	}
+
+	tr, err := tree.OpenTreeReader(path)
+	if err != nil {
+		t.Fatal("failed to open tree reader", err)
+	}
+	defer tr.Close()
+	numNodes := 0
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal("failed to read node", err)
+		}
+		numNodes++
+	}
+	if numNodes != 5 {
+		t.Errorf("expected 5 nodes in the tree, got %d", numNodes)
+	}
+}
+
+// A tree written as a `gob` by older versions of seye is migrated to the compact format
+func TestConvertGobTree(t *testing.T) {
+	var (
+		lastModified = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		allHash      = append(bytes.Repeat([]byte{0xaa}, 32), bytes.Repeat([]byte{0xbb}, 32)...)
+	)
+	old := legacyFileTree{
+		Comprehensive: true,
+		BasePath:      "/root",
+		Files: []legacyFile{
+			{Name: "/root/f", Hash: utility.HashLocation{HashOffset: 0, HashLength: 32}, Size: 3, LastModified: lastModified},
+		},
+		LastModifiedDirect: lastModified,
+		SizeDirect:         3,
+		NumFilesDirect:     1,
+		LastModifiedBelow:  lastModified,
+		SizeBelow:          5,
+		NumFilesBelow:      2,
+		SubTrees: []legacyFileTree{{
+			Comprehensive: true,
+			BasePath:      "/root/a",
+			Depth:         1,
+			Files: []legacyFile{
+				{Name: "/root/a/g", Hash: utility.HashLocation{HashOffset: 32, HashLength: 32}, Size: 2, LastModified: lastModified},
+			},
+			LastModifiedDirect: lastModified,
+			SizeDirect:         2,
+			NumFilesDirect:     1,
+			LastModifiedBelow:  lastModified,
+			SizeBelow:          2,
+			NumFilesBelow:      1,
+		}},
+		AllHash: allHash,
+	}
+	path := filepath.Join(t.TempDir(), "scan.tree")
+	writeLegacyGob(t, path, old)
+
+	converted, err := tree.MigrateTree(path)
+	if err != nil || !converted {
+		t.Fatalf("expected '%s' to be converted, got: %v, err: %v", path, converted, err)
+	}
+	tr, err := tree.OpenTreeReader(path)
+	if err != nil {
+		t.Fatal("expected converted file to be in the compact format, err: ", err)
+	}
+	tr.Close()
+
+	read, err := tree.ReadBinary(path)
+	if err != nil {
+		t.Fatal("failed to read tree", err)
+	}
+	if read.BasePath != old.BasePath || !read.Comprehensive || read.SizeBelow != old.SizeBelow || read.NumFilesBelow != old.NumFilesBelow ||
+		len(read.Files) != 1 || read.Files[0].Name != "/root/f" || read.Files[0].Size != 3 || !read.Files[0].LastModified.Equal(lastModified) ||
+		len(read.SubTrees) != 1 || read.SubTrees[0].BasePath != "/root/a" || len(read.SubTrees[0].Files) != 1 || read.SubTrees[0].Files[0].Name != "/root/a/g" {
+		t.Fatalf("converted tree NOT as expected: %+v", read)
+	}
+	for _, f := range []tree.File{read.Files[0], read.SubTrees[0].Files[0]} {
+		hl := f.Hash
+		if hl.HashOffset < 0 || hl.HashOffset+hl.HashLength > len(read.AllHash) {
+			t.Fatalf("expected '%s' to have a hash, got %+v", f.Name, hl)
+		}
+		expected := allHash[:32]
+		if f.Name == "/root/a/g" {
+			expected = allHash[32:]
+		}
+		if !bytes.Equal(read.AllHash[hl.HashOffset:hl.HashOffset+hl.HashLength], expected) {
+			t.Errorf("hash of '%s' NOT the same as the `gob` tree's", f.Name)
+		}
+	}
+	if converted, _ := tree.MigrateTree(path); converted {
+		t.Error("expected an already converted file not to be converted again")
+	}
+}
+
+// A tree written as it's walked reads back the same as a tree walked in memory, with a header and
+// index for the whole tree
+func TestWalkWriteTree(t *testing.T) {
+	root := makeFormatTestDir(t)
+	if err := os.Link(filepath.Join(root, "a/b/f"), filepath.Join(root, "d/link")); err != nil {
+		t.Fatal("failed to create hardlink", err)
+	}
+
+	for _, isComprehensive := range []bool{true, false} {
+		scanned := tree.WalkGenerateTreeRecursive(root, 0, isComprehensive, nil)
+
+		path := filepath.Join(t.TempDir(), "scan.tree")
+		written, numUnstable, err := tree.WalkWriteTree(root, isComprehensive, nil, path)
+		if err != nil {
+			t.Fatal("failed to walk and write tree", err)
+		} else if numUnstable != 0 {
+			t.Errorf("comprehensive %v: expected no unstable files, got %d", isComprehensive, numUnstable)
+		}
+		if written.SizeBelow != scanned.SizeBelow || written.NumFilesBelow != scanned.NumFilesBelow || len(written.Files) > 0 {
+			t.Errorf("comprehensive %v: root returned NOT as expected: %+v", isComprehensive, written)
+		}
+
+		read, err := tree.ReadBinary(path)
+		if err != nil {
+			t.Fatal("failed to read tree", err)
+		}
+		if notEqualReason := scanned.Equal(read); notEqualReason != nil {
+			t.Errorf("comprehensive %v: tree written as it's walked NOT equal to the tree walked, reason: %v", isComprehensive, notEqualReason)
+		}
+
+		tr, err := tree.OpenTreeReader(path)
+		if err != nil {
+			t.Fatal("failed to open tree reader", err)
+		}
+		tr.Close()
+		if h := tr.Header; h == nil || h.NumDirs != 5 || h.NumFiles != 6 || h.Size != scanned.SizeBelow || h.Comprehensive != isComprehensive {
+			t.Errorf("comprehensive %v: header NOT as expected: %+v", isComprehensive, tr.Header)
+		}
+		if err = tree.CheckIndex(path, &read); err != nil {
+			t.Errorf("comprehensive %v: expected the index to be up to date, err: %v", isComprehensive, err)
+		}
+	}
+}
+
+// A scan writes its tree as it's walked, and is recorded (with its diff) the same as a tree walked in
+// memory
+func TestScanWritesTreeAsWalked(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory", err)
+	}
+	scansDir := t.TempDir()
+	if err = os.Chdir(scansDir); err != nil {
+		t.Fatal("failed to change directory", err)
+	}
+	defer os.Chdir(wd)
+	if err = config.Load(); err != nil {
+		t.Fatal("failed to load config", err)
+	}
+	if err = records.Load(); err != nil {
+		t.Fatal("failed to load records", err)
+	}
+
+	root, err := filepath.EvalSymlinks(makeFormatTestDir(t))
+	if err != nil {
+		t.Fatal("failed to resolve temp dir", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = command.Scan([]string{root, "-c"}); err != nil {
+			t.Fatal("failed to scan", err)
+		}
+		if err = os.WriteFile(filepath.Join(root, "a/new"), make([]byte, 10+i), 0600); err != nil {
+			t.Fatal("failed to create file", err)
+		}
+	}
+
+	scans := records.GetScansFull(root)
+	if scans == nil || len(scans.Records) != 2 || records.GetScansDiff(root) == nil {
+		t.Fatalf("expected 2 full scans and a diff of '%s', got %+v", root, scans)
+	}
+	last, err := tree.ReadBinary(records.GetLastScanFilename(root, false))
+	if err != nil {
+		t.Fatal("failed to read last scan", err)
+	}
+	if err = tree.CheckIndex(records.GetLastScanFilename(root, false), &last); err != nil {
+		t.Error("expected the index of the last scan to be up to date", err)
+	}
+	if !last.Comprehensive || last.NumFilesBelow != 6 {
+		t.Errorf("last scan NOT as expected: %+v", last)
+	}
+	if problems, err := records.Fsck(false); err != nil || len(problems) > 0 {
+		t.Errorf("expected no problems with the scans, got %+v (%v)", problems, err)
+	}
+}
@@ -0,0 +1,224 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/command"
+	"github.com/pericles-tpt/seye/config"
+	"github.com/pericles-tpt/seye/diff"
+	"github.com/pericles-tpt/seye/records"
+	"github.com/pericles-tpt/seye/tree"
+)
+
+func countRepairable(problems []records.FsckProblem) int {
+	n := 0
+	for _, p := range problems {
+		if p.Repair != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Orphaned files, missing indexes and records of missing scans are found by `Fsck`, and repaired
+func TestFsckRepair(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory", err)
+	}
+	scansDir := t.TempDir()
+	if err = os.Chdir(scansDir); err != nil {
+		t.Fatal("failed to change directory", err)
+	}
+	defer os.Chdir(wd)
+	if err = config.Load(); err != nil {
+		t.Fatal("failed to load config", err)
+	}
+	if err = records.Load(); err != nil {
+		t.Fatal("failed to load records", err)
+	}
+
+	root := makeFormatTestDir(t)
+	var last *tree.FileTree
+	for i := 0; i < 3; i++ {
+		scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+		var d *diff.ScanDiff
+		if last != nil {
+			sd := diff.CompareTrees(last, scanned)
+			d = &sd
+		}
+		if err = records.RecordScan(scanned, d, true); err != nil {
+			t.Fatal("failed to record scan", err)
+		}
+		last = scanned
+	}
+
+	problems, err := records.Fsck(false)
+	if err != nil {
+		t.Fatal("failed to check scans", err)
+	} else if len(problems) > 0 {
+		t.Errorf("expected no problems after recording scans, got %+v", problems)
+	}
+
+	// An orphaned diff, a temporary file and a missing index
+	var (
+		lastTree = records.GetLastScanFilename(root, false)
+		orphan   = records.GetScanFilename(root, 5, true)
+		temp     = "." + records.GetScanFilename(root, 3, false) + ".tmp123"
+	)
+	for _, name := range []string{orphan, temp} {
+		if err = os.WriteFile(name, []byte("garbage"), 0600); err != nil {
+			t.Fatal("failed to create file", err)
+		}
+	}
+	if err = os.Remove(tree.IndexPath(lastTree)); err != nil {
+		t.Fatal("failed to remove index", err)
+	}
+	before := readDirContents(t, scansDir)
+	if problems, err = records.Fsck(false); err != nil {
+		t.Fatal("failed to check scans", err)
+	} else if countRepairable(problems) != 3 {
+		t.Errorf("expected 3 repairable problems, got %+v", problems)
+	}
+	if after := readDirContents(t, scansDir); len(after) != len(before) {
+		t.Errorf("expected checking without repairing to leave %d files, got %d", len(before), len(after))
+	}
+
+	if _, err = records.Fsck(true); err != nil {
+		t.Fatal("failed to repair scans", err)
+	}
+	if problems, err = records.Fsck(false); err != nil {
+		t.Fatal("failed to check scans", err)
+	} else if len(problems) > 0 {
+		t.Errorf("expected no problems after repairing, got %+v", problems)
+	}
+	for _, name := range []string{orphan, temp} {
+		if _, err = os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("expected '%s' to be removed", name)
+		}
+	}
+	if _, err = os.Stat(tree.IndexPath(lastTree)); err != nil {
+		t.Errorf("expected the index of '%s' to be rebuilt", lastTree)
+	}
+
+	// The record of a missing last scan is dropped
+	if err = os.Remove(filepath.Join(scansDir, lastTree)); err != nil {
+		t.Fatal("failed to remove tree", err)
+	}
+	if _, err = records.Fsck(true); err != nil {
+		t.Fatal("failed to repair scans", err)
+	}
+	if scans := records.GetScansFull(root); scans == nil || scans.CurrScanNum != 1 || len(scans.Records) != 1 {
+		t.Errorf("expected 1 record after the last scan was removed, got %+v", scans)
+	}
+	if problems, err = records.Fsck(false); err != nil {
+		t.Fatal("failed to check scans", err)
+	} else if len(problems) > 0 {
+		t.Errorf("expected no problems after repairing, got %+v", problems)
+	}
+}
+
+// Replaying real changes between scans, i.e. nested directories added, files and directories
+// removed, moved and renamed, reproduces the last scan, so a healthy store has no problems
+func TestFsckReplayChanges(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory", err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal("failed to change directory", err)
+	}
+	defer os.Chdir(wd)
+	if err = config.Load(); err != nil {
+		t.Fatal("failed to load config", err)
+	}
+	if err = records.Load(); err != nil {
+		t.Fatal("failed to load records", err)
+	}
+
+	root := makeFormatTestDir(t)
+	changes := []func() error{
+		// Nested directories added, and a file added to an existing one
+		func() error {
+			if err := os.MkdirAll(filepath.Join(root, "e/x/y"), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(root, "e/x/y/h"), []byte("nested"), 0600); err != nil {
+				return err
+			}
+			return os.WriteFile(filepath.Join(root, "a/c/new"), []byte("new"), 0600)
+		},
+		// A directory, and a file in a nested directory, removed
+		func() error {
+			if err := os.RemoveAll(filepath.Join(root, "d")); err != nil {
+				return err
+			}
+			return os.Remove(filepath.Join(root, "a/b/g"))
+		},
+		// A file, and a directory, moved to a nested directory
+		func() error {
+			if err := os.Rename(filepath.Join(root, "a/f"), filepath.Join(root, "e/x/f")); err != nil {
+				return err
+			}
+			return os.Rename(filepath.Join(root, "a/c"), filepath.Join(root, "e/c"))
+		},
+		// A directory renamed
+		func() error {
+			return os.Rename(filepath.Join(root, "e/x"), filepath.Join(root, "e/z"))
+		},
+		// A directory without files of its own renamed, with its subdirectories
+		func() error {
+			return os.Rename(filepath.Join(root, "e"), filepath.Join(root, "g"))
+		},
+	}
+
+	var last *tree.FileTree
+	for i := 0; i <= len(changes); i++ {
+		if i > 0 {
+			if err = changes[i-1](); err != nil {
+				t.Fatal("failed to change directory", err)
+			}
+		}
+		scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+		var d *diff.ScanDiff
+		if last != nil {
+			sd := diff.CompareTrees(last, scanned)
+			d = &sd
+		}
+		if err = records.RecordScan(scanned, d, true); err != nil {
+			t.Fatal("failed to record scan", err)
+		}
+		last = scanned
+
+		problems, err := records.Fsck(false)
+		if err != nil {
+			t.Fatal("failed to check scans", err)
+		} else if len(problems) > 0 {
+			t.Errorf("expected no problems after change %d, got %+v", i, problems)
+		}
+	}
+
+	// A diff that doesn't reproduce the scan it was recorded with is a problem that can't be repaired
+	if err = os.WriteFile(filepath.Join(root, "unrecorded"), []byte("unrecorded"), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	sd := diff.CompareTrees(last, last)
+	if err = records.RecordScan(scanned, &sd, true); err != nil {
+		t.Fatal("failed to record scan", err)
+	}
+	problems, err := records.Fsck(false)
+	if err != nil {
+		t.Fatal("failed to check scans", err)
+	} else if len(problems) != 1 || problems[0].Name != root || problems[0].Repair != "" {
+		t.Errorf("expected a problem with replaying the diffs of '%s' that can't be repaired, got %+v", root, problems)
+	}
+	if err = command.Fsck(nil); err == nil {
+		t.Error("expected fsck to fail with a problem that can't be repaired")
+	}
+	if err = command.Fsck([]string{"--repair"}); err == nil {
+		t.Error("expected fsck to fail after repairing with a problem that can't be repaired")
+	}
+}
@@ -0,0 +1,96 @@
+package test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/pericles-tpt/seye/tree"
+)
+
+// All walk algorithms should generate the same tree for the same directory
+func TestGenerateAllWalksEqualShallow(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Error("failed to get cwd", err)
+	}
+
+	populatedDir := cwd + "/testDir"
+	recursiveTree := tree.WalkGenerateTreeRecursive(populatedDir, 0, false, nil)
+	iterativeFileTree := tree.WalkTreeIterativeFile(populatedDir, 0, false, nil)
+	iterativeDirTree := tree.WalkTreeIterativeDir(populatedDir, false, nil)
+	recursiveSplitTree := tree.WalkTreeRecursiveSplit(populatedDir, false, nil, tree.GetTopLevelSplitPoints(populatedDir))
+
+	notEqualReason := (*iterativeFileTree).Equal(*recursiveTree)
+	if notEqualReason != nil {
+		spew.Dump(recursiveTree)
+		spew.Dump(iterativeFileTree)
+		t.Error("tree from `WalkTreeIterativeFile` NOT equal to tree from `WalkGenerateTreeRecursive`, reason: ", notEqualReason)
+	}
+
+	notEqualReason = (*iterativeDirTree).Equal(*recursiveTree)
+	if notEqualReason != nil {
+		spew.Dump(recursiveTree)
+		spew.Dump(iterativeDirTree)
+		t.Error("tree from `WalkTreeIterativeDir` NOT equal to tree from `WalkGenerateTreeRecursive`, reason: ", notEqualReason)
+	}
+
+	notEqualReason = (*recursiveSplitTree).Equal(*recursiveTree)
+	if notEqualReason != nil {
+		spew.Dump(recursiveTree)
+		spew.Dump(recursiveSplitTree)
+		t.Error("tree from `WalkTreeRecursiveSplit` NOT equal to tree from `WalkGenerateTreeRecursive`, reason: ", notEqualReason)
+	}
+}
+
+func TestGenerateAllWalksEqualComprehensive(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Error("failed to get cwd", err)
+	}
+
+	populatedDir := cwd + "/testDir"
+	recursiveTree := tree.WalkGenerateTreeRecursive(populatedDir, 0, true, nil)
+	iterativeFileTree := tree.WalkTreeIterativeFile(populatedDir, 0, true, nil)
+	iterativeDirTree := tree.WalkTreeIterativeDir(populatedDir, true, nil)
+	recursiveSplitTree := tree.WalkTreeRecursiveSplit(populatedDir, true, nil, tree.GetSplitPoints(recursiveTree, 2, true))
+
+	notEqualReason := (*iterativeFileTree).Equal(*recursiveTree)
+	if notEqualReason != nil {
+		spew.Dump(recursiveTree)
+		spew.Dump(iterativeFileTree)
+		t.Error("tree from `WalkTreeIterativeFile` NOT equal to tree from `WalkGenerateTreeRecursive`, reason: ", notEqualReason)
+	}
+
+	notEqualReason = (*iterativeDirTree).Equal(*recursiveTree)
+	if notEqualReason != nil {
+		spew.Dump(recursiveTree)
+		spew.Dump(iterativeDirTree)
+		t.Error("tree from `WalkTreeIterativeDir` NOT equal to tree from `WalkGenerateTreeRecursive`, reason: ", notEqualReason)
+	}
+
+	notEqualReason = (*recursiveSplitTree).Equal(*recursiveTree)
+	if notEqualReason != nil {
+		spew.Dump(recursiveTree)
+		spew.Dump(recursiveSplitTree)
+		t.Error("tree from `WalkTreeRecursiveSplit` NOT equal to tree from `WalkGenerateTreeRecursive`, reason: ", notEqualReason)
+	}
+}
+
+// The output of `WalkTreeIterativeDir` shouldn't depend on which thread finishes first
+func TestGenerateIDDeterministic(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Error("failed to get cwd", err)
+	}
+
+	populatedDir := cwd + "/testDir"
+	firstTree := tree.WalkTreeIterativeDir(populatedDir, true, nil)
+	for i := 0; i < 10; i++ {
+		nextTree := tree.WalkTreeIterativeDir(populatedDir, true, nil)
+		notEqualReason := (*nextTree).Equal(*firstTree)
+		if notEqualReason != nil {
+			t.Fatal("trees from repeated `WalkTreeIterativeDir` walks NOT equal, reason: ", notEqualReason)
+		}
+	}
+}
@@ -148,8 +148,13 @@ func TestGenerateIFNonexistentDir(t *testing.T) {
 	invalidPath := "/invalidPath"
 	invalidPathTree := tree.WalkTreeIterativeFile(invalidPath, 0, false, nil)
 	expTree := tree.FileTree{
-		BasePath:   "/invalidPath",
-		ErrStrings: []string{"failed to open `tree.BasePath`, cause: open /invalidPath: no such file or directory"},
+		BasePath: "/invalidPath",
+		Errors: []tree.WalkError{{
+			Path:    "/invalidPath",
+			Op:      tree.OpReadDir,
+			Class:   tree.ClassNotExist,
+			Message: "no such file or directory",
+		}},
 	}
 	notEqualReason := (*invalidPathTree).Equal(expTree)
 	if notEqualReason != nil {
@@ -158,12 +163,8 @@ func TestGenerateIFNonexistentDir(t *testing.T) {
 }
 
 func TestGenerateIFEmptyDir(t *testing.T) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		t.Error("failed to get cwd", err)
-	}
-
-	emptyDirPath := cwd + "/testDir/B"
+	// Git doesn't track empty directories, so it can't be a fixture in `testDir`
+	emptyDirPath := t.TempDir()
 	emptyDirTree := tree.WalkTreeIterativeFile(emptyDirPath, 0, false, nil)
 	expTree := tree.FileTree{
 		BasePath:      emptyDirPath,
@@ -171,6 +172,10 @@ func TestGenerateIFEmptyDir(t *testing.T) {
 		LastVisited:   emptyDirTree.LastVisited,
 		TimeTaken:     emptyDirTree.TimeTaken,
 		Depth:         0,
+		Dev:           emptyDirTree.Dev,
+		Uid:           emptyDirTree.Uid,
+		Gid:           emptyDirTree.Gid,
+		Mode:          emptyDirTree.Mode,
 	}
 	notEqualReason := (*emptyDirTree).Equal(expTree)
 	if notEqualReason != nil {
@@ -178,170 +183,41 @@ func TestGenerateIFEmptyDir(t *testing.T) {
 	}
 }
 
-// TODO: Get tests for "MT Dir" traversal (below) working
-// func TestGenerateDFNonexistentDir(t *testing.T) {
-// 	invalidPath := "/invalidPath"
-// 	invalidPathTree := tree.WalkTreeIterativeDir(invalidPath, false, nil)
-// 	expTree := tree.FileTree{
-// 		BasePath:   "/invalidPath",
-// 		ErrStrings: []string{"failed to open `tree.BasePath`, cause: open /invalidPath: no such file or directory"},
-// 	}
-// 	notEqualReason := diff.TreesEqualShallow(*invalidPathTree, expTree)
-// 	if notEqualReason != nil {
-// 		t.Error("tree for invalid path NOT equal to empty `FileTree`, reason: ", notEqualReason)
-// 	}
-// }
-
-// func TestGenerateDFEmptyDir(t *testing.T) {
-// 	cwd, err := os.Getwd()
-// 	if err != nil {
-// 		t.Error("failed to get cwd", err)
-// 	}
-
-// 	emptyDirPath := cwd + "/testDir/B"
-// 	emptyDirTree := tree.WalkTreeIterativeDir(emptyDirPath, false, nil)
-// 	expTree := tree.FileTree{
-// 		BasePath:      emptyDirPath,
-// 		Comprehensive: false,
-// 		LastVisited:   emptyDirTree.LastVisited,
-// 		TimeTaken:     emptyDirTree.TimeTaken,
-// 		Depth:         0,
-// 	}
-// 	notEqualReason := diff.TreesEqualShallow(*emptyDirTree, expTree)
-// 	if notEqualReason != nil {
-// 		t.Error("tree for invalid path NOT equal to expected `emptyDirTree`, reason: ", notEqualReason)
-// 	}
-// }
-
-// func TestGenerateDFPopulatedDir(t *testing.T) {
-// 	cwd, err := os.Getwd()
-// 	if err != nil {
-// 		t.Error("failed to get cwd", err)
-// 	}
-
-// 	populatedDir := cwd + "/testDir"
-// 	populatedDirTree := tree.WalkTreeIterativeDir(populatedDir, false, nil)
-// 	var (
-// 		timeA, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2023-06-09 18:53:06.852119727 +1000 AEST")
-// 		timeB, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2023-06-09 18:53:08.974341879 +1000 AEST")
-// 		timeC, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2023-06-09 18:52:59.078572814 +1000 AEST")
-// 		timeD, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2023-06-09 18:52:02.937675614 +1000 AEST")
-// 		timeE, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2023-06-09 18:53:06.852119727 +1000 AEST")
-// 		timeF, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2023-06-09 18:53:07.876436108 +1000 AEST")
-// 		timeG, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2023-06-09 18:53:08.974341879 +1000 AEST")
-// 		timeH, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2023-06-09 18:52:30.76262004 +1000 AEST")
-// 		timeI, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2023-06-09 18:52:44.737678331 +1000 AEST")
-// 		timeJ, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2023-06-09 18:53:08.974341879 +1000 AEST")
-// 		timeK, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2023-06-09 18:53:08.974341879 +1000 AEST")
-// 		expTree  = tree.FileTree{
-// 			Comprehensive:      false,
-// 			BasePath:           "/Users/ptelemachou/Code/Work/Go_Projects/src/github.com/pericles-tpt/seye/test/testDir",
-// 			LastVisited:        populatedDirTree.LastVisited,
-// 			TimeTaken:          populatedDirTree.TimeTaken,
-// 			LastModifiedDirect: timeA,
-// 			SizeDirect:         79,
-// 			NumFilesDirect:     3,
-// 			LastModifiedBelow:  timeB,
-// 			SizeBelow:          105,
-// 			NumFilesBelow:      7,
-// 			Files: []tree.File{
-// 				{
-// 					Name: "/Users/ptelemachou/Code/Work/Go_Projects/src/github.com/pericles-tpt/seye/test/testDir/A1",
-// 					Hash: utility.HashLocation{
-// 						HashOffset: -1,
-// 					},
-// 					Size:         44,
-// 					LastModified: timeC,
-// 				},
-// 				{
-// 					Name: "/Users/ptelemachou/Code/Work/Go_Projects/src/github.com/pericles-tpt/seye/test/testDir/B1",
-// 					Hash: utility.HashLocation{
-// 						HashOffset: -1,
-// 					},
-// 					LastModified: timeD,
-// 				},
-// 				{
-// 					Name: "/Users/ptelemachou/Code/Work/Go_Projects/src/github.com/pericles-tpt/seye/test/testDir/C1",
-// 					Hash: utility.HashLocation{
-// 						HashOffset: -1,
-// 					},
-// 					Size:         35,
-// 					LastModified: timeE,
-// 				},
-// 			},
-// 			SubTrees: []tree.FileTree{{
-// 				Comprehensive: false,
-// 				BasePath:      "/Users/ptelemachou/Code/Work/Go_Projects/src/github.com/pericles-tpt/seye/test/testDir/A",
-// 				Files: []tree.File{
-// 					{
-// 						Name: "/Users/ptelemachou/Code/Work/Go_Projects/src/github.com/pericles-tpt/seye/test/testDir/A/A",
-// 						Hash: utility.HashLocation{
-// 							HashOffset: -1,
-// 						},
-// 						Size:         11,
-// 						LastModified: timeF,
-// 					},
-// 					{
-// 						Name: "/Users/ptelemachou/Code/Work/Go_Projects/src/github.com/pericles-tpt/seye/test/testDir/A/B",
-// 						Hash: utility.HashLocation{
-// 							HashOffset: -1,
-// 						},
-// 						Size:         6,
-// 						LastModified: timeG,
-// 					},
-// 					{
-// 						Name: "/Users/ptelemachou/Code/Work/Go_Projects/src/github.com/pericles-tpt/seye/test/testDir/A/C",
-// 						Hash: utility.HashLocation{
-// 							HashOffset: -1,
-// 						},
-// 						LastModified: timeH,
-// 					},
-// 					{
-// 						Name: "/Users/ptelemachou/Code/Work/Go_Projects/src/github.com/pericles-tpt/seye/test/testDir/A/D",
-// 						Hash: utility.HashLocation{
-// 							HashOffset: -1,
-// 						},
-// 						Size:         9,
-// 						LastModified: timeI,
-// 					},
-// 				},
-// 				LastVisited:        populatedDirTree.SubTrees[0].LastVisited,
-// 				TimeTaken:          populatedDirTree.SubTrees[0].TimeTaken,
-// 				Depth:              1,
-// 				LastModifiedDirect: timeJ,
-// 				SizeDirect:         26,
-// 				NumFilesDirect:     4,
-// 				LastModifiedBelow:  timeK,
-// 				SizeBelow:          26,
-// 				NumFilesBelow:      4,
-// 			},
-// 				{
-// 					Comprehensive: false,
-// 					BasePath:      "/Users/ptelemachou/Code/Work/Go_Projects/src/github.com/pericles-tpt/seye/test/testDir/B",
-// 					LastVisited:   populatedDirTree.SubTrees[1].LastVisited,
-// 					TimeTaken:     populatedDirTree.SubTrees[1].TimeTaken,
-// 					Depth:         1,
-// 				},
-// 				{
-// 					Comprehensive: false,
-// 					BasePath:      "/Users/ptelemachou/Code/Work/Go_Projects/src/github.com/pericles-tpt/seye/test/testDir/C",
-// 					LastVisited:   populatedDirTree.SubTrees[2].LastVisited,
-// 					TimeTaken:     populatedDirTree.SubTrees[2].TimeTaken,
-// 					Depth:         1,
-// 				},
-// 				{
-// 					Comprehensive: false,
-// 					BasePath:      "/Users/ptelemachou/Code/Work/Go_Projects/src/github.com/pericles-tpt/seye/test/testDir/D",
-// 					LastVisited:   populatedDirTree.SubTrees[3].LastVisited,
-// 					TimeTaken:     populatedDirTree.SubTrees[3].TimeTaken,
-// 					Depth:         1,
-// 				},
-// 			},
-// 		}
-// 	)
+func TestGenerateDFNonexistentDir(t *testing.T) {
+	invalidPath := "/invalidPath"
+	invalidPathTree := tree.WalkTreeIterativeDir(invalidPath, false, nil)
+	expTree := tree.FileTree{
+		BasePath: "/invalidPath",
+		Errors: []tree.WalkError{{
+			Path:    "/invalidPath",
+			Op:      tree.OpReadDir,
+			Class:   tree.ClassNotExist,
+			Message: "no such file or directory",
+		}},
+	}
+	notEqualReason := (*invalidPathTree).Equal(expTree)
+	if notEqualReason != nil {
+		t.Error("tree for invalid path NOT equal to empty `FileTree`, reason: ", notEqualReason)
+	}
+}
 
-// 	notEqualReason := diff.TreesEqualShallow(*populatedDirTree, expTree)
-// 	if notEqualReason != nil {
-// 		t.Error("tree for populate dir NOT equal to expected `populatedDirTree`, reason: ", notEqualReason)
-// 	}
-// }
+func TestGenerateDFEmptyDir(t *testing.T) {
+	// Git doesn't track empty directories, so it can't be a fixture in `testDir`
+	emptyDirPath := t.TempDir()
+	emptyDirTree := tree.WalkTreeIterativeDir(emptyDirPath, false, nil)
+	expTree := tree.FileTree{
+		BasePath:      emptyDirPath,
+		Comprehensive: false,
+		LastVisited:   emptyDirTree.LastVisited,
+		TimeTaken:     emptyDirTree.TimeTaken,
+		Depth:         0,
+		Dev:           emptyDirTree.Dev,
+		Uid:           emptyDirTree.Uid,
+		Gid:           emptyDirTree.Gid,
+		Mode:          emptyDirTree.Mode,
+	}
+	notEqualReason := (*emptyDirTree).Equal(expTree)
+	if notEqualReason != nil {
+		t.Error("tree for invalid path NOT equal to expected `emptyDirTree`, reason: ", notEqualReason)
+	}
+}
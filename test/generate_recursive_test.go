@@ -2,6 +2,7 @@ package test
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -146,8 +147,13 @@ func TestGenerateRNonexistentDir(t *testing.T) {
 	invalidPath := "/invalidPath"
 	invalidPathTree := tree.WalkGenerateTreeRecursive(invalidPath, 0, false, nil)
 	expTree := tree.FileTree{
-		BasePath:   invalidPath,
-		ErrStrings: []string{"failed to open `tree.BasePath`, cause: open /invalidPath: no such file or directory"},
+		BasePath: invalidPath,
+		Errors: []tree.WalkError{{
+			Path:    "/invalidPath",
+			Op:      tree.OpReadDir,
+			Class:   tree.ClassNotExist,
+			Message: "no such file or directory",
+		}},
 	}
 	notEqualReason := (*invalidPathTree).Equal(expTree)
 	if notEqualReason != nil {
@@ -156,12 +162,8 @@ func TestGenerateRNonexistentDir(t *testing.T) {
 }
 
 func TestGenerateREmptyDir(t *testing.T) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		t.Error("failed to get cwd", err)
-	}
-
-	emptyDirPath := cwd + "/testDir/B"
+	// Git doesn't track empty directories, so it can't be a fixture in `testDir`
+	emptyDirPath := t.TempDir()
 	emptyDirTree := tree.WalkGenerateTreeRecursive(emptyDirPath, 0, false, nil)
 	expTree := tree.FileTree{
 		BasePath:      emptyDirPath,
@@ -169,9 +171,29 @@ func TestGenerateREmptyDir(t *testing.T) {
 		LastVisited:   emptyDirTree.LastVisited,
 		TimeTaken:     emptyDirTree.TimeTaken,
 		Depth:         0,
+		Dev:           emptyDirTree.Dev,
+		Uid:           emptyDirTree.Uid,
+		Gid:           emptyDirTree.Gid,
+		Mode:          emptyDirTree.Mode,
 	}
 	notEqualReason := (*emptyDirTree).Equal(expTree)
 	if notEqualReason != nil {
 		t.Error("tree for invalid path NOT equal to expected `emptyDirTree`, reason: ", notEqualReason)
 	}
 }
+
+// A trailing "/" on the path walked doesn't change the paths in the tree
+func TestGenerateRTrailingSlash(t *testing.T) {
+	root := makeFormatTestDir(t)
+	withoutSlash := tree.WalkGenerateTreeRecursive(root, 0, false, nil)
+	withSlash := tree.WalkGenerateTreeRecursive(root+"/", 0, false, nil)
+	withSlash.LastVisited, withSlash.TimeTaken = withoutSlash.LastVisited, withoutSlash.TimeTaken
+	if notEqualReason := withSlash.Equal(*withoutSlash); notEqualReason != nil {
+		t.Error("tree walked with a trailing '/' NOT equal to tree walked without one, reason: ", notEqualReason)
+	}
+	for _, st := range withSlash.SubTrees {
+		if strings.Contains(st.BasePath, "//") {
+			t.Errorf("expected no '//' in path '%s'", st.BasePath)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/diff"
+	"github.com/pericles-tpt/seye/stats"
+	"github.com/pericles-tpt/seye/tree"
+)
+
+func createHardlinkedTree(t *testing.T) string {
+	root := t.TempDir()
+	for _, d := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(root, d), 0700); err != nil {
+			t.Fatal("failed to create dir", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "f"), []byte("contents"), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	if err := os.Link(filepath.Join(root, "a", "f"), filepath.Join(root, "b", "f")); err != nil {
+		t.Skip("hardlinks not supported", err)
+	}
+	return root
+}
+
+// Each inode should only be counted once, whichever walk algorithm is used
+func TestGenerateHardlinksCountedOnce(t *testing.T) {
+	root := createHardlinkedTree(t)
+
+	recursiveTree := tree.WalkGenerateTreeRecursive(root, 0, false, nil)
+	iterativeFileTree := tree.WalkTreeIterativeFile(root, 0, false, nil)
+	iterativeDirTree := tree.WalkTreeIterativeDir(root, false, nil)
+	if notEqualReason := (*iterativeFileTree).Equal(*recursiveTree); notEqualReason != nil {
+		t.Error("tree from `WalkTreeIterativeFile` NOT equal to tree from `WalkGenerateTreeRecursive`, reason: ", notEqualReason)
+	}
+	if notEqualReason := (*iterativeDirTree).Equal(*recursiveTree); notEqualReason != nil {
+		t.Error("tree from `WalkTreeIterativeDir` NOT equal to tree from `WalkGenerateTreeRecursive`, reason: ", notEqualReason)
+	}
+	if recursiveTree.SizeBelow != int64(len("contents")) {
+		t.Errorf("expected `SizeBelow` of %d, got %d", len("contents"), recursiveTree.SizeBelow)
+	}
+
+	tree.SetCountLinks(true)
+	defer tree.SetCountLinks(false)
+	countedTree := tree.WalkGenerateTreeRecursive(root, 0, false, nil)
+	if countedTree.SizeBelow != 2*int64(len("contents")) {
+		t.Errorf("expected `SizeBelow` of %d when counting links, got %d", 2*len("contents"), countedTree.SizeBelow)
+	}
+}
+
+func TestDuplicatesExcludeHardlinks(t *testing.T) {
+	root := createHardlinkedTree(t)
+	if err := os.WriteFile(filepath.Join(root, "copy"), []byte("contents"), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+
+	fileMap := map[string][]stats.BasicFile{}
+	ws := stats.WalkStats{DuplicateMap: &fileMap}
+	tree.WalkGenerateTreeRecursive(root, 0, true, &ws)
+
+	duplicates := ws.GetLargestDuplicates(10)
+	if len(duplicates) != 1 || len(duplicates[0]) != 2 {
+		t.Errorf("expected one group of 2 duplicates, got %+v", duplicates)
+	}
+}
+
+// A new hardlink to an existing file is a metadata change to it, so the file replayed from the diff
+// is equal to the file in the newer scan
+func TestDiffHardlinkChange(t *testing.T) {
+	root := t.TempDir()
+	for _, d := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(root, d), 0700); err != nil {
+			t.Fatal("failed to create dir", err)
+		}
+	}
+	name := filepath.Join(root, "a", "f")
+	if err := os.WriteFile(name, []byte("contents"), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	older := tree.WalkGenerateTreeRecursive(root, 0, false, nil)
+	if err := os.Link(name, filepath.Join(root, "b", "f")); err != nil {
+		t.Skip("hardlinks not supported", err)
+	}
+	newer := tree.WalkGenerateTreeRecursive(root, 0, false, nil)
+
+	d := diff.CompareTrees(older, newer)
+	if fd, ok := d.Files[name]; !ok || fd.NewerNlink != 2 {
+		t.Fatalf("expected a diff of '%s' with 2 links, got %+v", name, d.Files)
+	}
+
+	replayed := older.DeepCopy()
+	diff.WalkAddTreeDiff(&replayed, &d, &replayed.AllHash, []diff.TreeDiff{}, []diff.FileDiff{})
+	if len(replayed.SubTrees) == 0 || len(replayed.SubTrees[0].Files) != 1 || !replayed.SubTrees[0].Files[0].Equal(newer.SubTrees[0].Files[0]) {
+		t.Errorf("expected replayed '%s' to equal the newer scan's, got %+v, expected %+v", name, replayed.SubTrees, newer.SubTrees[0].Files)
+	}
+}
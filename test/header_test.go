@@ -0,0 +1,112 @@
+package test
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pericles-tpt/seye/diff"
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+// Trees are written with a header summarising the scan, trees without one are migrated to have one
+func TestTreeHeaderMigrate(t *testing.T) {
+	root := makeFormatTestDir(t)
+	scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+
+	path := filepath.Join(t.TempDir(), "scan.tree")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	if err = tree.EncodeTree(f, scanned); err != nil {
+		t.Fatal("failed to encode tree", err)
+	}
+	f.Close()
+
+	migrated, err := tree.MigrateTree(path)
+	if err != nil || !migrated {
+		t.Fatalf("expected '%s' to be migrated, got: %v, err: %v", path, migrated, err)
+	}
+	tr, err := tree.OpenTreeReader(path)
+	if err != nil {
+		t.Fatal("failed to open tree reader", err)
+	}
+	tr.Close()
+
+	h := tr.Header
+	if h == nil || h.Kind != utility.FileKindTree || h.RootPath != scanned.BasePath || !h.Comprehensive ||
+		h.Size != scanned.SizeBelow || h.NumFiles != 5 || h.NumDirs != 5 {
+		t.Errorf("header NOT as expected: %+v", h)
+	}
+	if migrated, _ := tree.MigrateTree(path); migrated {
+		t.Error("expected an already migrated file not to be migrated again")
+	}
+	read, err := tree.ReadBinary(path)
+	if err != nil {
+		t.Fatal("failed to read tree", err)
+	}
+	if notEqualReason := scanned.Equal(read); notEqualReason != nil {
+		t.Error("migrated tree NOT equal to the original, reason: ", notEqualReason)
+	}
+}
+
+// Files from newer versions of seye, or of the wrong kind, are rejected
+func TestHeaderRejectsUnknownVersion(t *testing.T) {
+	defer utility.SetCompressionLevel(utility.GetCompressionLevel())
+	utility.SetCompressionLevel(0)
+
+	path := filepath.Join(t.TempDir(), "scan.tree")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	utility.WriteFileHeader(f, utility.FileHeader{Kind: utility.FileKindTree, FormatVersion: 99, SeyeVersion: "future"})
+	f.Close()
+	if _, err = tree.ReadBinary(path); err == nil || !strings.Contains(err.Error(), "unsupported tree format version 99") {
+		t.Error("expected an unsupported version error, got: ", err)
+	}
+
+	root := makeFormatTestDir(t)
+	scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	if err = scanned.WriteBinary(path); err != nil {
+		t.Fatal("failed to write tree", err)
+	}
+	if _, err = diff.ReadBinary(path); err == nil || !strings.Contains(err.Error(), "expected a diff file") {
+		t.Error("expected a wrong kind error, got: ", err)
+	}
+}
+
+// Diffs without a header are migrated to have one
+func TestDiffHeaderMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.diff")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	d := diff.ScanDiff{Files: map[string]diff.FileDiff{}, Trees: map[string]diff.TreeDiff{}}
+	if err = gob.NewEncoder(f).Encode(&d); err != nil {
+		t.Fatal("failed to encode gob", err)
+	}
+	f.Close()
+
+	scanTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	migrated, err := diff.MigrateDiff(path, "/root", scanTime, true)
+	if err != nil || !migrated {
+		t.Fatalf("expected '%s' to be migrated, got: %v, err: %v", path, migrated, err)
+	}
+	_, h, err := diff.ReadBinaryWithHeader(path)
+	if err != nil {
+		t.Fatal("failed to read diff", err)
+	}
+	if h == nil || h.Kind != utility.FileKindDiff || h.RootPath != "/root" || !h.ScanTime.Equal(scanTime) || !h.Comprehensive {
+		t.Errorf("header NOT as expected: %+v", h)
+	}
+	if migrated, _ := diff.MigrateDiff(path, "/root", scanTime, true); migrated {
+		t.Error("expected an already migrated file not to be migrated again")
+	}
+}
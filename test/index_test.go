@@ -0,0 +1,71 @@
+package test
+
+import (
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+// A subtree read through the index is the same as the subtree of the whole tree, whether the tree
+// file is compressed or not, and whether it has an index or not
+func TestIndexedSubtree(t *testing.T) {
+	root := makeFormatTestDir(t)
+	scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	defer utility.SetCompressionLevel(utility.GetCompressionLevel())
+
+	var expected *tree.FileTree
+	for i := range scanned.SubTrees {
+		if scanned.SubTrees[i].BasePath == filepath.Join(root, "a") {
+			expected = &scanned.SubTrees[i]
+		}
+	}
+	if expected == nil {
+		t.Fatal("expected directory 'a' in the scanned tree")
+	}
+
+	for _, level := range []int{gzip.NoCompression, gzip.DefaultCompression} {
+		utility.SetCompressionLevel(level)
+		path := filepath.Join(t.TempDir(), "scan.tree")
+		if err := scanned.WriteBinary(path); err != nil {
+			t.Fatal("failed to write tree", err)
+		}
+
+		for _, withIndex := range []bool{true, false} {
+			if !withIndex {
+				os.Remove(tree.IndexPath(path))
+			}
+			it, err := tree.OpenIndexed(path)
+			if err != nil {
+				t.Fatal("failed to open indexed tree", err)
+			}
+
+			st, err := it.Subtree(filepath.Join(root, "a"))
+			if err != nil {
+				t.Fatal("failed to read subtree", err)
+			}
+			if st.BasePath != expected.BasePath || st.Depth != 0 || st.SizeBelow != expected.SizeBelow ||
+				len(st.SubTrees) != len(expected.SubTrees) || len(st.AllHash) != int(expected.NumFilesBelow)*32 {
+				t.Errorf("level %d, index %v: subtree read NOT the same as the scanned subtree: %+v", level, withIndex, st)
+			}
+
+			top, err := it.SubtreeToDepth(root, 1)
+			if err != nil {
+				t.Fatal("failed to read subtree", err)
+			}
+			for _, sst := range top.SubTrees {
+				if len(sst.SubTrees) > 0 {
+					t.Errorf("level %d, index %v: expected '%s' to be read without its subtrees", level, withIndex, sst.BasePath)
+				}
+			}
+
+			if _, err = it.Subtree(filepath.Join(root, "missing")); !errors.Is(err, tree.ErrNotInTree) {
+				t.Errorf("level %d, index %v: expected ErrNotInTree for a missing directory, got: %v", level, withIndex, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package test
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pericles-tpt/seye/diff"
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+/*
+Copies of the structs as they were written, as a `gob`, by older versions of seye. `gob` matches
+fields by name, so these decode the same as the originals
+*/
+type legacyFileTree struct {
+	Comprehensive bool
+	BasePath      string
+	Files         []legacyFile
+	ErrStrings    []string
+	LastVisited   time.Time
+	TimeTaken     time.Duration
+	Depth         int
+
+	LastModifiedDirect time.Time
+	SizeDirect         int64
+	NumFilesDirect     int64
+
+	LastModifiedBelow time.Time
+	SizeBelow         int64
+	NumFilesBelow     int64
+	SubTrees          []legacyFileTree
+
+	AllHash       []byte
+	AllHashOffset int64
+}
+
+type legacyFile struct {
+	Name         string
+	Hash         utility.HashLocation
+	Size         int64
+	Err          string
+	LastModified time.Time
+}
+
+type legacyScanDiff struct {
+	AllHash []byte
+	Trees   map[string]legacyTreeDiff
+	Files   map[string]legacyFileDiff
+}
+
+type legacyTreeDiff struct {
+	DiffCompleted time.Time
+	Comprehensive bool
+	Type          int64
+
+	OriginalPath     string
+	NewerPath        string
+	DepthDiff        int
+	ErrStringsDiff   []string
+	FilesDiff        []legacyFileDiff
+	FilesDiffIndices []int
+	LastVisitedDiff  time.Duration
+	TimeTakenDiff    time.Duration
+
+	LastModifiedDiffDirect  time.Duration
+	SizeDiffDirect          int64
+	NumFilesTotalDiffDirect int64
+
+	SubTreesDiff        []legacyTreeDiff
+	SubTreesDiffIndices []int
+
+	AllHash       []byte
+	AllHashOffset int64
+}
+
+type legacyFileDiff struct {
+	NewerName        string
+	NewerErr         string
+	Type             int64
+	HashDiff         utility.HashLocation
+	SizeDiff         int64
+	LastModifiedDiff time.Duration
+}
+
+func writeLegacyGob(t *testing.T, path string, v any) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	defer f.Close()
+	if err = gob.NewEncoder(f).Encode(v); err != nil {
+		t.Fatal("failed to encode gob", err)
+	}
+}
+
+// The error string of a file in an older `gob` scan is read into its `WalkErr`
+func TestReadLegacyGobTreeErrors(t *testing.T) {
+	lastModified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	old := legacyFileTree{
+		BasePath: "/root",
+		Files: []legacyFile{
+			{Name: "/root/ok", Hash: utility.InitialiseHashLocation(), Size: 1, LastModified: lastModified},
+			{Name: "/root/unreadable", Hash: utility.InitialiseHashLocation(), Err: "permission denied", LastModified: lastModified},
+		},
+		SizeDirect:     1,
+		SizeBelow:      1,
+		NumFilesDirect: 2,
+		NumFilesBelow:  2,
+	}
+	path := filepath.Join(t.TempDir(), "scan.tree")
+	writeLegacyGob(t, path, old)
+
+	read, err := tree.ReadBinary(path)
+	if err != nil {
+		t.Fatal("failed to read tree", err)
+	}
+	if len(read.Files) != 2 || read.Files[0].WalkErr != nil || read.Files[1].Size != 0 {
+		t.Fatalf("tree NOT as expected: %+v", read.Files)
+	}
+	if we := read.Files[1].WalkErr; we == nil || we.Path != "/root/unreadable" || we.Message != "permission denied" {
+		t.Errorf("expected the file's error to be read, got %+v", we)
+	}
+	if read.Files[1].Err != "" {
+		t.Errorf("expected the error string to be moved to `WalkErr`, got '%s'", read.Files[1].Err)
+	}
+}
+
+// The error string of a file diff in an older `gob` diff is read into its `NewerWalkErr`
+func TestReadLegacyGobDiffErrors(t *testing.T) {
+	unreadable := legacyFileDiff{
+		NewerName: "/root/unreadable",
+		NewerErr:  "permission denied",
+		Type:      4,
+		HashDiff:  utility.InitialiseHashLocation(),
+	}
+	old := legacyScanDiff{
+		Trees: map[string]legacyTreeDiff{
+			"/root": {NewerPath: "/root", FilesDiff: []legacyFileDiff{unreadable}},
+		},
+		Files: map[string]legacyFileDiff{unreadable.NewerName: unreadable},
+	}
+	path := filepath.Join(t.TempDir(), "scan.diff")
+	writeLegacyGob(t, path, old)
+
+	read, err := diff.ReadBinary(path)
+	if err != nil {
+		t.Fatal("failed to read diff", err)
+	}
+	fd, ok := read.Files[unreadable.NewerName]
+	if !ok || fd.NewerWalkErr == nil || fd.NewerWalkErr.Message != "permission denied" || fd.NewerErr != "" {
+		t.Errorf("expected the file's error to be read, got %+v", read.Files)
+	}
+	td, ok := read.Trees["/root"]
+	if !ok || len(td.FilesDiff) != 1 || td.FilesDiff[0].NewerWalkErr == nil || td.FilesDiff[0].NewerWalkErr.Message != "permission denied" {
+		t.Errorf("expected the error of the tree's file diff to be read, got %+v", read.Trees)
+	}
+}
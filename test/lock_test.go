@@ -0,0 +1,85 @@
+//go:build unix
+
+package test
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pericles-tpt/seye/config"
+	"github.com/pericles-tpt/seye/records"
+)
+
+// An exclusive lock on the scans excludes all others, shared locks only exclude exclusive ones
+func TestLockScans(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory", err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal("failed to change directory", err)
+	}
+	defer os.Chdir(wd)
+	if err = config.Load(); err != nil {
+		t.Fatal("failed to load config", err)
+	}
+
+	exclusive, err := records.LockScans(true, false)
+	if err != nil {
+		t.Fatal("failed to take exclusive lock", err)
+	}
+	_, err = records.LockScans(false, false)
+	if !errors.Is(err, records.ErrLocked) || !strings.Contains(err.Error(), strconv.Itoa(os.Getpid())) {
+		t.Error("expected the lock to be held by this PID, got: ", err)
+	}
+	exclusive.Unlock()
+
+	shared, err := records.LockScans(false, false)
+	if err != nil {
+		t.Fatal("failed to take shared lock", err)
+	}
+	defer shared.Unlock()
+	otherShared, err := records.LockScans(false, false)
+	if err != nil {
+		t.Fatal("failed to take a second shared lock", err)
+	}
+	defer otherShared.Unlock()
+	// Readers aren't named by a PID, even that of the last process to hold the lock exclusively
+	if _, err = records.LockScans(true, false); !errors.Is(err, records.ErrLocked) {
+		t.Error("expected an exclusive lock to fail while shared locks are held, got: ", err)
+	} else if strings.Contains(err.Error(), "PID") {
+		t.Error("expected no PID to be named for shared locks, got: ", err)
+	}
+}
+
+// The lock is taken in the scans output directory, once it's been chosen
+func TestLockScansInOutputDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory", err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal("failed to change directory", err)
+	}
+	defer os.Chdir(wd)
+	if err = config.Load(); err != nil {
+		t.Fatal("failed to load config", err)
+	}
+	outDir := t.TempDir() + "/"
+	config.SetScansOutputDir(outDir)
+
+	lock, err := records.LockScans(true, false)
+	if err != nil {
+		t.Fatal("failed to take exclusive lock", err)
+	}
+	defer lock.Unlock()
+	if _, err = os.Stat(outDir + ".seye.lock"); err != nil {
+		t.Error("expected the lock file in the scans output directory, err: ", err)
+	}
+	if _, err = os.Stat(".seye.lock"); !os.IsNotExist(err) {
+		t.Error("expected no lock file in the working directory, err: ", err)
+	}
+}
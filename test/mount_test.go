@@ -0,0 +1,47 @@
+package test
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/pericles-tpt/seye/tree"
+)
+
+func TestGetMountsHasRoot(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mounts are only listed on linux")
+	}
+
+	mounts, err := tree.GetMounts()
+	if err != nil {
+		t.Fatal("failed to get mounts", err)
+	}
+	for _, m := range mounts {
+		if m.MountPoint == "/" {
+			return
+		}
+	}
+	t.Errorf("expected a mount at '/', got %+v", mounts)
+}
+
+// A tree on a single filesystem should be the same with or without `--one-file-system`
+func TestGenerateOneFileSystemSameDev(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(root+"/f", []byte("contents"), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+
+	allTree := tree.WalkGenerateTreeRecursive(root, 0, false, nil)
+	tree.SetOneFileSystem(true)
+	defer tree.SetOneFileSystem(false)
+	oneFsTree := tree.WalkGenerateTreeRecursive(root, 0, false, nil)
+
+	if notEqualReason := (*oneFsTree).Equal(*allTree); notEqualReason != nil {
+		t.Error("tree walked with `--one-file-system` NOT equal to tree walked without it, reason: ", notEqualReason)
+	}
+	usage := allTree.GetMountUsage()
+	if len(usage) != 1 || usage[0].MountPoint != root || usage[0].NumFiles != 1 {
+		t.Errorf("expected all usage under '%s', got %+v", root, usage)
+	}
+}
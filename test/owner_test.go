@@ -0,0 +1,43 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/diff"
+	"github.com/pericles-tpt/seye/tree"
+)
+
+func TestUsageByOwner(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "f"), []byte("contents"), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+
+	usage := tree.WalkGenerateTreeRecursive(root, 0, false, nil).GetUsageByOwner(false)
+	if len(usage) != 1 || usage[0].Id != uint32(os.Getuid()) || usage[0].NumFiles != 1 || usage[0].Size != int64(len("contents")) {
+		t.Errorf("expected all usage by uid %d, got %+v", os.Getuid(), usage)
+	}
+}
+
+// Changing only a file's mode is a metadata change, not a content change
+func TestDiffModeChanged(t *testing.T) {
+	root := t.TempDir()
+	filePath := filepath.Join(root, "f")
+	if err := os.WriteFile(filePath, []byte("contents"), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+
+	originalTree := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	if err := os.Chmod(filePath, 0644); err != nil {
+		t.Fatal("failed to chmod file", err)
+	}
+	chmodTree := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+
+	d := diff.CompareTrees(originalTree, chmodTree)
+	fd, ok := d.Files[filePath]
+	if !ok || !fd.ModeChanged || fd.OwnerChanged || fd.SizeDiff != 0 || fd.NewerMode != 0644 {
+		t.Errorf("expected a mode change to 0644 for '%s', got %+v", filePath, d.Files)
+	}
+}
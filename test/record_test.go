@@ -0,0 +1,181 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/config"
+	"github.com/pericles-tpt/seye/diff"
+	"github.com/pericles-tpt/seye/records"
+	"github.com/pericles-tpt/seye/tree"
+)
+
+func readDirContents(t *testing.T, dir string) map[string][]byte {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal("failed to read directory", err)
+	}
+	ret := map[string][]byte{}
+	for _, e := range ents {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal("failed to read file", err)
+		}
+		ret[e.Name()] = b
+	}
+	return ret
+}
+
+// A scan that fails to be recorded, at any step, leaves the records and scan files as they were
+func TestRecordScanRollback(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory", err)
+	}
+	scansDir := t.TempDir()
+	if err = os.Chdir(scansDir); err != nil {
+		t.Fatal("failed to change directory", err)
+	}
+	defer os.Chdir(wd)
+	defer records.SetRecordStepHook(nil)
+	if err = config.Load(); err != nil {
+		t.Fatal("failed to load config", err)
+	}
+	if err = records.Load(); err != nil {
+		t.Fatal("failed to load records", err)
+	}
+
+	// Record two scans, so the next one replaces the last
+	root := makeFormatTestDir(t)
+	var last *tree.FileTree
+	for i := 0; i < 3; i++ {
+		scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+		var d *diff.ScanDiff
+		if last != nil {
+			sd := diff.CompareTrees(last, scanned)
+			d = &sd
+		}
+
+		if i == 2 {
+			before := readDirContents(t, scansDir)
+			beforeScans := *records.GetScansFull(root)
+			for _, step := range []string{records.StepWriteTree, records.StepWriteDiff, records.StepRenameFiles, records.StepFlushRecords} {
+				records.SetRecordStepHook(func(s string) error {
+					if s == step {
+						return errors.New("injected failure")
+					}
+					return nil
+				})
+				if err = records.RecordScan(scanned, d, true); err == nil {
+					t.Fatalf("expected recording to fail at step '%s'", step)
+				}
+
+				after := readDirContents(t, scansDir)
+				if len(after) != len(before) {
+					t.Errorf("failed at '%s': expected %d files after rollback, got %d", step, len(before), len(after))
+				}
+				for name, b := range before {
+					if !bytes.Equal(after[name], b) {
+						t.Errorf("failed at '%s': '%s' changed after rollback", step, name)
+					}
+				}
+				afterScans := *records.GetScansFull(root)
+				if afterScans.CurrScanNum != beforeScans.CurrScanNum || len(afterScans.Records) != len(beforeScans.Records) {
+					t.Errorf("failed at '%s': records changed after rollback: %+v", step, afterScans)
+				}
+			}
+			records.SetRecordStepHook(nil)
+		}
+
+		if err = records.RecordScan(scanned, d, true); err != nil {
+			t.Fatal("failed to record scan", err)
+		}
+		last = scanned
+		os.WriteFile(filepath.Join(root, "f"), make([]byte, 1000+i), 0600)
+	}
+
+	// Only the first and last scans, their indexes, 2 diffs, the records and their backup remain
+	if after := readDirContents(t, scansDir); len(after) != 8 {
+		t.Errorf("expected 8 files after 3 scans, got %d", len(after))
+	}
+	if scans := records.GetScansFull(root); scans == nil || scans.CurrScanNum != 3 || len(scans.Records) != 2 {
+		t.Errorf("expected 2 records of 3 scans, got %+v", scans)
+	}
+}
+
+// A scan that's interrupted (e.g. killed) at any step, before its records are written, leaves the
+// records and recorded scan files as they were, and only files that `Fsck` removes
+func TestRecordScanInterrupted(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory", err)
+	}
+	scansDir := t.TempDir()
+	if err = os.Chdir(scansDir); err != nil {
+		t.Fatal("failed to change directory", err)
+	}
+	defer os.Chdir(wd)
+	defer records.SetRecordStepHook(nil)
+	if err = config.Load(); err != nil {
+		t.Fatal("failed to load config", err)
+	}
+	if err = records.Load(); err != nil {
+		t.Fatal("failed to load records", err)
+	}
+
+	root := makeFormatTestDir(t)
+	first := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	if err = records.RecordScan(first, nil, true); err != nil {
+		t.Fatal("failed to record scan", err)
+	}
+	if err = os.WriteFile(filepath.Join(root, "f"), make([]byte, 1000), 0600); err != nil {
+		t.Fatal("failed to change file", err)
+	}
+	scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	d := diff.CompareTrees(first, scanned)
+
+	before := readDirContents(t, scansDir)
+	for _, step := range []string{records.StepWriteDiff, records.StepRenameFiles, records.StepFlushRecords} {
+		// A panic skips the rollback, like being killed would
+		records.SetRecordStepHook(func(s string) error {
+			if s == step {
+				panic("interrupted")
+			}
+			return nil
+		})
+		func() {
+			defer func() { recover() }()
+			records.RecordScan(scanned, &d, true)
+		}()
+		records.SetRecordStepHook(nil)
+
+		if err = records.Load(); err != nil {
+			t.Fatal("failed to load records", err)
+		}
+		if scans := records.GetScansFull(root); scans == nil || scans.CurrScanNum != 1 || records.GetScansDiff(root) != nil {
+			t.Errorf("interrupted at '%s': records changed: %+v", step, scans)
+		}
+		if _, err = records.Fsck(true); err != nil {
+			t.Fatal("failed to repair scans", err)
+		}
+		after := readDirContents(t, scansDir)
+		if len(after) != len(before) {
+			t.Errorf("interrupted at '%s': expected %d files once repaired, got %d", step, len(before), len(after))
+		}
+		for name, b := range before {
+			if !bytes.Equal(after[name], b) {
+				t.Errorf("interrupted at '%s': '%s' changed", step, name)
+			}
+		}
+	}
+
+	if err = records.RecordScan(scanned, &d, true); err != nil {
+		t.Fatal("failed to record scan", err)
+	}
+	if scans := records.GetScansFull(root); scans == nil || scans.CurrScanNum != 2 {
+		t.Errorf("expected the scan to be recorded after being interrupted, got %+v", scans)
+	}
+}
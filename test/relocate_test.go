@@ -0,0 +1,229 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pericles-tpt/seye/command"
+	"github.com/pericles-tpt/seye/config"
+	"github.com/pericles-tpt/seye/diff"
+	"github.com/pericles-tpt/seye/records"
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+// Relative paths, trailing slashes and symlinks all resolve to the same canonical path
+func TestCanonicalPath(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal("failed to resolve temp dir", err)
+	}
+	dir := filepath.Join(root, "data")
+	if err = os.Mkdir(dir, 0755); err != nil {
+		t.Fatal("failed to create directory", err)
+	}
+	if err = os.Symlink(dir, filepath.Join(root, "link")); err != nil {
+		t.Fatal("failed to create symlink", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory", err)
+	}
+	if err = os.Chdir(root); err != nil {
+		t.Fatal("failed to change directory", err)
+	}
+	defer os.Chdir(wd)
+
+	for _, p := range []string{"data", "./data/", dir + "/", "link", filepath.Join(root, "link", "..", "data")} {
+		if canonical, err := utility.CanonicalPath(p); err != nil {
+			t.Errorf("failed to get canonical path of '%s': %v", p, err)
+		} else if canonical != dir {
+			t.Errorf("expected canonical path of '%s' to be '%s', got '%s'", p, dir, canonical)
+		}
+	}
+
+	// A path that doesn't exist (any more) resolves the part that does
+	if canonical, err := utility.CanonicalPath("link/removed"); err != nil || canonical != filepath.Join(dir, "removed") {
+		t.Errorf("expected canonical path of a removed directory to be '%s', got '%s' (%v)", filepath.Join(dir, "removed"), canonical, err)
+	}
+}
+
+// The history of a moved directory continues under its new path after it's relocated
+func TestRelocate(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory", err)
+	}
+	scansDir := t.TempDir()
+	if err = os.Chdir(scansDir); err != nil {
+		t.Fatal("failed to change directory", err)
+	}
+	defer os.Chdir(wd)
+	if err = config.Load(); err != nil {
+		t.Fatal("failed to load config", err)
+	}
+	if err = records.Load(); err != nil {
+		t.Fatal("failed to load records", err)
+	}
+
+	oldRoot := makeFormatTestDir(t)
+	var last *tree.FileTree
+	for i := 0; i < 2; i++ {
+		scanned := tree.WalkGenerateTreeRecursive(oldRoot, 0, true, nil)
+		var d *diff.ScanDiff
+		if last != nil {
+			sd := diff.CompareTrees(last, scanned)
+			d = &sd
+		}
+		if err = records.RecordScan(scanned, d, true); err != nil {
+			t.Fatal("failed to record scan", err)
+		}
+		last = scanned
+	}
+	oldFiles := readDirContents(t, scansDir)
+
+	newRoot := filepath.Join(t.TempDir(), "moved")
+	if err = os.Rename(oldRoot, newRoot); err != nil {
+		t.Fatal("failed to move directory", err)
+	}
+	if err = records.Relocate(oldRoot, newRoot); err != nil {
+		t.Fatal("failed to relocate scans", err)
+	}
+
+	if records.GetScansFull(oldRoot) != nil || records.GetScansDiff(oldRoot) != nil {
+		t.Errorf("expected no scans of '%s' after relocating", oldRoot)
+	}
+	if scans := records.GetScansFull(newRoot); scans == nil || len(scans.Records) != 2 {
+		t.Fatalf("expected 2 scans of '%s' after relocating, got %+v", newRoot, scans)
+	}
+	newFiles := readDirContents(t, scansDir)
+	for name := range oldFiles {
+		if _, ok := newFiles[name]; ok && strings.HasPrefix(name, utility.HashFilePath(oldRoot)) {
+			t.Errorf("expected '%s' to be removed after relocating", name)
+		}
+	}
+
+	// The relocated scan matches a new scan of the moved directory
+	relocated, err := tree.ReadBinary(records.GetLastScanFilename(newRoot, false))
+	if err != nil {
+		t.Fatal("failed to read relocated tree", err)
+	}
+	rescanned := tree.WalkGenerateTreeRecursive(newRoot, 0, true, nil)
+	d := diff.CompareTrees(&relocated, rescanned)
+	if len(d.Files) > 0 || len(d.Trees) > 0 {
+		t.Errorf("expected no differences between the relocated scan and a new scan, got %+v", d)
+	}
+	if _, err = diff.ReadBinary(records.GetScanFilename(newRoot, 0, true)); err != nil {
+		t.Error("failed to read relocated diff", err)
+	}
+}
+
+// OLD is used as it was recorded, without resolving it, even when only its diffs are left
+func TestRelocateOnlyDiffs(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory", err)
+	}
+	scansDir := t.TempDir()
+	if err = os.Chdir(scansDir); err != nil {
+		t.Fatal("failed to change directory", err)
+	}
+	defer os.Chdir(wd)
+	if err = config.Load(); err != nil {
+		t.Fatal("failed to load config", err)
+	}
+	if err = records.Load(); err != nil {
+		t.Fatal("failed to load records", err)
+	}
+
+	// Recorded through a symlink, so it isn't canonical
+	dir := makeFormatTestDir(t)
+	oldRoot := filepath.Join(t.TempDir(), "link")
+	if err = os.Symlink(dir, oldRoot); err != nil {
+		t.Fatal("failed to create symlink", err)
+	}
+	var last *tree.FileTree
+	for i := 0; i < 2; i++ {
+		scanned := tree.WalkGenerateTreeRecursive(oldRoot, 0, true, nil)
+		var d *diff.ScanDiff
+		if last != nil {
+			sd := diff.CompareTrees(last, scanned)
+			d = &sd
+		}
+		if err = records.RecordScan(scanned, d, true); err != nil {
+			t.Fatal("failed to record scan", err)
+		}
+		last = scanned
+	}
+
+	// Losing the full scans leaves only the diffs once repaired
+	for i := 0; i < 2; i++ {
+		name := config.GetScansOutputDir() + records.GetScanFilename(oldRoot, i, false)
+		if err = os.Remove(name); err != nil {
+			t.Fatal("failed to remove full scan", err)
+		}
+		os.Remove(tree.IndexPath(name))
+	}
+	if _, err = records.Fsck(true); err != nil {
+		t.Fatal("failed to repair scans", err)
+	}
+	if records.GetScansFull(oldRoot) != nil || records.GetScansDiff(oldRoot) == nil {
+		t.Fatalf("expected only the diffs of '%s' to be left after repairing", oldRoot)
+	}
+
+	newRoot, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal("failed to resolve temp dir", err)
+	}
+	if err = command.Relocate([]string{oldRoot, newRoot}); err != nil {
+		t.Fatal("failed to relocate scans", err)
+	}
+	if records.GetScansDiff(oldRoot) != nil || records.GetScansDiff(newRoot) == nil {
+		t.Errorf("expected the diffs of '%s' to be moved to '%s'", oldRoot, newRoot)
+	}
+}
+
+// A scan of a directory continues the history recorded by another path to it, e.g. by an older
+// version of seye
+func TestScanCanonicalisesRoot(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory", err)
+	}
+	scansDir := t.TempDir()
+	if err = os.Chdir(scansDir); err != nil {
+		t.Fatal("failed to change directory", err)
+	}
+	defer os.Chdir(wd)
+	if err = config.Load(); err != nil {
+		t.Fatal("failed to load config", err)
+	}
+	if err = records.Load(); err != nil {
+		t.Fatal("failed to load records", err)
+	}
+
+	dir, err := filepath.EvalSymlinks(makeFormatTestDir(t))
+	if err != nil {
+		t.Fatal("failed to resolve temp dir", err)
+	}
+	link := filepath.Join(t.TempDir(), "link")
+	if err = os.Symlink(dir, link); err != nil {
+		t.Fatal("failed to create symlink", err)
+	}
+	if err = records.RecordScan(tree.WalkGenerateTreeRecursive(link, 0, true, nil), nil, true); err != nil {
+		t.Fatal("failed to record scan", err)
+	}
+
+	if err = command.Scan([]string{link, "-c"}); err != nil {
+		t.Fatal("failed to scan", err)
+	}
+	if records.GetScansFull(link) != nil {
+		t.Errorf("expected no scans of '%s' after scanning it", link)
+	}
+	if scans := records.GetScansFull(dir); scans == nil || len(scans.Records) != 2 || records.GetScansDiff(dir) == nil {
+		t.Errorf("expected the scan of '%s' to continue the history of '%s', got %+v", dir, link, scans)
+	}
+}
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/diff"
+	"github.com/pericles-tpt/seye/tree"
+)
+
+func TestGenerateSparseFileAllocated(t *testing.T) {
+	root := t.TempDir()
+	sparsePath := filepath.Join(root, "sparse")
+	f, err := os.Create(sparsePath)
+	if err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	if err = f.Truncate(1 << 24); err != nil {
+		t.Fatal("failed to truncate file", err)
+	}
+	f.Close()
+
+	recursiveTree := tree.WalkGenerateTreeRecursive(root, 0, false, nil)
+	iterativeDirTree := tree.WalkTreeIterativeDir(root, false, nil)
+	if notEqualReason := (*iterativeDirTree).Equal(*recursiveTree); notEqualReason != nil {
+		t.Error("tree from `WalkTreeIterativeDir` NOT equal to tree from `WalkGenerateTreeRecursive`, reason: ", notEqualReason)
+	}
+	if recursiveTree.AllocatedBelow >= recursiveTree.SizeBelow {
+		t.Skip("filesystem doesn't support sparse files")
+	}
+
+	sparseFiles := recursiveTree.GetMostSparseFiles(10)
+	if len(sparseFiles) != 1 || sparseFiles[0].Name != sparsePath {
+		t.Errorf("expected '%s' to be the only sparse file, got %+v", sparsePath, sparseFiles)
+	}
+
+	tree.SetSizeMode(tree.SizeDiskUsage)
+	defer tree.SetSizeMode(tree.SizeApparent)
+	if recursiveTree.ReportedSizeBelow() != recursiveTree.AllocatedBelow {
+		t.Error("expected reported size to be the allocated size with `SizeDiskUsage`")
+	}
+}
+
+// Filling in a sparse file, without changing its size or mtime, only changes its allocated size, the
+// diff should still record it so the replayed tree has the newer allocated sizes
+func TestDiffAllocatedOnly(t *testing.T) {
+	root := t.TempDir()
+	sparsePath := filepath.Join(root, "sparse")
+	f, err := os.Create(sparsePath)
+	if err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	defer f.Close()
+	if err = f.Truncate(1 << 24); err != nil {
+		t.Fatal("failed to truncate file", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal("failed to stat file", err)
+	}
+	originalTree := tree.WalkGenerateTreeRecursive(root, 0, false, nil)
+
+	if _, err = f.WriteAt(make([]byte, 1<<20), 0); err != nil {
+		t.Fatal("failed to write file", err)
+	}
+	if err = f.Sync(); err != nil {
+		t.Fatal("failed to sync file", err)
+	}
+	if err = os.Chtimes(sparsePath, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal("failed to touch file", err)
+	}
+	filledTree := tree.WalkGenerateTreeRecursive(root, 0, false, nil)
+	if filledTree.AllocatedBelow == originalTree.AllocatedBelow {
+		t.Skip("filesystem doesn't support sparse files")
+	}
+
+	d := diff.CompareTrees(originalTree, filledTree)
+	if fd, ok := d.Files[sparsePath]; !ok || fd.AllocatedDiff != filledTree.AllocatedBelow-originalTree.AllocatedBelow {
+		t.Fatalf("expected a diff of '%s' with its allocated size, got %+v", sparsePath, d.Files)
+	}
+
+	originalPlusDiff := originalTree.DeepCopy()
+	_ = diff.WalkAddTreeDiff(&originalPlusDiff, &d, &originalPlusDiff.AllHash, []diff.TreeDiff{}, []diff.FileDiff{})
+	if originalPlusDiff.AllocatedDirect != filledTree.AllocatedDirect || originalPlusDiff.AllocatedBelow != filledTree.AllocatedBelow {
+		t.Errorf("expected replayed allocated sizes %d/%d, got %d/%d", filledTree.AllocatedDirect, filledTree.AllocatedBelow, originalPlusDiff.AllocatedDirect, originalPlusDiff.AllocatedBelow)
+	}
+	if len(originalPlusDiff.Files) != 1 || !originalPlusDiff.Files[0].Equal(filledTree.Files[0]) {
+		t.Errorf("expected replayed '%s' to equal the newer scan's, got %+v, expected %+v", sparsePath, originalPlusDiff.Files, filledTree.Files)
+	}
+}
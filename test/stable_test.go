@@ -0,0 +1,55 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/tree"
+)
+
+// A file that's written to throughout the scan can't get a consistent hash, so it's marked as unstable
+func TestGrowingFileUnstable(t *testing.T) {
+	root := t.TempDir()
+	filePath := filepath.Join(root, "growing.log")
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	defer f.Close()
+	// Large enough that it's still being hashed while it's appended to
+	if err := f.Truncate(64 * 1024 * 1024); err != nil {
+		t.Fatal("failed to grow file", err)
+	}
+
+	// Not retried, so it's marked as unstable as soon as it changes
+	defer tree.SetMaxHashRetries(tree.GetMaxHashRetries())
+	tree.SetMaxHashRetries(0)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				f.Write([]byte("line\n"))
+			}
+		}
+	}()
+	scanned := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	close(done)
+	<-stopped
+
+	if len(scanned.Files) != 1 || scanned.Files[0].WalkErr == nil || scanned.Files[0].WalkErr.Class != tree.ClassUnstable {
+		t.Fatalf("expected '%s' to be marked as unstable, got %+v", filePath, scanned.Files)
+	}
+	if scanned.Files[0].Hash.HashOffset > -1 {
+		t.Errorf("expected no hash for unstable file '%s'", filePath)
+	}
+	if scanned.CountUnstableFiles() != 1 {
+		t.Errorf("expected 1 unstable file, got %d", scanned.CountUnstableFiles())
+	}
+}
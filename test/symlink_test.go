@@ -0,0 +1,45 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/tree"
+)
+
+// Following a symlink that points back up the tree shouldn't loop forever
+func TestGenerateFollowSymlinkCycle(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "a"), 0700); err != nil {
+		t.Fatal("failed to create dir", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "f"), []byte("contents"), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+	if err := os.Symlink(root, filepath.Join(root, "a", "loop")); err != nil {
+		t.Fatal("failed to create symlink", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "missing"), filepath.Join(root, "broken")); err != nil {
+		t.Fatal("failed to create symlink", err)
+	}
+
+	tree.SetSymlinkPolicy(tree.SymlinksFollow)
+	defer tree.SetSymlinkPolicy(tree.SymlinksSkip)
+
+	recursiveTree := tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	iterativeFileTree := tree.WalkTreeIterativeFile(root, 0, true, nil)
+	iterativeDirTree := tree.WalkTreeIterativeDir(root, true, nil)
+
+	if notEqualReason := (*iterativeFileTree).Equal(*recursiveTree); notEqualReason != nil {
+		t.Error("tree from `WalkTreeIterativeFile` NOT equal to tree from `WalkGenerateTreeRecursive`, reason: ", notEqualReason)
+	}
+	if notEqualReason := (*iterativeDirTree).Equal(*recursiveTree); notEqualReason != nil {
+		t.Error("tree from `WalkTreeIterativeDir` NOT equal to tree from `WalkGenerateTreeRecursive`, reason: ", notEqualReason)
+	}
+
+	brokenLinks := recursiveTree.GetBrokenLinks()
+	if len(brokenLinks) != 1 || brokenLinks[0].Name != filepath.Join(root, "broken") {
+		t.Errorf("expected a single broken link 'broken', got %+v", brokenLinks)
+	}
+}
@@ -0,0 +1,46 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pericles-tpt/seye/tree"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+// Hashing is limited to the max read rate, after the first second's worth of bytes
+func TestMaxReadRate(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "f"), make([]byte, 200*1000), 0600); err != nil {
+		t.Fatal("failed to create file", err)
+	}
+
+	rate, err := utility.ParseByteRate("100KB/s")
+	if err != nil || rate != 100*1000 {
+		t.Fatalf("expected '100KB/s' to be 100000 bytes per second, got %d, err: %v", rate, err)
+	}
+	tree.SetMaxReadRate(rate)
+	defer tree.SetMaxReadRate(0)
+
+	timer := time.Now()
+	tree.WalkGenerateTreeRecursive(root, 0, true, nil)
+	if took := time.Since(timer); took < 900*time.Millisecond {
+		t.Errorf("expected hashing 200KB at 100KB/s to take at least 1s, took %s", took)
+	}
+}
+
+// The "/s" suffix of a rate is optional and case-insensitive
+func TestParseByteRate(t *testing.T) {
+	for s, expected := range map[string]int64{"50MB/s": 50 * 1000 * 1000, "50mb/S": 50 * 1000 * 1000, " 1KiB/S ": 1 << 10, "4096": 4096} {
+		if rate, err := utility.ParseByteRate(s); err != nil || rate != expected {
+			t.Errorf("expected '%s' to be %d bytes per second, got %d, err: %v", s, expected, rate, err)
+		}
+	}
+	for _, s := range []string{"-1MB/s", "50XB/s", "/s"} {
+		if _, err := utility.ParseByteRate(s); err == nil {
+			t.Errorf("expected '%s' to be an invalid rate", s)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pericles-tpt/seye/tree"
+)
+
+/*
+Creates a tree with very uneven directory sizes, i.e. one directory with many
+files, many directories with a single file and a deep chain of directories
+*/
+func createUnevenTree(b *testing.B) string {
+	root := b.TempDir()
+
+	bigDir := filepath.Join(root, "big")
+	if err := os.Mkdir(bigDir, 0700); err != nil {
+		b.Fatal("failed to create `big` dir", err)
+	}
+	for i := 0; i < 2000; i++ {
+		if err := os.WriteFile(filepath.Join(bigDir, fmt.Sprintf("f%d", i)), []byte("contents"), 0600); err != nil {
+			b.Fatal("failed to create file in `big` dir", err)
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		smallDir := filepath.Join(root, fmt.Sprintf("small%d", i))
+		if err := os.Mkdir(smallDir, 0700); err != nil {
+			b.Fatal("failed to create `small` dir", err)
+		}
+		if err := os.WriteFile(filepath.Join(smallDir, "f"), []byte("contents"), 0600); err != nil {
+			b.Fatal("failed to create file in `small` dir", err)
+		}
+	}
+
+	deepDir := root
+	for i := 0; i < 50; i++ {
+		deepDir = filepath.Join(deepDir, fmt.Sprintf("deep%d", i))
+		if err := os.Mkdir(deepDir, 0700); err != nil {
+			b.Fatal("failed to create `deep` dir", err)
+		}
+	}
+
+	return root
+}
+
+func BenchmarkWalkIFUnevenShallow(b *testing.B) {
+	root := createUnevenTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.WalkTreeIterativeFile(root, 0, false, nil)
+	}
+}
+
+func BenchmarkWalkIFUnevenComprehensive(b *testing.B) {
+	root := createUnevenTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.WalkTreeIterativeFile(root, 0, true, nil)
+	}
+}
+
+func BenchmarkWalkIDUnevenShallow(b *testing.B) {
+	root := createUnevenTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.WalkTreeIterativeDir(root, false, nil)
+	}
+}
+
+func BenchmarkWalkIDUnevenComprehensive(b *testing.B) {
+	root := createUnevenTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.WalkTreeIterativeDir(root, true, nil)
+	}
+}
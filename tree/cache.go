@@ -0,0 +1,51 @@
+package tree
+
+import "os"
+
+var (
+	// Hash files without updating their atime or filling the page cache, see `openForHashing`
+	cacheFriendly = true
+)
+
+/*
+Sets whether comprehensive scans open files with `O_NOATIME` and tell the kernel not to keep
+them in the page cache after hashing (the default). Only has an effect on Linux
+*/
+func SetCacheFriendly(newVal bool) {
+	cacheFriendly = newVal
+}
+
+func GetCacheFriendly() bool {
+	return cacheFriendly
+}
+
+/*
+Opens a file to be hashed. When `cacheFriendly`, its atime isn't updated (where permitted) and
+the kernel is told it'll be read sequentially
+*/
+func openForHashing(path string) (*os.File, error) {
+	if !cacheFriendly {
+		return os.OpenFile(path, os.O_RDONLY, 0400)
+	}
+
+	f, err := openNoAtime(path)
+	if err != nil {
+		return nil, err
+	}
+	fadvise(f, fadviseSequential)
+	return f, nil
+}
+
+/*
+Closes a file opened by `openForHashing`. When `cacheFriendly`, its pages are dropped from the page
+cache first, so hashing doesn't evict the pages of other processes
+*/
+func closeAfterHashing(f *os.File) {
+	if f == nil {
+		return
+	}
+	if cacheFriendly {
+		fadvise(f, fadviseDontNeed)
+	}
+	f.Close()
+}
@@ -0,0 +1,19 @@
+package tree
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+/*
+Opens a file without updating its atime. `O_NOATIME` is only permitted for the file's owner (or
+with `CAP_FOWNER`), otherwise it's opened normally
+*/
+func openNoAtime(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOATIME, 0400)
+	if errors.Is(err, syscall.EPERM) {
+		return os.OpenFile(path, os.O_RDONLY, 0400)
+	}
+	return f, err
+}
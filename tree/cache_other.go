@@ -0,0 +1,12 @@
+//go:build !linux
+
+package tree
+
+import "os"
+
+/*
+`O_NOATIME` is specific to Linux
+*/
+func openNoAtime(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDONLY, 0400)
+}
@@ -0,0 +1,40 @@
+package tree
+
+import "fmt"
+
+/*
+Checks the totals of each directory in the tree add up and that each file's hash is inside
+`AllHash`, returning a problem for each directory or file that doesn't. Only `t` has `AllHash`, so
+the hashes of its subtrees are checked against it
+*/
+func (t *FileTree) CheckInvariants() []error {
+	return t.checkInvariants(len(t.AllHash))
+}
+
+func (t *FileTree) checkInvariants(allHashLen int) []error {
+	var (
+		problems      = []error{}
+		sizeBelow     = t.SizeDirect
+		numFilesBelow = t.NumFilesDirect
+	)
+	for _, st := range t.SubTrees {
+		sizeBelow += st.SizeBelow
+		numFilesBelow += st.NumFilesBelow
+		problems = append(problems, st.checkInvariants(allHashLen)...)
+	}
+
+	if t.SizeBelow != sizeBelow {
+		problems = append(problems, fmt.Errorf("'%s': `SizeBelow` is %d, expected %d from `SizeDirect` and its subdirectories", t.BasePath, t.SizeBelow, sizeBelow))
+	}
+	if t.NumFilesBelow != numFilesBelow {
+		problems = append(problems, fmt.Errorf("'%s': `NumFilesBelow` is %d, expected %d from `NumFilesDirect` and its subdirectories", t.BasePath, t.NumFilesBelow, numFilesBelow))
+	}
+	for _, f := range t.Files {
+		hl := f.Hash
+		if hl.HashOffset > -1 && (hl.HashLength < 0 || hl.HashOffset+hl.HashLength > allHashLen) {
+			problems = append(problems, fmt.Errorf("'%s': hash at offset %d, length %d is outside `AllHash` (length %d)", f.Name, hl.HashOffset, hl.HashLength, allHashLen))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,162 @@
+package tree
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pericles-tpt/seye/utility"
+)
+
+const (
+	defaultChunkedHashThreshold int64 = 1 << 30
+	defaultHashChunkSize        int64 = 64 << 20
+)
+
+var (
+	// Files at least this large are hashed in chunks, 0 disables chunked hashing
+	chunkedHashThreshold = defaultChunkedHashThreshold
+	hashChunkSize        = defaultHashChunkSize
+	// Workers hashing chunks, shared by every file being hashed so the number of concurrent reads
+	// is bounded however many files are hashed at once
+	numChunkWorkers = maxNumThreadsComprehensive
+	chunkJobs       chan chunkJob
+	startChunkPool  sync.Once
+)
+
+/*
+A chunk of a file to hash, `done` is called with the chunk's hash and the number of bytes read
+*/
+type chunkJob struct {
+	f      *os.File
+	offset int64
+	size   int64
+	done   func(hash []byte, n int64, err error)
+}
+
+/*
+Sets the size files must be (`threshold`) to be hashed in chunks of `chunkSize` on multiple
+workers, rather than sequentially. A `threshold` of 0 disables chunked hashing
+*/
+func SetChunkedHashing(threshold, chunkSize int64) {
+	chunkedHashThreshold = threshold
+	if chunkSize > 0 {
+		hashChunkSize = chunkSize
+	}
+}
+
+func GetChunkedHashThreshold() int64 {
+	return chunkedHashThreshold
+}
+
+/*
+Records the chunked hashing parameters the tree was hashed with, so hashes are only compared with
+those of scans hashed the same way
+*/
+func recordChunkedHashing(t *FileTree) {
+	t.HashChunkThreshold = chunkedHashThreshold
+	t.HashChunkSize = hashChunkSize
+}
+
+/*
+The chunked hashing parameters of a scan from its header, scans written before they were recorded
+hashed every file whole, i.e. with chunked hashing disabled
+*/
+func chunkedHashingOf(h *utility.FileHeader) (threshold, chunkSize int64) {
+	if h == nil || h.HashChunkSize == 0 {
+		return 0, 0
+	}
+	return h.HashChunkThreshold, h.HashChunkSize
+}
+
+/*
+Hashes the contents of `f`, sequentially using `buf` or, if it's at least `chunkedHashThreshold`
+bytes, in chunks (see `hashChunked`). Returns the hash, its type and the number of bytes read
+*/
+func hashContents(f *os.File, size int64, buf []byte) ([]byte, utility.HashType, int64, error) {
+	if chunkedHashThreshold > 0 && size >= chunkedHashThreshold {
+		hash, n, err := hashChunked(f, size)
+		return hash, utility.SHA256Chunked, n, err
+	}
+
+	h := sha256.New()
+	n, err := io.CopyBuffer(h, throttleReads(f), buf)
+	if err != nil {
+		return nil, utility.SHA256, n, err
+	}
+	return h.Sum(nil), utility.SHA256, n, nil
+}
+
+/*
+Splits the first `size` bytes of `f` into `hashChunkSize` chunks and hashes them on the shared chunk
+workers (see `submitChunk`). The root hash is the SHA256 of the chunks' SHA256s, in order, so it only
+depends on the file's contents and `hashChunkSize`
+*/
+func hashChunked(f *os.File, size int64) ([]byte, int64, error) {
+	var (
+		chunkSize   = hashChunkSize
+		numChunks   = int((size + chunkSize - 1) / chunkSize)
+		chunkHashes = make([]byte, numChunks*sha256.Size)
+		wg          sync.WaitGroup
+		lock        sync.Mutex
+		totalRead   int64
+		firstErr    error
+	)
+	wg.Add(numChunks)
+	for i := 0; i < numChunks; i++ {
+		i := i
+		submitChunk(chunkJob{
+			f:      f,
+			offset: int64(i) * chunkSize,
+			size:   chunkSize,
+			done: func(hash []byte, n int64, err error) {
+				defer wg.Done()
+				lock.Lock()
+				defer lock.Unlock()
+				totalRead += n
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					return
+				}
+				copy(chunkHashes[i*sha256.Size:], hash)
+			},
+		})
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, totalRead, firstErr
+	}
+
+	root := sha256.Sum256(chunkHashes)
+	return root[:], totalRead, nil
+}
+
+/*
+Queues a chunk to be hashed by the shared pool of `numChunkWorkers` workers, starting them the first
+time it's called. Blocks until a worker takes the chunk
+*/
+func submitChunk(j chunkJob) {
+	startChunkPool.Do(func() {
+		chunkJobs = make(chan chunkJob)
+		for w := 0; w < numChunkWorkers; w++ {
+			go chunkWorker()
+		}
+	})
+	chunkJobs <- j
+}
+
+func chunkWorker() {
+	buf := make([]byte, copyBufferLen)
+	for j := range chunkJobs {
+		h := sha256.New()
+		n, err := io.CopyBuffer(h, throttleReads(io.NewSectionReader(j.f, j.offset, j.size)), buf)
+		if err != nil {
+			j.done(nil, n, err)
+			continue
+		}
+		j.done(h.Sum(nil), n, nil)
+	}
+}
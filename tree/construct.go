@@ -2,6 +2,7 @@ package tree
 
 import (
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 
 type BubbleUpProps struct {
 	Size          int64
+	Allocated     int64
 	NewestModtime time.Time
 	NumFiles      int64
 }
@@ -50,10 +52,12 @@ func constructTreeFromIterativeQ(buildQ *[]FileTree) FileTree {
 			ok  bool
 		)
 		t.SizeBelow = t.SizeDirect
+		t.AllocatedBelow = t.AllocatedDirect
 		t.LastModifiedBelow = t.LastModifiedDirect
 		t.NumFilesBelow = t.NumFilesDirect
 		if bup, ok = childProps[t.BasePath]; ok {
 			t.SizeBelow = t.SizeDirect + bup.Size
+			t.AllocatedBelow = t.AllocatedDirect + bup.Allocated
 			t.LastModifiedBelow = utility.GetNewestTime(bup.NewestModtime, t.LastModifiedBelow)
 			t.NumFilesBelow = t.NumFilesDirect + bup.NumFiles
 		}
@@ -68,14 +72,16 @@ func constructTreeFromIterativeQ(buildQ *[]FileTree) FileTree {
 			// its properties from its child
 			if bup, ok = childProps[parentDir]; ok {
 				childProps[parentDir] = BubbleUpProps{
-					NewestModtime: t.LastModifiedBelow,
-					Size:          t.SizeBelow,
-					NumFiles:      t.NumFilesBelow,
+					NewestModtime: utility.GetNewestTime(bup.NewestModtime, t.LastModifiedBelow),
+					Size:          bup.Size + t.SizeBelow,
+					Allocated:     bup.Allocated + t.AllocatedBelow,
+					NumFiles:      bup.NumFiles + t.NumFilesBelow,
 				}
 			} else {
 				childProps[parentDir] = BubbleUpProps{
 					NewestModtime: t.LastModifiedBelow,
 					Size:          t.SizeBelow,
+					Allocated:     t.AllocatedBelow,
 					NumFiles:      t.NumFilesBelow,
 				}
 			}
@@ -93,3 +99,52 @@ func constructTreeFromIterativeQ(buildQ *[]FileTree) FileTree {
 func prepend(trees []FileTree, new FileTree) []FileTree {
 	return append([]FileTree{new}, trees...)
 }
+
+/*
+Sorts each depth of an "Iterative" walk's build queue by `BasePath`, so that
+subtrees end up in the same (lexical) order as `os.ReadDir` returns them,
+regardless of which thread finished first
+*/
+func sortBuildQLevels(buildQ [][]FileTree) {
+	for _, level := range buildQ {
+		sort.SliceStable(level, func(i, j int) bool {
+			return level[i].BasePath < level[j].BasePath
+		})
+	}
+}
+
+/*
+Rewrites a tree's `AllHash` so each file's hash is in the same position as it
+would be for a single threaded, breadth first, walk of the tree (i.e. root files
+first, then the files of each subtree in order, level by level). This makes
+`AllHash` deterministic for walks where threads reserve hash space in whatever
+order they finish in
+*/
+func relayoutAllHash(t *FileTree) {
+	if !t.Comprehensive {
+		return
+	}
+
+	var (
+		newAllHash = make([]byte, 0, len(t.AllHash))
+		level      = []*FileTree{t}
+	)
+	for len(level) > 0 {
+		nextLevel := []*FileTree{}
+		for _, st := range level {
+			for i, f := range st.Files {
+				offset := len(newAllHash)
+				newAllHash = append(newAllHash, make([]byte, chosenHash)...)
+				if f.Hash.HashOffset > -1 {
+					copy(newAllHash[offset:], t.AllHash[f.Hash.HashOffset:f.Hash.HashOffset+f.Hash.HashLength])
+					st.Files[i].Hash.HashOffset = offset
+				}
+			}
+			for i := range st.SubTrees {
+				nextLevel = append(nextLevel, &st.SubTrees[i])
+			}
+		}
+		level = nextLevel
+	}
+	t.AllHash = newAllHash
+}
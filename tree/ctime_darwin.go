@@ -0,0 +1,18 @@
+package tree
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+/*
+Gets the time a file's inode last changed from a `stat`
+*/
+func getCtime(info os.FileInfo) (time.Time, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(st.Ctimespec.Unix()), true
+}
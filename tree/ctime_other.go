@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package tree
+
+import (
+	"os"
+	"time"
+)
+
+/*
+The inode change time isn't read on this platform, so only size and mtime are compared
+*/
+func getCtime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
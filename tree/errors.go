@@ -0,0 +1,192 @@
+package tree
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+	"syscall"
+)
+
+/*
+The operation that failed on a path during a walk
+*/
+const (
+	OpReadDir = "readdir"
+	OpStat    = "stat"
+	OpOpen    = "open"
+	OpRead    = "read"
+)
+
+/*
+A broad class of the cause of a `WalkError`, so errors can be grouped without
+matching on their messages
+*/
+const (
+	ClassPermission = "permission"
+	ClassNotExist   = "notExist" // e.g. the path was removed during the walk
+	ClassIO         = "io"
+	ClassUnstable   = "unstable" // the file kept changing while being hashed, see `hashStable`
+	ClassOther      = "other"
+)
+
+/*
+An error encountered on a path during a walk. Errors on directories (i.e. `readdir`) are
+stored in their `FileTree.Errors`, errors on files are stored in their `File.WalkErr`
+*/
+type WalkError struct {
+	Path    string
+	Op      string
+	Class   string
+	Message string
+}
+
+func (e WalkError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Message
+}
+
+/*
+Identifies the same error on the same path across scans, ignoring its `Message`
+*/
+func (e WalkError) key() string {
+	return e.Path + "\x00" + e.Op + "\x00" + e.Class
+}
+
+func newWalkError(path, op string, err error) WalkError {
+	class := ClassOther
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		class = ClassPermission
+	case errors.Is(err, fs.ErrNotExist):
+		class = ClassNotExist
+	case errors.Is(err, syscall.EIO):
+		class = ClassIO
+	case errors.Is(err, errFileUnstable):
+		class = ClassUnstable
+	}
+
+	// The op and path are already in `WalkError`, keep only the cause
+	msg := err.Error()
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		msg = pe.Err.Error()
+	}
+
+	return WalkError{
+		Path:    path,
+		Op:      op,
+		Class:   class,
+		Message: msg,
+	}
+}
+
+/*
+The `WalkError` of a file, from its error string in an older `gob` scan or diff, only its message
+is known
+*/
+func LegacyWalkError(path, msg string) *WalkError {
+	if msg == "" {
+		return nil
+	}
+	return &WalkError{
+		Path:    path,
+		Class:   ClassOther,
+		Message: msg,
+	}
+}
+
+/*
+Moves the `Err` of each file in a tree, decoded from an older `gob` scan, to its `WalkErr`
+*/
+func (t *FileTree) upgradeLegacyErrors() {
+	for i := range t.Files {
+		f := &t.Files[i]
+		if f.WalkErr == nil {
+			f.WalkErr = LegacyWalkError(f.Name, f.Err)
+		}
+		f.Err = ""
+	}
+	for i := range t.SubTrees {
+		t.SubTrees[i].upgradeLegacyErrors()
+	}
+}
+
+func WalkErrorsEqual(a, b *WalkError) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+/*
+Collects every `WalkError` in the tree, from both its directories and files, sorted by
+`Class`, then `Op`, then `Path`
+*/
+func (t *FileTree) GetErrors() []WalkError {
+	var (
+		errs       = []WalkError{}
+		collectErr func(st *FileTree)
+	)
+	collectErr = func(st *FileTree) {
+		errs = append(errs, st.Errors...)
+		for _, f := range st.Files {
+			if f.WalkErr != nil {
+				errs = append(errs, *f.WalkErr)
+			}
+		}
+		for i := range st.SubTrees {
+			collectErr(&st.SubTrees[i])
+		}
+	}
+	collectErr(t)
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Class != errs[j].Class {
+			return errs[i].Class < errs[j].Class
+		}
+		if errs[i].Op != errs[j].Op {
+			return errs[i].Op < errs[j].Op
+		}
+		return errs[i].Path < errs[j].Path
+	})
+
+	return errs
+}
+
+/*
+Compares the errors in two scans of the same tree, returning the errors only in `newer`
+(`appeared`) and those only in `older` (`resolved`)
+*/
+func GetErrorChanges(older, newer *FileTree) (appeared []WalkError, resolved []WalkError) {
+	return DiffWalkErrors(older.GetErrors(), newer.GetErrors())
+}
+
+/*
+Returns the errors only in `newer` (`appeared`) and those only in `older` (`resolved`),
+errors are the same if they have the same `Path`, `Op` and `Class`
+*/
+func DiffWalkErrors(older, newer []WalkError) (appeared []WalkError, resolved []WalkError) {
+	var (
+		olderKeys = make(map[string]struct{}, len(older))
+		newerKeys = make(map[string]struct{}, len(newer))
+	)
+	for _, e := range older {
+		olderKeys[e.key()] = struct{}{}
+	}
+	for _, e := range newer {
+		newerKeys[e.key()] = struct{}{}
+	}
+
+	appeared, resolved = []WalkError{}, []WalkError{}
+	for _, e := range newer {
+		if _, ok := olderKeys[e.key()]; !ok {
+			appeared = append(appeared, e)
+		}
+	}
+	for _, e := range older {
+		if _, ok := newerKeys[e.key()]; !ok {
+			resolved = append(resolved, e)
+		}
+	}
+
+	return appeared, resolved
+}
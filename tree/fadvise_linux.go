@@ -0,0 +1,21 @@
+//go:build linux && (amd64 || arm64)
+
+package tree
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	fadviseSequential = 2 // POSIX_FADV_SEQUENTIAL
+	fadviseDontNeed   = 4 // POSIX_FADV_DONTNEED
+)
+
+/*
+Advises the kernel how the whole of `f` will be accessed (see `posix_fadvise(2)`), it's only
+a hint so errors are ignored
+*/
+func fadvise(f *os.File, advice int) {
+	syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, uintptr(advice), 0, 0)
+}
@@ -0,0 +1,16 @@
+//go:build !linux || !(amd64 || arm64)
+
+package tree
+
+import "os"
+
+const (
+	fadviseSequential = 0
+	fadviseDontNeed   = 0
+)
+
+/*
+`posix_fadvise` isn't called on this platform, the `fadvise64` syscall's arguments differ between
+architectures
+*/
+func fadvise(f *os.File, advice int) {}
@@ -1,13 +1,16 @@
 package tree
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/gob"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/joomcode/errorx"
+	"github.com/pericles-tpt/seye/utility"
 )
 
 /*
@@ -29,17 +32,52 @@ func (tree *FileTree) DeepCopy() FileTree {
 	return newTree
 }
 
+/*
+Writes the tree's header (see `NewHeader`) then the tree to `path` in the compact binary format (see
+`EncodeTree`), each compressed on their own at the `utility.GetCompressionLevel`, and its index to
+`IndexPath(path)`.
+Both are written atomically (see `utility.WriteFileAtomic`), any existing index is removed first so
+it's never left out of date
+*/
 func (tree *FileTree) WriteBinary(path string) error {
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err := os.Remove(IndexPath(path)); err != nil && !os.IsNotExist(err) {
+		return errorx.Decorate(err, "failed to remove old index of FileTree data")
+	}
+
+	var (
+		entries       []indexEntry
+		allHashLength int
+	)
+	err := utility.WriteFileAtomic(path, 0600, func(w io.Writer) error {
+		cw := &countingWriter{w: w}
+		hw, err := utility.NewCompressedWriter(cw)
+		if err != nil {
+			return errorx.Decorate(err, "failed to create compressor for FileTree data")
+		}
+		if _, err = utility.WriteFileHeader(hw, tree.NewHeader()); err != nil {
+			return errorx.Decorate(err, "failed to write FileTree header")
+		}
+		if err = hw.Close(); err != nil {
+			return err
+		}
+		entries, allHashLength, err = encodeTree(cw, tree)
+		return err
+	})
 	if err != nil {
-		return errorx.Decorate(err, "failed to open/create file for writing FileTree data")
+		return errorx.Decorate(err, "failed to write FileTree data")
 	}
-	defer f.Close()
 
-	ge := gob.NewEncoder(f)
-	return ge.Encode(tree)
+	err = writeIndex(IndexPath(path), tree.Comprehensive, allHashLength, entries)
+	if err != nil {
+		return errorx.Decorate(err, "failed to write index of FileTree data")
+	}
+	return nil
 }
 
+/*
+Reads a tree written by `WriteBinary`, or by older versions of seye (without a header, or as an
+uncompressed `gob`)
+*/
 func ReadBinary(path string) (FileTree, error) {
 	var tree FileTree
 	f, err := os.OpenFile(path, os.O_RDONLY, 0400)
@@ -48,9 +86,90 @@ func ReadBinary(path string) (FileTree, error) {
 	}
 	defer f.Close()
 
-	gd := gob.NewDecoder(f)
-	err = gd.Decode(&tree)
-	return tree, err
+	br, err := utility.NewDecompressedReader(bufio.NewReader(f))
+	if err != nil {
+		return tree, errorx.Decorate(err, "failed to decompress FileTree data")
+	}
+	h, _, err := readTreeHeader(br)
+	if err != nil {
+		return tree, errorx.Decorate(err, "failed to read '%s'", path)
+	}
+	tr, err := newTreeReader(br, nil)
+	if err == errNotTreeFormat {
+		gd := gob.NewDecoder(br)
+		err = gd.Decode(&tree)
+		tree.upgradeLegacyErrors()
+		tree.HashChunkThreshold, tree.HashChunkSize = chunkedHashingOf(h)
+		return tree, err
+	} else if err != nil {
+		return tree, errorx.Decorate(err, "failed to read FileTree header")
+	}
+	tr.Header = h
+
+	return tr.ReadAll()
+}
+
+/*
+Rewrites a `.tree` file written by an older version of seye (i.e. without a header, or as a `gob`)
+in the current format. Returns false if it's already in the current format
+*/
+func MigrateTree(path string) (bool, error) {
+	isCurrent, err := isCurrentTreeFormat(path)
+	if err != nil || isCurrent {
+		return false, err
+	}
+
+	tree, err := ReadBinary(path)
+	if err != nil {
+		return false, err
+	}
+	return true, tree.WriteBinary(path)
+}
+
+/*
+Whether the `.tree` file at `path` is in the current format, with a header
+*/
+func isCurrentTreeFormat(path string) (bool, error) {
+	tr, err := OpenTreeReader(path)
+	if err == errNotTreeFormat {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer tr.Close()
+
+	return tr.Header != nil && tr.Header.FormatVersion == treeFormatVersion, nil
+}
+
+/*
+Populates the metadata of `nf` from its `stat`
+*/
+func setFileStat(nf *File, info os.FileInfo) {
+	nf.Size = info.Size()
+	nf.Allocated = info.Size()
+	if allocated, ok := getAllocated(info); ok {
+		nf.Allocated = allocated
+	}
+	nf.LastModified = info.ModTime()
+	nf.Mode = info.Mode()
+	nf.Uid, nf.Gid, _ = getOwner(info)
+	if id, ok := GetDevIno(info); ok {
+		nf.Dev = id.Dev
+		nf.Ino = id.Ino
+	}
+	if nlink, ok := getNlink(info); ok {
+		nf.Nlink = nlink
+	}
+}
+
+/*
+Removes the trailing "/" from the root of a walk, unless it's the filesystem root
+*/
+func trimRootPath(rootPath string) string {
+	if rootPath == "/" {
+		return rootPath
+	}
+	return strings.TrimSuffix(rootPath, "/")
 }
 
 func getFullPath(dir, basepath string) string {
@@ -0,0 +1,874 @@
+package tree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pericles-tpt/seye/utility"
+)
+
+/*
+The compact binary format of a `.tree` file, written by `WriteBinary` and `WalkWriteTree`:
+
+	header: "SEYT" | version | Comprehensive
+	body:   an open record for each `FileTree` in pre-order, and a close record after its subtrees
+	end:    len(AllHash)
+
+An open record has the directory's own fields and its files, a close record has the fields that are
+only known after its subtrees have been walked (its errors, and the totals below it). So a walk can
+write each directory as it goes, without the whole tree in memory (see `TreeWriter`).
+
+Integers (sizes, times, ids, etc) are varints. Strings are interned within each record, the first
+occurrence of a string is written in full after a new id, later occurrences only write the id. The
+ids restart at each record, so a directory can be decoded from its offset alone (see `OpenIndexed`).
+The `BasePath` of a subtree and the `Name` of a file are written as just their last path component
+when they're in their parent's directory (which they almost always are), so each directory name is
+only stored once.
+
+File hashes are written alongside each file, so neither the writer nor `TreeReader` need the whole
+tree in memory.
+
+The body is written in blocks of up to `dirsPerBlock` directories, each compressed on its own (i.e.
+as its own `gzip` member), so a directory can be read by decompressing just the block it starts in
+(see `IndexPath`)
+*/
+const (
+	treeFormatMagic   = "SEYT"
+	treeFormatVersion = 4
+)
+
+// The records of the body of a tree
+const (
+	tagEnd uint64 = iota
+	tagOpen
+	tagClose
+)
+
+// The most directories opened in each block of a tree's body
+var dirsPerBlock = 256
+
+func SetDirsPerBlock(newVal int) {
+	dirsPerBlock = newVal
+}
+
+func GetDirsPerBlock() int {
+	return dirsPerBlock
+}
+
+// Longer strings or hashes than this are treated as corrupt, rather than allocated
+const maxEncodedBytesLen = 1 << 24
+
+// The algorithm of file hashes, see `chosenHash`
+const hashAlgorithm = "sha256"
+
+var errNotTreeFormat = errors.New("not a SEYT tree file")
+
+/*
+A directory of a tree read by `TreeReader`, its `SubTrees` are the directories opened before it's
+closed. Its `Errors`, and the fields totalled below it, are only read when it's closed
+*/
+type TreeNode struct {
+	FileTree
+	// The hash bytes of each of `Files`, nil for files without a hash
+	Hashes [][]byte
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+/*
+Compresses what's written to it in blocks, each compressed on its own, so each block can be
+decompressed without those before it
+*/
+type blockWriter struct {
+	cw *countingWriter
+	zw io.WriteCloser
+
+	// The offset of the current block in `cw`, and how many bytes have been written to it before
+	// they're compressed
+	start int64
+	n     int64
+}
+
+func newBlockWriter(cw *countingWriter) (*blockWriter, error) {
+	zw, err := utility.NewCompressedWriter(cw)
+	if err != nil {
+		return nil, err
+	}
+	return &blockWriter{cw: cw, zw: zw, start: cw.n}, nil
+}
+
+func (b *blockWriter) Write(p []byte) (int, error) {
+	n, err := b.zw.Write(p)
+	b.n += int64(n)
+	return n, err
+}
+
+/*
+Ends the current block, starting the next one
+*/
+func (b *blockWriter) next() error {
+	if err := b.zw.Close(); err != nil {
+		return err
+	}
+	zw, err := utility.NewCompressedWriter(b.cw)
+	if err != nil {
+		return err
+	}
+	b.zw, b.start, b.n = zw, b.cw.n, 0
+	return nil
+}
+
+func (b *blockWriter) Close() error {
+	return b.zw.Close()
+}
+
+type encoder struct {
+	w       *bufio.Writer
+	strings map[string]uint64
+	buf     [binary.MaxVarintLen64]byte
+	err     error
+}
+
+func (e *encoder) uvarint(v uint64) {
+	if e.err != nil {
+		return
+	}
+	n := binary.PutUvarint(e.buf[:], v)
+	_, e.err = e.w.Write(e.buf[:n])
+}
+
+func (e *encoder) varint(v int64) {
+	if e.err != nil {
+		return
+	}
+	n := binary.PutVarint(e.buf[:], v)
+	_, e.err = e.w.Write(e.buf[:n])
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.uvarint(1)
+	} else {
+		e.uvarint(0)
+	}
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(b)
+}
+
+func (e *encoder) str(s string) {
+	if id, ok := e.strings[s]; ok {
+		e.uvarint(id)
+		return
+	}
+	id := uint64(len(e.strings))
+	e.strings[s] = id
+	e.uvarint(id)
+	e.bytes([]byte(s))
+}
+
+func (e *encoder) time(t time.Time) {
+	e.varint(t.Unix())
+	e.uvarint(uint64(t.Nanosecond()))
+}
+
+/*
+Writes `p` as its last component if it's directly in the `parent` directory, otherwise in full
+*/
+func (e *encoder) path(parent, p string) {
+	if parent != "" {
+		prefix := getFullPath(parent, "")
+		name := strings.TrimPrefix(p, prefix)
+		if len(name) < len(p) && name != "" && !strings.Contains(name, "/") {
+			e.bool(true)
+			e.str(name)
+			return
+		}
+	}
+	e.bool(false)
+	e.str(p)
+}
+
+func (e *encoder) walkError(we WalkError) {
+	e.str(we.Path)
+	e.str(we.Op)
+	e.str(we.Class)
+	e.str(we.Message)
+}
+
+/*
+Writes a tree in the compact binary format, one directory at a time. Each directory is opened (see
+`Open`) once its files have been walked, then closed (see `Close`) after its subtrees have been
+written
+*/
+type TreeWriter struct {
+	e  *encoder
+	bw *blockWriter
+
+	comprehensive bool
+	numFiles      int64
+	// The paths of the directories opened but not yet closed
+	parents []string
+
+	// The directories opened in the current block
+	blockDirs int
+	// The offset of each directory, in the order they're written
+	index []indexEntry
+}
+
+/*
+Starts writing a tree to `w`, compressed at the `utility.GetCompressionLevel`. Any error writing it
+is returned by a later call
+*/
+func NewTreeWriter(w io.Writer, comprehensive bool) *TreeWriter {
+	return newTreeWriter(&countingWriter{w: w}, comprehensive)
+}
+
+/*
+Starts writing a tree to `cw`, the offsets of its blocks include what's already been written to `cw`
+*/
+func newTreeWriter(cw *countingWriter, comprehensive bool) *TreeWriter {
+	tw := &TreeWriter{comprehensive: comprehensive}
+	bw, err := newBlockWriter(cw)
+	if err != nil {
+		tw.e = &encoder{w: bufio.NewWriter(io.Discard), err: err}
+		return tw
+	}
+	tw.bw = bw
+	tw.e = &encoder{w: bufio.NewWriter(bw)}
+	_, tw.e.err = tw.e.w.WriteString(treeFormatMagic)
+	tw.e.uvarint(treeFormatVersion)
+	tw.e.bool(comprehensive)
+	return tw
+}
+
+/*
+Starts the next block once the current one has `dirsPerBlock` directories, returning where the next
+directory starts
+*/
+func (tw *TreeWriter) nextDirOffset() indexEntry {
+	e := tw.e
+	if e.err == nil && tw.blockDirs == dirsPerBlock {
+		if e.err = e.w.Flush(); e.err == nil {
+			e.err = tw.bw.next()
+		}
+		tw.blockDirs = 0
+	}
+	tw.blockDirs++
+	if e.err != nil {
+		return indexEntry{}
+	}
+	return indexEntry{block: tw.bw.start, offset: tw.bw.n + int64(e.w.Buffered())}
+}
+
+/*
+Writes the directory `t` and its files, with their hashes from `allHash`. Its subtrees are written
+after it, up until it's closed. Only the fields of `t` that are known before its subtrees are walked
+are written, the rest are written by `Close`
+*/
+func (tw *TreeWriter) Open(t *FileTree, allHash []byte) error {
+	e := tw.e
+	parentPath := ""
+	if len(tw.parents) > 0 {
+		parentPath = tw.parents[len(tw.parents)-1]
+	}
+	tw.parents = append(tw.parents, t.BasePath)
+	tw.numFiles += int64(len(t.Files))
+
+	ie := tw.nextDirOffset()
+	ie.path = t.BasePath
+	tw.index = append(tw.index, ie)
+	e.strings = map[string]uint64{}
+
+	e.uvarint(tagOpen)
+	e.path(parentPath, t.BasePath)
+	e.bool(t.Comprehensive)
+	e.varint(int64(t.Depth))
+	e.uvarint(t.Dev)
+	e.uvarint(uint64(t.Uid))
+	e.uvarint(uint64(t.Gid))
+	e.uvarint(uint64(t.Mode))
+
+	e.time(t.LastModifiedDirect)
+	e.varint(t.SizeDirect)
+	e.varint(t.AllocatedDirect)
+	e.varint(t.NumFilesDirect)
+
+	e.uvarint(uint64(len(t.Files)))
+	for _, f := range t.Files {
+		e.path(t.BasePath, f.Name)
+		hl := f.Hash
+		hasHash := hl.HashOffset > -1 && hl.HashOffset+hl.HashLength <= len(allHash)
+		e.bool(hasHash)
+		if hasHash {
+			e.uvarint(uint64(hl.Type))
+			e.bytes(allHash[hl.HashOffset : hl.HashOffset+hl.HashLength])
+		}
+		e.varint(f.Size)
+		e.varint(f.Allocated)
+		e.bool(f.WalkErr != nil)
+		if f.WalkErr != nil {
+			e.walkError(*f.WalkErr)
+		}
+		e.time(f.LastModified)
+		e.uvarint(uint64(f.Uid))
+		e.uvarint(uint64(f.Gid))
+		e.uvarint(uint64(f.Mode))
+		e.str(f.LinkTarget)
+		e.bool(f.LinkBroken)
+		e.uvarint(f.Dev)
+		e.uvarint(f.Ino)
+		e.uvarint(f.Nlink)
+	}
+	return e.err
+}
+
+/*
+Writes the rest of the fields of `t`, the last directory opened
+*/
+func (tw *TreeWriter) Close(t *FileTree) error {
+	e := tw.e
+	if len(tw.parents) == 0 || tw.parents[len(tw.parents)-1] != t.BasePath {
+		return fmt.Errorf("can't close '%s', it's not the last directory opened", t.BasePath)
+	}
+	tw.parents = tw.parents[:len(tw.parents)-1]
+	e.strings = map[string]uint64{}
+
+	e.uvarint(tagClose)
+	e.uvarint(uint64(len(t.Errors)))
+	for _, we := range t.Errors {
+		e.walkError(we)
+	}
+	e.time(t.LastVisited)
+	e.varint(int64(t.TimeTaken))
+	e.time(t.LastModifiedBelow)
+	e.varint(t.SizeBelow)
+	e.varint(t.AllocatedBelow)
+	e.varint(t.NumFilesBelow)
+	e.varint(t.AllHashOffset)
+	return e.err
+}
+
+/*
+Writes `t`, and all of its subtrees, with their hashes from `allHash`
+*/
+func (tw *TreeWriter) WriteTree(t *FileTree, allHash []byte) error {
+	if err := tw.Open(t, allHash); err != nil {
+		return err
+	}
+	for i := range t.SubTrees {
+		if err := tw.WriteTree(&t.SubTrees[i], allHash); err != nil {
+			return err
+		}
+	}
+	return tw.Close(t)
+}
+
+/*
+Ends the tree, once its root has been closed. `allHashLength` is the length of the `AllHash` the
+hashes written were from
+*/
+func (tw *TreeWriter) Finish(allHashLength int) error {
+	if len(tw.parents) > 0 {
+		return fmt.Errorf("can't finish the tree, '%s' hasn't been closed", tw.parents[len(tw.parents)-1])
+	}
+	tw.e.uvarint(tagEnd)
+	tw.e.uvarint(uint64(allHashLength))
+	if tw.e.err != nil {
+		return tw.e.err
+	}
+	if err := tw.e.w.Flush(); err != nil {
+		return err
+	}
+	return tw.bw.Close()
+}
+
+/*
+The length of the `AllHash` of the tree when it's read back by `ReadAll`, see `relayoutAllHash`
+*/
+func (tw *TreeWriter) readAllHashLength(allHashLength int) int {
+	if tw.comprehensive && allHashLength > 0 {
+		return int(tw.numFiles) * chosenHash
+	}
+	return allHashLength
+}
+
+/*
+Writes `t` to `w` in the compact binary format, one directory at a time, see `NewTreeWriter`
+*/
+func EncodeTree(w io.Writer, t *FileTree) error {
+	_, _, err := encodeTree(&countingWriter{w: w}, t)
+	return err
+}
+
+/*
+Writes `t` to `cw` like `EncodeTree`, returning the offset of each directory for its index, and the
+length of its `AllHash` when it's read back
+*/
+func encodeTree(cw *countingWriter, t *FileTree) ([]indexEntry, int, error) {
+	tw := newTreeWriter(cw, t.Comprehensive)
+	if err := tw.WriteTree(t, t.AllHash); err != nil {
+		return nil, 0, err
+	}
+	if err := tw.Finish(len(t.AllHash)); err != nil {
+		return nil, 0, err
+	}
+	return tw.index, tw.readAllHashLength(len(t.AllHash)), nil
+}
+
+type decoder struct {
+	r       *bufio.Reader
+	strings []string
+	err     error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	var v uint64
+	v, d.err = binary.ReadUvarint(d.r)
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	var v int64
+	v, d.err = binary.ReadVarint(d.r)
+	return v
+}
+
+func (d *decoder) bool() bool {
+	return d.uvarint() != 0
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	} else if n > maxEncodedBytesLen {
+		d.err = fmt.Errorf("invalid length %d, the file may be corrupt", n)
+		return nil
+	}
+	b := make([]byte, n)
+	_, d.err = io.ReadFull(d.r, b)
+	return b
+}
+
+func (d *decoder) str() string {
+	id := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if id == uint64(len(d.strings)) {
+		d.strings = append(d.strings, string(d.bytes()))
+	} else if id > uint64(len(d.strings)) {
+		d.err = fmt.Errorf("invalid string id %d, only %d strings defined", id, len(d.strings))
+		return ""
+	}
+	return d.strings[id]
+}
+
+func (d *decoder) time() time.Time {
+	sec := d.varint()
+	nsec := d.uvarint()
+	t := time.Unix(sec, int64(nsec))
+	if t.IsZero() {
+		// Keep unset times comparable with `time.Time{}`
+		return time.Time{}
+	}
+	return t
+}
+
+func (d *decoder) path(parent string) string {
+	isRelative := d.bool()
+	p := d.str()
+	if isRelative {
+		return getFullPath(parent, p)
+	}
+	return p
+}
+
+func (d *decoder) walkError() WalkError {
+	return WalkError{
+		Path:    d.str(),
+		Op:      d.str(),
+		Class:   d.str(),
+		Message: d.str(),
+	}
+}
+
+/*
+Reads the open record of a directory, see `TreeWriter.Open`
+*/
+func (d *decoder) openNode(parentPath string) *TreeNode {
+	d.strings = d.strings[:0]
+
+	n := &TreeNode{}
+	t := &n.FileTree
+	t.BasePath = d.path(parentPath)
+	t.Comprehensive = d.bool()
+	t.Depth = int(d.varint())
+	t.Dev = d.uvarint()
+	t.Uid = uint32(d.uvarint())
+	t.Gid = uint32(d.uvarint())
+	t.Mode = os.FileMode(d.uvarint())
+
+	t.LastModifiedDirect = d.time()
+	t.SizeDirect = d.varint()
+	t.AllocatedDirect = d.varint()
+	t.NumFilesDirect = d.varint()
+
+	d.files(n)
+	return n
+}
+
+/*
+Reads the close record of the directory `t`, see `TreeWriter.Close`
+*/
+func (d *decoder) closeNode(t *FileTree) {
+	d.strings = d.strings[:0]
+
+	numErrors := d.uvarint()
+	for i := uint64(0); i < numErrors && d.err == nil; i++ {
+		t.Errors = append(t.Errors, d.walkError())
+	}
+	t.LastVisited = d.time()
+	t.TimeTaken = time.Duration(d.varint())
+	t.LastModifiedBelow = d.time()
+	t.SizeBelow = d.varint()
+	t.AllocatedBelow = d.varint()
+	t.NumFilesBelow = d.varint()
+	t.AllHashOffset = d.varint()
+}
+
+/*
+Reads the files of `n`, and their hashes. The offsets of the hashes into `AllHash` are assigned as the
+tree is read
+*/
+func (d *decoder) files(n *TreeNode) {
+	t := &n.FileTree
+	numFiles := d.uvarint()
+	for i := uint64(0); i < numFiles && d.err == nil; i++ {
+		f := File{
+			Name: d.path(t.BasePath),
+			Hash: utility.InitialiseHashLocation(),
+		}
+		var hash []byte
+		if d.bool() {
+			f.Hash.Type = utility.HashType(d.uvarint())
+			hash = d.bytes()
+			f.Hash.HashLength = len(hash)
+		}
+		f.Size = d.varint()
+		f.Allocated = d.varint()
+		if d.bool() {
+			we := d.walkError()
+			f.WalkErr = &we
+		}
+		f.LastModified = d.time()
+		f.Uid = uint32(d.uvarint())
+		f.Gid = uint32(d.uvarint())
+		f.Mode = os.FileMode(d.uvarint())
+		f.LinkTarget = d.str()
+		f.LinkBroken = d.bool()
+		f.Dev = d.uvarint()
+		f.Ino = d.uvarint()
+		f.Nlink = d.uvarint()
+
+		t.Files = append(t.Files, f)
+		n.Hashes = append(n.Hashes, hash)
+	}
+}
+
+/*
+Reads a tree in the compact binary format one directory at a time, see `EncodeTree`
+*/
+type TreeReader struct {
+	d *decoder
+	c io.Closer
+
+	// Nil for trees written by older versions of seye
+	Header *utility.FileHeader
+
+	Comprehensive bool
+	// Only known once the whole tree has been read
+	AllHashLength int
+
+	// The directories opened but not yet closed
+	open []*TreeNode
+	done bool
+}
+
+func newTreeReader(r io.Reader, c io.Closer) (*TreeReader, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+	magic, err := d.r.Peek(len(treeFormatMagic))
+	if err != nil || string(magic) != treeFormatMagic {
+		return nil, errNotTreeFormat
+	}
+	d.r.Discard(len(treeFormatMagic))
+
+	if version := d.uvarint(); d.err == nil && version != treeFormatVersion {
+		return nil, fmt.Errorf("unsupported tree format version %d, this version of seye reads version %d", version, treeFormatVersion)
+	}
+	tr := &TreeReader{
+		d:             d,
+		c:             c,
+		Comprehensive: d.bool(),
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return tr, nil
+}
+
+/*
+Opens a `.tree` file in the compact binary format for reading with `Next`
+*/
+func OpenTreeReader(path string) (*TreeReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br, err := utility.NewDecompressedReader(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	h, _, err := readTreeHeader(br)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read '%s': %w", path, err)
+	}
+	tr, err := newTreeReader(br, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tr.Header = h
+	return tr, nil
+}
+
+/*
+Reads the header of a tree file from `r`, if it has one, checking this version of seye can read the
+rest of the file. Also returns the number of bytes read
+*/
+func readTreeHeader(r *bufio.Reader) (*utility.FileHeader, int64, error) {
+	h, n, err := utility.ReadFileHeader(r)
+	if err == nil && h != nil {
+		err = h.Check(utility.FileKindTree, treeFormatVersion)
+	}
+	return h, n, err
+}
+
+/*
+The header of the tree's `.tree` file, summarising the scan
+*/
+func (t *FileTree) NewHeader() utility.FileHeader {
+	var countDirs func(st *FileTree) int64
+	countDirs = func(st *FileTree) int64 {
+		n := int64(1)
+		for i := range st.SubTrees {
+			n += countDirs(&st.SubTrees[i])
+		}
+		return n
+	}
+
+	return utility.FileHeader{
+		Kind:          utility.FileKindTree,
+		FormatVersion: treeFormatVersion,
+		SeyeVersion:   utility.SeyeVersion,
+		HashAlgorithm: hashAlgorithm,
+		RootPath:      t.BasePath,
+		ScanTime:      t.LastVisited,
+		Comprehensive: t.Comprehensive,
+
+		HashChunkThreshold: t.HashChunkThreshold,
+		HashChunkSize:      t.HashChunkSize,
+
+		Size:      t.SizeBelow,
+		Allocated: t.AllocatedBelow,
+		NumFiles:  t.NumFilesBelow,
+		NumDirs:   countDirs(t),
+	}
+}
+
+/*
+Reads the next directory of the tree, in pre-order. Returns `io.EOF` after the last one. The fields
+of a directory that are read when it's closed are only set once its subtrees have been read
+*/
+func (tr *TreeReader) Next() (*TreeNode, error) {
+	for {
+		n, err := tr.nextRecord()
+		if err != nil || n != nil {
+			return n, err
+		}
+	}
+}
+
+/*
+Reads the next record of the tree, returning the directory it opens, or nil if it closes the last
+directory opened. Returns `io.EOF` after the end of the tree
+*/
+func (tr *TreeReader) nextRecord() (*TreeNode, error) {
+	if tr.done {
+		return nil, io.EOF
+	}
+
+	switch tag := tr.d.uvarint(); {
+	case tr.d.err != nil:
+		return nil, tr.d.err
+	case tag == tagOpen:
+		n := tr.d.openNode(tr.parentPath())
+		if tr.d.err != nil {
+			return nil, tr.d.err
+		}
+		tr.open = append(tr.open, n)
+		return n, nil
+	case tag == tagClose:
+		if len(tr.open) == 0 {
+			return nil, errors.New("a directory was closed without being opened, the file may be corrupt")
+		}
+		tr.d.closeNode(&tr.open[len(tr.open)-1].FileTree)
+		tr.open = tr.open[:len(tr.open)-1]
+		return nil, tr.d.err
+	case tag == tagEnd:
+		tr.AllHashLength = int(tr.d.uvarint())
+		if tr.d.err != nil {
+			return nil, tr.d.err
+		} else if len(tr.open) > 0 {
+			return nil, fmt.Errorf("the tree ended before '%s' was closed, the file may be corrupt", tr.parentPath())
+		}
+		tr.done = true
+		return nil, io.EOF
+	default:
+		return nil, fmt.Errorf("invalid record %d, the file may be corrupt", tag)
+	}
+}
+
+/*
+The path of the last directory opened, the parent of the next one read
+*/
+func (tr *TreeReader) parentPath() string {
+	if len(tr.open) == 0 {
+		return ""
+	}
+	return tr.open[len(tr.open)-1].BasePath
+}
+
+func (tr *TreeReader) Close() error {
+	if tr.c == nil {
+		return nil
+	}
+	return tr.c.Close()
+}
+
+/*
+Reads the whole tree, putting each file's hash back in the root's `AllHash`. The hashes of a
+"comprehensive" tree are laid out like a walk lays them out, see `relayoutAllHash`
+*/
+func (tr *TreeReader) ReadAll() (FileTree, error) {
+	allHash := []byte{}
+	n, err := tr.Next()
+	if err != nil {
+		return FileTree{}, err
+	}
+
+	t, err := tr.buildTree(n, -1, func(n *TreeNode) {
+		for i, h := range n.Hashes {
+			if h != nil {
+				n.Files[i].Hash.HashOffset = len(allHash)
+				allHash = append(allHash, h...)
+			}
+		}
+	})
+	if err != nil {
+		return t, err
+	}
+	// The end of the tree, with the length of its `AllHash`
+	if _, err = tr.nextRecord(); err == nil {
+		return t, errors.New("expected the end of the tree after its root, the file may be corrupt")
+	} else if err != io.EOF {
+		return t, err
+	}
+	if tr.AllHashLength > 0 {
+		t.AllHash = allHash
+		relayoutAllHash(&t)
+	}
+	t.HashChunkThreshold, t.HashChunkSize = chunkedHashingOf(tr.Header)
+	return t, nil
+}
+
+/*
+Builds the tree below `n` from the records read until it's closed, down to `maxDepth` levels below
+it (or all of them if `maxDepth` is negative), deeper directories are read but discarded. `addHashes`
+is called with each directory that's kept, to store its files' hashes
+*/
+func (tr *TreeReader) buildTree(n *TreeNode, maxDepth int, addHashes func(n *TreeNode)) (FileTree, error) {
+	addHashes(n)
+	for {
+		sn, err := tr.nextRecord()
+		if err == io.EOF {
+			return FileTree{}, fmt.Errorf("the tree ended before '%s' was closed, the file may be corrupt", n.BasePath)
+		} else if err != nil {
+			return FileTree{}, err
+		} else if sn == nil {
+			return n.FileTree, nil
+		}
+
+		if maxDepth == 0 {
+			if err = tr.skipSubTrees(sn); err != nil {
+				return FileTree{}, err
+			}
+			continue
+		}
+		st, err := tr.buildTree(sn, maxDepth-1, addHashes)
+		if err != nil {
+			return FileTree{}, err
+		}
+		n.SubTrees = append(n.SubTrees, st)
+	}
+}
+
+/*
+Reads past the subtrees of `n`, without keeping them, until it's closed
+*/
+func (tr *TreeReader) skipSubTrees(n *TreeNode) error {
+	for depth := 0; ; {
+		sn, err := tr.nextRecord()
+		if err == io.EOF {
+			return fmt.Errorf("the tree ended before '%s' was closed, the file may be corrupt", n.BasePath)
+		} else if err != nil {
+			return err
+		} else if sn != nil {
+			depth++
+		} else if depth == 0 {
+			return nil
+		} else {
+			depth--
+		}
+	}
+}
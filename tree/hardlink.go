@@ -0,0 +1,58 @@
+package tree
+
+var (
+	// When false, each inode's size is only counted once in `SizeDirect` and `SizeBelow`
+	countLinks = false
+)
+
+func SetCountLinks(newVal bool) {
+	countLinks = newVal
+}
+
+func GetCountLinks() bool {
+	return countLinks
+}
+
+/*
+Only has an effect when not counting links. Subtracts the size (and allocated
+size) of each hardlink, after the first, to the same inode from the trees above
+it.
+
+Trees are visited in the order of `SubTrees` (sorted by path), so the same link
+is counted regardless of which walk algorithm produced the tree
+*/
+func countHardlinksOnce(t *FileTree) {
+	if countLinks {
+		return
+	}
+	countHardlinksOnceBelow(t, map[DevIno]struct{}{})
+}
+
+func countHardlinksOnceBelow(t *FileTree, seen map[DevIno]struct{}) (removedBelow, removedAllocatedBelow int64) {
+	var removedDirect, removedAllocatedDirect int64
+	for _, f := range t.Files {
+		if f.Nlink < 2 {
+			continue
+		}
+
+		id := DevIno{Dev: f.Dev, Ino: f.Ino}
+		if _, ok := seen[id]; ok {
+			removedDirect += f.Size
+			removedAllocatedDirect += f.Allocated
+		} else {
+			seen[id] = struct{}{}
+		}
+	}
+	removedBelow, removedAllocatedBelow = removedDirect, removedAllocatedDirect
+	for i := range t.SubTrees {
+		subRemoved, subRemovedAllocated := countHardlinksOnceBelow(&t.SubTrees[i], seen)
+		removedBelow += subRemoved
+		removedAllocatedBelow += subRemovedAllocated
+	}
+
+	t.SizeDirect -= removedDirect
+	t.SizeBelow -= removedBelow
+	t.AllocatedDirect -= removedAllocatedDirect
+	t.AllocatedBelow -= removedAllocatedBelow
+	return removedBelow, removedAllocatedBelow
+}
@@ -0,0 +1,326 @@
+package tree
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pericles-tpt/seye/utility"
+)
+
+/*
+The index of a `.tree` file, written next to it by `WriteBinary` (see `IndexPath`):
+
+	header:  "SEYI" | version | tree format version | Comprehensive | len(AllHash)
+	entries: count | (path | block offset | offset), for each directory of the tree in pre-order
+
+The block offset of a directory is the offset, in the tree file, of the block it starts in (see
+`dirsPerBlock`), and its offset is from the start of that block once it's decompressed. So reading a
+directory only decompresses the blocks it's in
+*/
+const (
+	indexMagic   = "SEYI"
+	indexVersion = 3
+)
+
+var (
+	ErrNotInTree = errors.New("directory isn't in the tree")
+)
+
+type indexEntry struct {
+	path   string
+	block  int64
+	offset int64
+}
+
+/*
+A `.tree` file opened to read parts of the tree from, see `Subtree`
+*/
+type IndexedTree struct {
+	path string
+
+	Comprehensive bool
+	AllHashLength int
+
+	treeVersion uint64
+	// The offset of each directory in the tree, nil if the tree has no index
+	offsets map[string]indexEntry
+}
+
+/*
+The path of the index of the `.tree` file at `treePath`
+*/
+func IndexPath(treePath string) string {
+	return treePath + ".idx"
+}
+
+/*
+Writes the index of a tree, `allHashLength` is the length of its `AllHash` when it's read back
+*/
+func writeIndex(path string, comprehensive bool, allHashLength int, entries []indexEntry) error {
+	return utility.WriteFileAtomic(path, 0600, func(w io.Writer) error {
+		cw, err := utility.NewCompressedWriter(w)
+		if err != nil {
+			return err
+		}
+		e := &encoder{w: bufio.NewWriter(cw)}
+		_, e.err = e.w.WriteString(indexMagic)
+		e.uvarint(indexVersion)
+		e.uvarint(treeFormatVersion)
+		e.bool(comprehensive)
+		e.uvarint(uint64(allHashLength))
+		e.uvarint(uint64(len(entries)))
+		for _, ie := range entries {
+			e.bytes([]byte(ie.path))
+			e.uvarint(uint64(ie.block))
+			e.uvarint(uint64(ie.offset))
+		}
+		if e.err != nil {
+			return e.err
+		}
+		if err = e.w.Flush(); err != nil {
+			return err
+		}
+		return cw.Close()
+	})
+}
+
+func readIndex(path string) (*IndexedTree, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br, err := utility.NewDecompressedReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{r: br}
+	magic, err := d.r.Peek(len(indexMagic))
+	if err != nil || string(magic) != indexMagic {
+		return nil, fmt.Errorf("'%s' isn't a tree index", path)
+	}
+	d.r.Discard(len(indexMagic))
+
+	if version := d.uvarint(); d.err == nil && version != indexVersion {
+		return nil, fmt.Errorf("unsupported tree index version %d, this version of seye reads version %d", version, indexVersion)
+	}
+	it := &IndexedTree{
+		treeVersion:   d.uvarint(),
+		Comprehensive: d.bool(),
+		AllHashLength: int(d.uvarint()),
+		offsets:       map[string]indexEntry{},
+	}
+	numEntries := d.uvarint()
+	for i := uint64(0); i < numEntries && d.err == nil; i++ {
+		ie := indexEntry{path: string(d.bytes())}
+		ie.block = int64(d.uvarint())
+		ie.offset = int64(d.uvarint())
+		it.offsets[ie.path] = ie
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return it, nil
+}
+
+/*
+Opens the `.tree` file at `path` to read subtrees from, using its index if it has one. Trees without
+an index (e.g. if it was removed) can still be read, but every node before the subtree has to be
+decoded
+*/
+func OpenIndexed(path string) (*IndexedTree, error) {
+	it, err := readIndex(IndexPath(path))
+	if err == nil {
+		it.path = path
+		return it, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	tr, err := OpenTreeReader(path)
+	if err == errNotTreeFormat {
+		return nil, fmt.Errorf("'%s' was written by an older version of seye, run `seye migrate` first", path)
+	} else if err != nil {
+		return nil, err
+	}
+	defer tr.Close()
+
+	return &IndexedTree{
+		path:          path,
+		Comprehensive: tr.Comprehensive,
+		AllHashLength: tr.AllHashLength,
+		treeVersion:   treeFormatVersion,
+	}, nil
+}
+
+/*
+Reads the directory `p`, and everything below it, from the tree. Only the nodes of the subtree are
+decoded, it's returned as a tree of its own (i.e. at depth 0, with its own `AllHash`)
+*/
+func (it *IndexedTree) Subtree(p string) (FileTree, error) {
+	return it.SubtreeToDepth(p, -1)
+}
+
+/*
+Reads the directory `p` like `Subtree`, but only `maxDepth` levels below it (e.g. 1 for just its
+direct subdirectories)
+*/
+func (it *IndexedTree) SubtreeToDepth(p string, maxDepth int) (FileTree, error) {
+	p = trimRootPath(p)
+
+	var (
+		tr  *TreeReader
+		n   *TreeNode
+		err error
+	)
+	if it.offsets != nil {
+		tr, n, err = it.seekNode(p)
+	} else {
+		tr, n, err = it.scanNode(p)
+	}
+	if err != nil {
+		return FileTree{}, err
+	}
+	defer tr.Close()
+
+	var (
+		allHash   = []byte{}
+		baseDepth = n.Depth
+	)
+	t, err := tr.buildTree(n, maxDepth, func(n *TreeNode) {
+		n.Depth -= baseDepth
+		for i, h := range n.Hashes {
+			if h != nil {
+				n.Files[i].Hash.HashOffset = len(allHash)
+				allHash = append(allHash, h...)
+			}
+		}
+	})
+	if err != nil {
+		return t, err
+	}
+	if it.Comprehensive {
+		t.AllHash = allHash
+	}
+	return t, nil
+}
+
+/*
+Opens the tree at the block `p` starts in, from its index, and reads its directory. Only that block,
+and those after it that `p` continues into, are decompressed
+*/
+func (it *IndexedTree) seekNode(p string) (*TreeReader, *TreeNode, error) {
+	ie, ok := it.offsets[p]
+	if !ok {
+		return nil, nil, fmt.Errorf("'%s': %w", p, ErrNotInTree)
+	}
+
+	f, err := os.Open(it.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var r *bufio.Reader
+	if _, err = f.Seek(ie.block, io.SeekStart); err == nil {
+		r, err = utility.NewDecompressedReader(bufio.NewReader(f))
+	}
+	if err == nil {
+		_, err = io.CopyN(io.Discard, r, ie.offset)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	// Start as if the parent directory of `p` has just been opened, since `p` is stored relative to it
+	tr := &TreeReader{
+		d:             &decoder{r: r},
+		c:             f,
+		Comprehensive: it.Comprehensive,
+		AllHashLength: it.AllHashLength,
+		open:          []*TreeNode{{FileTree: FileTree{BasePath: filepath.Dir(p)}}},
+	}
+	n, err := tr.Next()
+	if err != nil {
+		tr.Close()
+		return nil, nil, err
+	} else if n.BasePath != p {
+		tr.Close()
+		return nil, nil, fmt.Errorf("the index of '%s' is out of date, expected '%s' at offset %d of block %d, found '%s'", it.path, p, ie.offset, ie.block, n.BasePath)
+	}
+
+	return tr, n, nil
+}
+
+/*
+Reads the tree from the start until the node of `p`, skipping the subtrees that can't contain it
+*/
+func (it *IndexedTree) scanNode(p string) (*TreeReader, *TreeNode, error) {
+	tr, err := OpenTreeReader(it.path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		n, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			tr.Close()
+			return nil, nil, err
+		}
+
+		if n.BasePath == p {
+			return tr, n, nil
+		} else if !strings.HasPrefix(p, getFullPath(n.BasePath, "")) {
+			if err = tr.skipSubTrees(n); err != nil {
+				tr.Close()
+				return nil, nil, err
+			}
+		}
+	}
+	tr.Close()
+
+	return nil, nil, fmt.Errorf("'%s': %w", p, ErrNotInTree)
+}
+
+/*
+Checks the index of the `.tree` file at `path` is up to date with `t`, the tree read from it, i.e. it
+has every directory of `t`, and each is found at its offset. Returns an error satisfying
+`os.IsNotExist` if the tree has no index
+*/
+func CheckIndex(path string, t *FileTree) error {
+	it, err := readIndex(IndexPath(path))
+	if err != nil {
+		return err
+	} else if it.treeVersion != treeFormatVersion || it.Comprehensive != t.Comprehensive {
+		return errors.New("the index doesn't match the tree's header")
+	}
+	it.path = path
+
+	entries, allHashLength, err := encodeTree(&countingWriter{w: io.Discard}, t)
+	if err != nil {
+		return err
+	} else if allHashLength != it.AllHashLength {
+		return errors.New("the index doesn't match the tree's hashes")
+	} else if len(entries) != len(it.offsets) {
+		return fmt.Errorf("the index has %d directories, the tree has %d", len(it.offsets), len(entries))
+	}
+	for _, ie := range entries {
+		if _, ok := it.offsets[ie.path]; !ok {
+			return fmt.Errorf("'%s' isn't in the index", ie.path)
+		}
+		tr, _, err := it.seekNode(ie.path)
+		if err != nil {
+			return err
+		}
+		tr.Close()
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package tree
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joomcode/errorx"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+/*
+A mounted filesystem, as listed in `/proc/self/mountinfo`
+*/
+type Mount struct {
+	MountPoint string
+	FsType     string
+	Source     string
+	Dev        uint64
+}
+
+/*
+The usage below a mount point in a `FileTree`, see `GetMountUsage`
+*/
+type MountUsage struct {
+	MountPoint string
+	FsType     string
+	Dev        uint64
+	Size       int64
+	NumFiles   int64
+}
+
+var (
+	oneFileSystem         = false
+	skipPseudoFilesystems = true
+	pseudoFsTypes         = []string{"proc", "sysfs", "cgroup", "cgroup2", "devtmpfs", "devpts", "securityfs", "debugfs", "tracefs", "pstore", "bpf", "configfs", "fusectl", "mqueue"}
+
+	// Populated at the start of each walk, by `resetWalkedDirs`
+	mountsByDev = map[uint64]Mount{}
+)
+
+func SetOneFileSystem(newVal bool) {
+	oneFileSystem = newVal
+}
+
+func GetOneFileSystem() bool {
+	return oneFileSystem
+}
+
+func SetSkipPseudoFilesystems(newVal bool) {
+	skipPseudoFilesystems = newVal
+}
+
+/*
+Parses the mounts in the format of `/proc/self/mountinfo`, e.g.
+
+	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+*/
+func parseMountInfo(r io.Reader) ([]Mount, error) {
+	mounts := []Mount{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		// Optional fields are terminated by a "-"
+		sepIdx := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sepIdx = i
+				break
+			}
+		}
+		if len(fields) < 5 || sepIdx < 0 || sepIdx+2 >= len(fields) {
+			continue
+		}
+
+		majorMinor := strings.Split(fields[2], ":")
+		if len(majorMinor) != 2 {
+			continue
+		}
+		major, err := strconv.ParseUint(majorMinor[0], 10, 32)
+		if err != nil {
+			return nil, errorx.Decorate(err, "failed to parse major device number in mountinfo")
+		}
+		minor, err := strconv.ParseUint(majorMinor[1], 10, 32)
+		if err != nil {
+			return nil, errorx.Decorate(err, "failed to parse minor device number in mountinfo")
+		}
+
+		mounts = append(mounts, Mount{
+			MountPoint: unescapeMountPath(fields[4]),
+			FsType:     fields[sepIdx+1],
+			Source:     unescapeMountPath(fields[sepIdx+2]),
+			Dev:        mkdev(major, minor),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errorx.Decorate(err, "failed to read mountinfo")
+	}
+	return mounts, nil
+}
+
+/*
+Paths in mountinfo have spaces, tabs, newlines and backslashes escaped as octal, e.g. "\040"
+*/
+func unescapeMountPath(p string) string {
+	if !strings.Contains(p, "\\") {
+		return p
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+3 < len(p) {
+			if c, err := strconv.ParseUint(p[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(p[i])
+	}
+	return sb.String()
+}
+
+/*
+Loads the system's mounts into `mountsByDev`, if they can't be read then no
+directories are skipped for being on a pseudo-filesystem
+*/
+func loadMounts() {
+	mountsByDev = map[uint64]Mount{}
+	mounts, err := GetMounts()
+	if err != nil {
+		return
+	}
+	for _, m := range mounts {
+		if _, ok := mountsByDev[m.Dev]; !ok {
+			mountsByDev[m.Dev] = m
+		}
+	}
+}
+
+/*
+Decides if a directory on `dev` should be walked, for a walk with its root on `rootDev`
+*/
+func onWalkableFilesystem(rootDev, dev uint64) bool {
+	if oneFileSystem && dev != rootDev {
+		return false
+	}
+	if skipPseudoFilesystems {
+		if m, ok := mountsByDev[dev]; ok && utility.Contains(pseudoFsTypes, m.FsType) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Breaks down the usage in a tree by mount point, a new mount point starts wherever
+a `FileTree`'s `Dev` differs from its parent's. Sorted by size, largest first
+*/
+func (t *FileTree) GetMountUsage() []MountUsage {
+	var (
+		ordered  = []*MountUsage{}
+		addUsage func(st *FileTree, parent *MountUsage)
+	)
+	addUsage = func(st *FileTree, parent *MountUsage) {
+		curr := parent
+		if parent == nil || st.Dev != parent.Dev {
+			curr = &MountUsage{MountPoint: st.BasePath, FsType: "unknown", Dev: st.Dev}
+			if m, ok := mountsByDev[st.Dev]; ok {
+				curr.FsType = m.FsType
+			}
+			ordered = append(ordered, curr)
+		}
+		curr.Size += st.ReportedSizeDirect()
+		curr.NumFiles += st.NumFilesDirect
+		for i := range st.SubTrees {
+			addUsage(&st.SubTrees[i], curr)
+		}
+	}
+	if len(mountsByDev) == 0 {
+		loadMounts()
+	}
+	addUsage(t, nil)
+
+	ret := make([]MountUsage, len(ordered))
+	for i, u := range ordered {
+		ret[i] = *u
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Size > ret[j].Size
+	})
+	return ret
+}
@@ -0,0 +1,28 @@
+package tree
+
+import (
+	"os"
+
+	"github.com/joomcode/errorx"
+)
+
+/*
+Gets the mounted filesystems visible to this process
+*/
+func GetMounts() ([]Mount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, errorx.Decorate(err, "failed to open mountinfo")
+	}
+	defer f.Close()
+
+	return parseMountInfo(f)
+}
+
+/*
+Encodes a device number the same way as glibc's `makedev`
+*/
+func mkdev(major, minor uint64) uint64 {
+	return ((major & 0xfffff000) << 32) | ((major & 0x00000fff) << 8) |
+		((minor & 0xffffff00) << 12) | (minor & 0x000000ff)
+}
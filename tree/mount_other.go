@@ -0,0 +1,14 @@
+//go:build !linux
+
+package tree
+
+/*
+Mounts are only listed on linux, elsewhere only `--one-file-system` can be used
+*/
+func GetMounts() ([]Mount, error) {
+	return []Mount{}, nil
+}
+
+func mkdev(major, minor uint64) uint64 {
+	return (major << 24) | minor
+}
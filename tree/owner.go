@@ -0,0 +1,82 @@
+package tree
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/pericles-tpt/seye/utility"
+)
+
+/*
+The usage in a `FileTree` by a single user or group, see `GetUsageByOwner`
+*/
+type OwnerUsage struct {
+	Id       uint32
+	Name     string
+	Size     int64
+	NumFiles int64
+}
+
+/*
+Aggregates the size and number of files in the tree by their owning user (or group
+if `byGroup`). Names are resolved from `/etc/passwd` (or `/etc/group`), an id without
+a name is named by its number. Sorted by size, largest first
+*/
+func (t *FileTree) GetUsageByOwner(byGroup bool) []OwnerUsage {
+	getNames := utility.GetUserNames
+	if byGroup {
+		getNames = utility.GetGroupNames
+	}
+	names, err := getNames()
+	if err != nil {
+		names = map[uint32]string{}
+	}
+
+	var (
+		usage    = map[uint32]*OwnerUsage{}
+		seen     = map[DevIno]struct{}{}
+		addUsage func(st *FileTree)
+	)
+	addUsage = func(st *FileTree) {
+		for _, f := range st.Files {
+			id := f.Uid
+			if byGroup {
+				id = f.Gid
+			}
+			u, ok := usage[id]
+			if !ok {
+				u = &OwnerUsage{Id: id, Name: names[id]}
+				if u.Name == "" {
+					u.Name = strconv.FormatUint(uint64(id), 10)
+				}
+				usage[id] = u
+			}
+			u.NumFiles++
+
+			// Hardlinks have the same owner, so only count each inode's size once (see `countHardlinksOnce`)
+			if !countLinks && f.Nlink > 1 {
+				if _, ok := seen[DevIno{Dev: f.Dev, Ino: f.Ino}]; ok {
+					continue
+				}
+				seen[DevIno{Dev: f.Dev, Ino: f.Ino}] = struct{}{}
+			}
+			u.Size += f.ReportedSize()
+		}
+		for i := range st.SubTrees {
+			addUsage(&st.SubTrees[i])
+		}
+	}
+	addUsage(t)
+
+	ret := make([]OwnerUsage, 0, len(usage))
+	for _, u := range usage {
+		ret = append(ret, *u)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Size == ret[j].Size {
+			return ret[i].Id < ret[j].Id
+		}
+		return ret[i].Size > ret[j].Size
+	})
+	return ret
+}
@@ -0,0 +1,49 @@
+package tree
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/joomcode/errorx"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+	idleNiceness     = 19
+)
+
+/*
+Sets the I/O priority class of the process to idle (see `ioprio_set(2)`) and lowers its CPU
+priority, so the walk only uses the disk and CPU when nothing else does.
+
+On Linux both are per-thread, so they're set for each of the process' existing threads, any
+threads created afterwards inherit them
+*/
+func SetIdlePriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return errorx.Decorate(err, "failed to list the process' threads")
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+
+		// A thread may have exited since it was listed
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift)
+		if errno != 0 && errno != syscall.ESRCH {
+			return errorx.Decorate(errno, "failed to set the I/O priority to idle")
+		}
+		err = syscall.Setpriority(syscall.PRIO_PROCESS, tid, idleNiceness)
+		if err != nil && err != syscall.ESRCH {
+			return errorx.Decorate(err, "failed to lower the CPU priority")
+		}
+	}
+	idlePriority = true
+
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package tree
+
+import "fmt"
+
+/*
+I/O priority classes are specific to Linux
+*/
+func SetIdlePriority() error {
+	return fmt.Errorf("idle priority isn't supported on this platform")
+}
@@ -0,0 +1,46 @@
+package tree
+
+import (
+	"github.com/joomcode/errorx"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+/*
+Moves every path in the tree from under `oldRoot` to under `newRoot`, see `utility.RebasePath`
+*/
+func (t *FileTree) Rebase(oldRoot, newRoot string) {
+	t.BasePath = utility.RebasePath(t.BasePath, oldRoot, newRoot)
+	RebaseWalkErrors(t.Errors, oldRoot, newRoot)
+	for i := range t.Files {
+		f := &t.Files[i]
+		f.Name = utility.RebasePath(f.Name, oldRoot, newRoot)
+		if f.WalkErr != nil {
+			f.WalkErr.Path = utility.RebasePath(f.WalkErr.Path, oldRoot, newRoot)
+		}
+	}
+	for i := range t.SubTrees {
+		t.SubTrees[i].Rebase(oldRoot, newRoot)
+	}
+}
+
+/*
+Moves the `Path` of each of `errs` like `FileTree.Rebase`
+*/
+func RebaseWalkErrors(errs []WalkError, oldRoot, newRoot string) {
+	for i := range errs {
+		errs[i].Path = utility.RebasePath(errs[i].Path, oldRoot, newRoot)
+	}
+}
+
+/*
+Rewrites the `.tree` file at `path`, of a scan of `oldRoot`, to `newPath` as a scan of `newRoot` (see
+`Rebase`). The file at `path` is left as it was
+*/
+func RelocateTree(path, newPath, oldRoot, newRoot string) error {
+	t, err := ReadBinary(path)
+	if err != nil {
+		return errorx.Decorate(err, "failed to read FileTree to relocate")
+	}
+	t.Rebase(oldRoot, newRoot)
+	return t.WriteBinary(newPath)
+}
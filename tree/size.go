@@ -0,0 +1,74 @@
+package tree
+
+import "sort"
+
+type SizeMode int
+
+const (
+	// Sizes are reported as the apparent size from `stat`, i.e. `File.Size`
+	SizeApparent SizeMode = iota
+	// Sizes are reported as the allocated size on disk, i.e. `File.Allocated`
+	SizeDiskUsage
+)
+
+var (
+	sizeMode = SizeApparent
+)
+
+func SetSizeMode(newVal SizeMode) {
+	sizeMode = newVal
+}
+
+func GetSizeMode() SizeMode {
+	return sizeMode
+}
+
+func reportedSize(size, allocated int64) int64 {
+	if sizeMode == SizeDiskUsage {
+		return allocated
+	}
+	return size
+}
+
+/*
+The size of the file for reports, depending on the `sizeMode`
+*/
+func (f *File) ReportedSize() int64 {
+	return reportedSize(f.Size, f.Allocated)
+}
+
+func (t *FileTree) ReportedSizeDirect() int64 {
+	return reportedSize(t.SizeDirect, t.AllocatedDirect)
+}
+
+func (t *FileTree) ReportedSizeBelow() int64 {
+	return reportedSize(t.SizeBelow, t.AllocatedBelow)
+}
+
+/*
+Gets the `limit` files in the tree with the most unallocated space, i.e. the
+largest difference between their apparent and allocated sizes
+*/
+func (t *FileTree) GetMostSparseFiles(limit int) []File {
+	sparseFiles := []File{}
+	var addSparse func(st *FileTree)
+	addSparse = func(st *FileTree) {
+		for _, f := range st.Files {
+			if f.Size > f.Allocated {
+				sparseFiles = append(sparseFiles, f)
+			}
+		}
+		for i := range st.SubTrees {
+			addSparse(&st.SubTrees[i])
+		}
+	}
+	addSparse(t)
+
+	sort.SliceStable(sparseFiles, func(i, j int) bool {
+		return sparseFiles[i].Size-sparseFiles[i].Allocated > sparseFiles[j].Size-sparseFiles[j].Allocated
+	})
+	if len(sparseFiles) > limit {
+		sparseFiles = sparseFiles[:limit]
+	}
+	return sparseFiles
+}
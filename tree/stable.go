@@ -0,0 +1,79 @@
+package tree
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/pericles-tpt/seye/utility"
+)
+
+/*
+Times a file that changed while being hashed is hashed again, before it's marked as unstable
+*/
+var maxHashRetries = 2
+
+func SetMaxHashRetries(newVal int) {
+	maxHashRetries = newVal
+}
+
+func GetMaxHashRetries() int {
+	return maxHashRetries
+}
+
+var errFileUnstable = errors.New("file changed while being hashed")
+
+/*
+Hashes the open file `f`, then re-stats it to check it didn't change while being read (i.e. its size, mtime or
+ctime differ from `before`). If it did, it's hashed again up to `maxHashRetries` times, before giving up with
+`errFileUnstable`.
+
+Returns the hash, its type, the `stat` it's consistent with and the number of bytes read
+*/
+func hashStable(f *os.File, before os.FileInfo, buf []byte) ([]byte, utility.HashType, os.FileInfo, int64, error) {
+	var totalRead int64
+	for i := 0; ; i++ {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, utility.SHA256, before, totalRead, err
+		}
+
+		hash, hashType, n, err := hashContents(f, before.Size(), buf)
+		totalRead += n
+		if err != nil {
+			return nil, hashType, before, totalRead, err
+		}
+
+		after, err := f.Stat()
+		if err != nil {
+			return nil, hashType, before, totalRead, err
+		}
+		if statUnchanged(before, after) {
+			return hash, hashType, after, totalRead, nil
+		}
+		if i >= maxHashRetries {
+			return nil, hashType, after, totalRead, errFileUnstable
+		}
+		before = after
+	}
+}
+
+func statUnchanged(a, b os.FileInfo) bool {
+	aCtime, aOk := getCtime(a)
+	bCtime, bOk := getCtime(b)
+	return a.Size() == b.Size() &&
+		a.ModTime().Equal(b.ModTime()) &&
+		(!aOk || !bOk || aCtime.Equal(bCtime))
+}
+
+/*
+Counts the files in the tree that kept changing while being hashed
+*/
+func (t *FileTree) CountUnstableFiles() int {
+	count := 0
+	for _, e := range t.GetErrors() {
+		if e.Class == ClassUnstable {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,26 @@
+//go:build !unix
+
+package tree
+
+import (
+	"os"
+)
+
+/*
+Device and inode numbers aren't available on this platform
+*/
+func GetDevIno(info os.FileInfo) (DevIno, bool) {
+	return DevIno{}, false
+}
+
+func getAllocated(info os.FileInfo) (int64, bool) {
+	return 0, false
+}
+
+func getOwner(info os.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
+
+func getNlink(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,52 @@
+//go:build unix
+
+package tree
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+Gets the device and inode numbers from a `stat`
+*/
+func GetDevIno(info os.FileInfo) (DevIno, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return DevIno{}, false
+	}
+	return DevIno{Dev: uint64(st.Dev), Ino: uint64(st.Ino)}, true
+}
+
+/*
+Gets the disk usage of a file from a `stat`, `Blocks` is always in 512 byte units
+*/
+func getAllocated(info os.FileInfo) (int64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int64(st.Blocks) * 512, true
+}
+
+/*
+Gets the owning user and group of a file from a `stat`
+*/
+func getOwner(info os.FileInfo) (uint32, uint32, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.Uid, st.Gid, true
+}
+
+/*
+Gets the number of hardlinks to a file from a `stat`
+*/
+func getNlink(info os.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Nlink), true
+}
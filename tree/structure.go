@@ -2,6 +2,7 @@ package tree
 
 import (
 	"errors"
+	"os"
 	"time"
 
 	"github.com/pericles-tpt/seye/utility"
@@ -15,23 +16,33 @@ type FileTree struct {
 	Comprehensive bool
 	BasePath      string
 	Files         []File
-	ErrStrings    []string
+	Errors        []WalkError
 	LastVisited   time.Time
 	TimeTaken     time.Duration
 	Depth         int
+	Dev           uint64
+	Uid           uint32
+	Gid           uint32
+	Mode          os.FileMode
 
 	LastModifiedDirect time.Time
 	SizeDirect         int64
+	AllocatedDirect    int64
 	NumFilesDirect     int64
 
 	// Recursive data
 	LastModifiedBelow time.Time
 	SizeBelow         int64
+	AllocatedBelow    int64
 	NumFilesBelow     int64
 	SubTrees          []FileTree
 
 	AllHash       []byte // Only populated at depth == 0
 	AllHashOffset int64
+
+	// The chunked hashing the files were hashed with, see `SetChunkedHashing`. Only populated at depth == 0
+	HashChunkThreshold int64
+	HashChunkSize      int64
 }
 
 /*
@@ -41,17 +52,42 @@ type File struct {
 	Name         string
 	Hash         utility.HashLocation
 	Size         int64
-	Err          string
+	Allocated    int64 // Disk usage, can be less than `Size` for sparse or compressed files
+	WalkErr      *WalkError
+	Err          string // Only set in older `gob` scans, moved to `WalkErr` when they're read
 	LastModified time.Time
+	Uid          uint32
+	Gid          uint32
+	Mode         os.FileMode
+
+	// Only populated for symlinks
+	LinkTarget string
+	LinkBroken bool
+
+	// Identifies the file's inode, used to account for hardlinks
+	Dev   uint64
+	Ino   uint64
+	Nlink uint64
+}
+
+/*
+Uniquely identifies a file or directory on a system
+*/
+type DevIno struct {
+	Dev uint64
+	Ino uint64
 }
 
 /*
 Not a complete equality check, doesn't check hashed bytes of both files
 */
 func (a *File) Equal(b File) bool {
-	return a.Err == b.Err &&
+	return WalkErrorsEqual(a.WalkErr, b.WalkErr) &&
 		time.Time.Equal(a.LastModified, b.LastModified) &&
-		a.Name == b.Name && a.Size == b.Size
+		a.Name == b.Name && a.Size == b.Size && a.Allocated == b.Allocated &&
+		a.LinkTarget == b.LinkTarget && a.LinkBroken == b.LinkBroken &&
+		a.Dev == b.Dev && a.Ino == b.Ino && a.Nlink == b.Nlink &&
+		a.Uid == b.Uid && a.Gid == b.Gid && a.Mode == b.Mode
 }
 
 func (a *FileTree) Equal(b FileTree) error {
@@ -64,11 +100,11 @@ func (a *FileTree) Equal(b FileTree) error {
 	}
 
 	allErrsEqual := true
-	if len(a.ErrStrings) != len(b.ErrStrings) {
+	if len(a.Errors) != len(b.Errors) {
 		return errors.New("number of errors in each tree aren't equal")
 	}
-	for i, e := range a.ErrStrings {
-		allErrsEqual = allErrsEqual && (e == b.ErrStrings[i])
+	for i, e := range a.Errors {
+		allErrsEqual = allErrsEqual && (e == b.Errors[i])
 	}
 
 	allSubTreesEqual := true
@@ -100,13 +136,25 @@ func (a *FileTree) Equal(b FileTree) error {
 	}
 
 	if !allErrsEqual {
-		return errors.New("trees don't have the same `ErrStrings`")
+		return errors.New("trees don't have the same `Errors`")
 	}
 
 	if a.Depth != b.Depth {
 		return errors.New("trees don't have the same `Depth`")
 	}
 
+	if a.Dev != b.Dev {
+		return errors.New("trees don't have the same `Dev`")
+	}
+
+	if a.Uid != b.Uid || a.Gid != b.Gid {
+		return errors.New("trees don't have the same owner")
+	}
+
+	if a.Mode != b.Mode {
+		return errors.New("trees don't have the same `Mode`")
+	}
+
 	if a.LastModifiedDirect != b.LastModifiedDirect {
 		return errors.New("trees don't have the same `LastModifiedDirect`")
 	}
@@ -115,6 +163,10 @@ func (a *FileTree) Equal(b FileTree) error {
 		return errors.New("trees don't have the same `SizeDirect`")
 	}
 
+	if a.AllocatedDirect != b.AllocatedDirect {
+		return errors.New("trees don't have the same `AllocatedDirect`")
+	}
+
 	if a.NumFilesDirect != b.NumFilesDirect {
 		return errors.New("trees don't have the same `NumFilesDirect`")
 	}
@@ -127,6 +179,10 @@ func (a *FileTree) Equal(b FileTree) error {
 		return errors.New("trees don't have the same `SizeBelow`")
 	}
 
+	if a.AllocatedBelow != b.AllocatedBelow {
+		return errors.New("trees don't have the same `AllocatedBelow`")
+	}
+
 	if a.NumFilesBelow != b.NumFilesBelow {
 		return errors.New("trees don't have the same `NumFilesBelow`")
 	}
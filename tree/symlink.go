@@ -0,0 +1,214 @@
+package tree
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+type SymlinkPolicy int
+
+const (
+	// Symlinks aren't added to the tree
+	SymlinksSkip SymlinkPolicy = iota
+	// Symlinks are added to the tree as a `File` (with a `LinkTarget`), but never followed
+	SymlinksRecord
+	// Symlinks are followed, linked directories are walked and linked files are treated
+	// as regular files (with a `LinkTarget`)
+	SymlinksFollow
+)
+
+var (
+	symlinkPolicyToString = map[SymlinkPolicy]string{
+		SymlinksSkip:   "skip",
+		SymlinksRecord: "record",
+		SymlinksFollow: "follow",
+	}
+	symlinkPolicy = SymlinksSkip
+
+	// The `stat` of each directory entered in the walk, used to detect cycles
+	walkedDirs     = map[string]walkedDir{}
+	walkedDirsLock = sync.Mutex{}
+)
+
+type walkedDir struct {
+	id   DevIno
+	uid  uint32
+	gid  uint32
+	mode os.FileMode
+}
+
+type entryKind int
+
+const (
+	entrySkip entryKind = iota
+	entryDir
+	entryFile
+)
+
+func SetSymlinkPolicy(newVal SymlinkPolicy) {
+	symlinkPolicy = newVal
+}
+
+func GetSymlinkPolicy() SymlinkPolicy {
+	return symlinkPolicy
+}
+
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	for p, ps := range symlinkPolicyToString {
+		if ps == s {
+			return p, nil
+		}
+	}
+	return SymlinksSkip, fmt.Errorf("invalid symlink policy '%s', must be one of: skip, record, follow", s)
+}
+
+/*
+Decides if a `readdir` entry should be walked as a directory, added as a file or
+skipped, depending on its type and the `symlinkPolicy`
+*/
+func getEntryKind(fullPath string, e os.DirEntry) entryKind {
+	if e.IsDir() {
+		return entryDir
+	} else if e.Type().IsRegular() {
+		return entryFile
+	} else if e.Type()&os.ModeSymlink == 0 {
+		return entrySkip
+	}
+
+	switch symlinkPolicy {
+	case SymlinksRecord:
+		return entryFile
+	case SymlinksFollow:
+		targetStat, err := os.Stat(fullPath)
+		if err != nil {
+			// Broken link, record it
+			return entryFile
+		} else if targetStat.IsDir() {
+			return entryDir
+		} else if targetStat.Mode().IsRegular() {
+			return entryFile
+		}
+	}
+	return entrySkip
+}
+
+/*
+Gets the `os.FileInfo` for a file entry. If the entry is a symlink its target is
+also recorded in `nf`, and when following symlinks the info is for the target
+*/
+func getFileInfo(fullPath string, e os.DirEntry, nf *File) (os.FileInfo, error) {
+	iopsLimiter.wait(1)
+	if e.Type()&os.ModeSymlink == 0 {
+		return e.Info()
+	}
+
+	target, err := os.Readlink(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	nf.LinkTarget = target
+
+	targetStat, err := os.Stat(fullPath)
+	nf.LinkBroken = err != nil
+	if symlinkPolicy == SymlinksFollow && !nf.LinkBroken {
+		return targetStat, nil
+	}
+	return e.Info()
+}
+
+/*
+A recorded (i.e. not followed) or broken symlink has no contents of its own to hash
+*/
+func isHashable(f File) bool {
+	return f.LinkTarget == "" || (symlinkPolicy == SymlinksFollow && !f.LinkBroken)
+}
+
+/*
+Clears the directories recorded by `enterDir` and reloads the system's mounts,
+must be called before each walk
+*/
+func resetWalkedDirs() {
+	walkedDirsLock.Lock()
+	walkedDirs = map[string]walkedDir{}
+	loadMounts()
+	walkedDirsLock.Unlock()
+}
+
+/*
+Records the `stat` of the directory at `dirPath` and decides if it should
+be walked. Returns false if it's on a filesystem that shouldn't be walked (see
+`onWalkableFilesystem`) or, when following symlinks, if it's the same directory
+as one of its ancestors up to `rootPath`, i.e. walking it would cause a cycle
+*/
+func enterDir(rootPath, dirPath string) bool {
+	iopsLimiter.wait(1)
+	dirStat, err := os.Stat(dirPath)
+	if err != nil {
+		// Let the walk record the error
+		return true
+	}
+	id, ok := GetDevIno(dirStat)
+	if !ok {
+		return true
+	}
+
+	rootPath = trimRootPath(rootPath)
+	walkedDirsLock.Lock()
+	defer walkedDirsLock.Unlock()
+	wd := walkedDir{id: id, mode: dirStat.Mode()}
+	wd.uid, wd.gid, _ = getOwner(dirStat)
+	walkedDirs[dirPath] = wd
+
+	if dirPath != rootPath {
+		if root, ok := walkedDirs[rootPath]; ok && !onWalkableFilesystem(root.id.Dev, id.Dev) {
+			return false
+		}
+	}
+
+	if symlinkPolicy != SymlinksFollow {
+		return true
+	}
+	for p := dirPath; p != rootPath && strings.HasPrefix(p, rootPath); {
+		p = path.Dir(p)
+		if ancestor, ok := walkedDirs[p]; ok && ancestor.id == id {
+			return false
+		}
+		if p == "/" || p == "." {
+			break
+		}
+	}
+	return true
+}
+
+/*
+Populates the device, owner and mode of `t` from its `stat`, recorded by `enterDir`
+*/
+func setWalkedDirStat(t *FileTree) {
+	walkedDirsLock.Lock()
+	wd := walkedDirs[t.BasePath]
+	walkedDirsLock.Unlock()
+
+	t.Dev = wd.id.Dev
+	t.Uid = wd.uid
+	t.Gid = wd.gid
+	t.Mode = wd.mode
+}
+
+/*
+Gets all the broken symlinks in a tree (only recorded when symlinks aren't skipped)
+*/
+func (t *FileTree) GetBrokenLinks() []File {
+	brokenLinks := []File{}
+	for _, f := range t.Files {
+		if f.LinkBroken {
+			brokenLinks = append(brokenLinks, f)
+		}
+	}
+	for i := range t.SubTrees {
+		brokenLinks = append(brokenLinks, t.SubTrees[i].GetBrokenLinks()...)
+	}
+	return brokenLinks
+}
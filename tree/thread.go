@@ -1,14 +1,10 @@
 package tree
 
 import (
-	"crypto/sha256"
-	"io"
-	"math"
 	"os"
 	"sync"
 	"time"
 
-	"github.com/joomcode/errorx"
 	"github.com/pericles-tpt/seye/stats"
 	"github.com/pericles-tpt/seye/utility"
 )
@@ -21,6 +17,7 @@ the IMMEDIATE directory.
 The 'path' for the `DirJob` to operate on is located in `buildQ[ThisIndexInBuildQ]`
 */
 type DirJob struct {
+	RootPath        string
 	ThisIndexBuildQ int
 	AllHashByte     *[]byte
 	IsComprehensive bool
@@ -41,7 +38,6 @@ type FileJob struct {
 	ThisIndexInFiles   int
 	Entry              os.DirEntry
 	File               File
-	ErrStrings         []string
 	IsComprehensive    bool
 	LastModified       time.Time
 	AllHashByte        *[]byte
@@ -52,78 +48,89 @@ type FileJob struct {
 }
 
 var (
-	maxNumThreadsComprehensive  = 8
-	maxNumThreadsShallow        = 8
-	idleThreadsForComprehensive = 50 * time.Microsecond
-	idleThreadsForShallow       = 50 * time.Microsecond
-	buildQLock                  = sync.Mutex{}
-	walkQLock                   = sync.Mutex{}
-	threadsBytesRead            = []int64{}
-	threadsFilesRead            = []int64{}
-
-	dirJobQueues      = [][]DirJob{}
-	dirJobQueueLocks  = []sync.Mutex{}
+	maxNumThreadsComprehensive = 8
+	maxNumThreadsShallow       = 8
+	// Jobs that can be waiting per thread before whoever is producing them has
+	// to wait for a thread to free up (i.e. back-pressure)
+	jobQueueLenPerThread = 256
+	buildQLock           = sync.Mutex{}
+	threadsBytesRead     = []int64{}
+	threadsFilesRead     = []int64{}
+
 	threadsCopyBuffer = [][]byte{}
 	allHashLock       = sync.Mutex{}
 
-	fileJobQueues     = [][]FileJob{}
-	fileJobQueueLocks = []sync.Mutex{}
-	buildQS           = []FileTree{}
-	buildQSLock       = sync.Mutex{}
+	buildQS     = []FileTree{}
+	buildQSLock = sync.Mutex{}
 
 	walkLock = sync.Mutex{}
 )
 
+/*
+Gets the number of threads a multithreaded walk uses for the type of scan
+*/
+func GetNumThreads(isComprehensive bool) int {
+	if isComprehensive {
+		return maxNumThreadsComprehensive
+	}
+	return maxNumThreadsShallow
+}
+
 /*
 Performs "read" and "hash" operations on a file, supports MT
 */
-func readHashFile(rl ReadLocation, threadNum int) (utility.HashLocation, []string) {
-	var errStrings []string
+func readHashFile(rl ReadLocation, threadNum int) (utility.HashLocation, os.FileInfo, *WalkError) {
+	var walkErr *WalkError
 	hl := utility.InitialiseHashLocation()
+	stat := rl.Stat
 
 	timer := time.Now()
-	fTmp, err := os.OpenFile(rl.FullPath, os.O_RDONLY, 0400)
+	fTmp, err := openForHashing(rl.FullPath)
 	if err != nil {
-		errStrings = append(errStrings, errorx.Decorate(err, "failed to open file to get `Hash` for 'Comprehensive' scan").Error())
-	} else if rl.Size > 0 {
-		h := sha256.New()
-		n, err := io.CopyBuffer(h, fTmp, threadsCopyBuffer[threadNum])
+		we := newWalkError(rl.FullPath, OpOpen, err)
+		walkErr = &we
+	} else if rl.Stat.Size() > 0 {
+		var (
+			hashedBytes []byte
+			hashType    utility.HashType
+			n           int64
+		)
+		hashedBytes, hashType, stat, n, err = hashStable(fTmp, rl.Stat, threadsCopyBuffer[threadNum])
 		if err != nil {
-			errStrings = append(errStrings, errorx.Decorate(err, "failed to read file to get `Hash` for 'Comprehensive' scan").Error())
+			we := newWalkError(rl.FullPath, OpRead, err)
+			walkErr = &we
 		} else {
-			timeSpentReading += time.Since(timer)
-			totalBytesRead += float64(n)
 			threadsBytesRead[threadNum] += n
 			threadsFilesRead[threadNum]++
-			totalFilesRead++
 
-			hashedBytes := h.Sum(nil)
-			for i := 0; i < rl.HashLength; i++ {
-				(*rl.AllHashByte)[rl.HashOffset+i] = hashedBytes[i]
-			}
-			hl.Type = utility.SHA256
+			// Other threads may grow `AllHashByte` while this one hashes, so it's only written under its lock
+			allHashLock.Lock()
+			copy((*rl.AllHashByte)[rl.HashOffset:rl.HashOffset+rl.HashLength], hashedBytes)
+			allHashLock.Unlock()
+			hl.Type = hashType
 			hl.HashOffset = rl.HashOffset
 			hl.HashLength = rl.HashLength
 
 			walkLock.Lock()
+			timeSpentReading += time.Since(timer)
+			totalBytesRead += float64(n)
+			totalFilesRead++
 			if rl.WalkStats != nil {
-				rl.WalkStats.UpdateDuplicates(hashedBytes[:], rl.Size, rl.FullPath)
+				allocated, _ := getAllocated(stat)
+				rl.WalkStats.UpdateDuplicates(hashedBytes[:], reportedSize(stat.Size(), allocated), rl.FullPath, rl.Dev, rl.Ino)
 			}
 			walkLock.Unlock()
 		}
 	}
-	defer fTmp.Close()
-	return hl, errStrings
+	defer closeAfterHashing(fTmp)
+	return hl, stat, walkErr
 }
 
 /*
- */
-func doFileJob(threadNum int) {
-	// Dequeue file job
-	fileJobQueueLocks[threadNum].Lock()
-	currJob := fileJobQueues[threadNum][0]
-	fileJobQueueLocks[threadNum].Unlock()
-
+Performs the `stat`, "read" and "hash" operations for a single `FileJob`, then
+writes the resultant `File` to its placeholder in `buildQS`
+*/
+func doFileJob(currJob FileJob, threadNum int) {
 	currJob.File = File{
 		Name: currJob.FullPath,
 		Hash: utility.InitialiseHashLocation(),
@@ -131,94 +138,105 @@ func doFileJob(threadNum int) {
 
 	timer := time.Now()
 	// stat(), syscall
-	fStat, err := currJob.Entry.Info()
+	fStat, err := getFileInfo(currJob.FullPath, currJob.Entry, &currJob.File)
 	if err != nil {
-		currJob.ErrStrings = append(currJob.ErrStrings, errorx.Decorate(err, "failed to stat file").Error())
+		we := newWalkError(currJob.FullPath, OpStat, err)
+		currJob.File.WalkErr = &we
 	} else {
+		walkLock.Lock()
 		timeSpentStating += time.Since(timer)
 		totalFilesStated++
-		currJob.File.LastModified = fStat.ModTime()
-		currJob.File.Size = fStat.Size()
-		if currJob.IsComprehensive {
+		walkLock.Unlock()
+		setFileStat(&currJob.File, fStat)
+		if currJob.IsComprehensive && isHashable(currJob.File) {
 			currJob.File.Hash = utility.InitialiseHashLocation()
-			errStrings := []string{}
 			// do "read" and "hash" of file
-			currJob.File.Hash, errStrings = getFileDataS(ReadLocation{
+			currJob.File.Hash, fStat, currJob.File.WalkErr = getFileDataS(ReadLocation{
 				WalkStats:   currJob.WalkStats,
 				HashOffset:  currJob.HashOffset,
 				HashLength:  currJob.HashLength,
 				FullPath:    currJob.FullPath,
-				Size:        fStat.Size(),
+				Stat:        fStat,
+				Dev:         currJob.File.Dev,
+				Ino:         currJob.File.Ino,
 				AllHashByte: currJob.AllHashByte,
 			}, threadNum)
-			if len(errStrings) > 0 {
-				currJob.ErrStrings = append(currJob.ErrStrings, errStrings...)
-			}
+			// The file may have changed while being hashed, keep the `stat` the hash matches
+			setFileStat(&currJob.File, fStat)
 		}
 	}
 
 	walkLock.Lock()
 	if currJob.WalkStats != nil {
-		currJob.WalkStats.UpdateLargestFiles(stats.BasicFile{Path: currJob.FullPath, Size: currJob.File.Size})
+		currJob.WalkStats.UpdateLargestFiles(stats.BasicFile{Path: currJob.FullPath, Size: currJob.File.ReportedSize()})
 	}
 	walkLock.Unlock()
 
 	buildQSLock.Lock()
 	buildQS[currJob.ParentIndexInQueue].Files[currJob.ThisIndexInFiles] = currJob.File
-	buildQS[currJob.ParentIndexInQueue].ErrStrings = append(buildQS[currJob.ParentIndexInQueue].ErrStrings, currJob.ErrStrings...)
 	buildQS[currJob.ParentIndexInQueue].LastModifiedDirect = utility.GetNewestTime(buildQS[currJob.ParentIndexInQueue].LastModifiedDirect, currJob.File.LastModified)
 	buildQS[currJob.ParentIndexInQueue].SizeDirect += currJob.File.Size
+	buildQS[currJob.ParentIndexInQueue].AllocatedDirect += currJob.File.Allocated
 	buildQSLock.Unlock()
-
-	dequeueFileJob(threadNum)
-}
-
-func pushFileJobOnQueue(j FileJob, threadNum int) {
-	fileJobQueueLocks[threadNum].Lock()
-	fileJobQueues[threadNum] = append(fileJobQueues[threadNum], j)
-	fileJobQueueLocks[threadNum].Unlock()
 }
 
-func dequeueFileJob(threadNum int) {
-	fileJobQueueLocks[threadNum].Lock()
-	fileJobQueues[threadNum] = fileJobQueues[threadNum][1:]
-	fileJobQueueLocks[threadNum].Unlock()
-}
-
-func doDirJob(threadNum int) {
-	// Dequeue dir job
-	dirJobQueueLocks[threadNum].Lock()
-	currJob := dirJobQueues[threadNum][0]
-	dirJobQueueLocks[threadNum].Unlock()
+/*
+Performs the `readdir` for a `DirJob`, then the `stat`, "read" and "hash"
+operations for each of its immediate files.
 
+Returns a `DirJob` for each subdirectory found, their placeholders have already
+been added to `buildQ` at the next depth
+*/
+func doDirJob(currJob DirJob, threadNum int) []DirJob {
 	startWalk := time.Now()
 
 	newNodesDepth := currJob.Depth + 1
 
+	// Only this thread modifies this node, so it's populated locally then
+	// written back to `buildQ` once at the end
 	buildQLock.Lock()
 	currTree := buildQ[currJob.Depth][currJob.ThisIndexBuildQ]
 	buildQLock.Unlock()
 
 	currTree.Comprehensive = currJob.IsComprehensive
 
-	pathChildren, err := os.ReadDir(currTree.BasePath)
-	currTree.ErrStrings = []string{}
+	pathChildren, err := readDir(currTree.BasePath)
 	if err != nil {
-		currTree.ErrStrings = append(currTree.ErrStrings, errorx.Decorate(err, "failed to open `BasePath`").Error())
+		currTree.Errors = append(currTree.Errors, newWalkError(currTree.BasePath, OpReadDir, err))
 	}
 
 	currTree.Depth = currJob.Depth
 	currTree.LastVisited = time.Now()
+	setWalkedDirStat(&currTree)
 
-	childrenFiles := []os.DirEntry{}
+	var (
+		childrenFiles = []os.DirEntry{}
+		childrenDirs  = []DirJob{}
+	)
 	for _, c := range pathChildren {
-		if c.IsDir() {
-			fullPath := getFullPath(currTree.BasePath, c.Name())
+		fullPath := getFullPath(currTree.BasePath, c.Name())
 
-			walkQLock.Lock()
-			walkQ[newNodesDepth] = append(walkQ[newNodesDepth], FileTree{BasePath: fullPath})
-			walkQLock.Unlock()
-		} else if c.Type().IsRegular() {
+		switch getEntryKind(fullPath, c) {
+		case entryDir:
+			if utility.Contains(ignoredDirs, c.Name()) || !enterDir(currJob.RootPath, fullPath) {
+				continue
+			}
+
+			buildQLock.Lock()
+			for newNodesDepth > len(buildQ)-1 {
+				buildQ = append(buildQ, []FileTree{})
+			}
+			buildQ[newNodesDepth] = append(buildQ[newNodesDepth], FileTree{BasePath: fullPath})
+			childrenDirs = append(childrenDirs, DirJob{
+				RootPath:        currJob.RootPath,
+				ThisIndexBuildQ: len(buildQ[newNodesDepth]) - 1,
+				AllHashByte:     currJob.AllHashByte,
+				IsComprehensive: currJob.IsComprehensive,
+				Depth:           newNodesDepth,
+				WalkStats:       currJob.WalkStats,
+			})
+			buildQLock.Unlock()
+		case entryFile:
 			childrenFiles = append(childrenFiles, c)
 		}
 	}
@@ -232,11 +250,6 @@ func doDirJob(threadNum int) {
 	for i, cf := range childrenFiles {
 		fullPath := getFullPath(currTree.BasePath, cf.Name())
 
-		// 1. Add file placeholder to parent `Files`
-		buildQLock.Lock()
-		buildQ[currJob.Depth][currJob.ThisIndexBuildQ].Files = append(buildQ[currJob.Depth][currJob.ThisIndexBuildQ].Files, File{})
-		buildQLock.Unlock()
-
 		// Do the stat
 		nf := File{
 			Name: fullPath,
@@ -244,128 +257,100 @@ func doDirJob(threadNum int) {
 		}
 
 		timer := time.Now()
-		fStat, err := cf.Info()
+		fStat, err := getFileInfo(fullPath, cf, &nf)
 		if err != nil {
-			currTree.ErrStrings = append(currTree.ErrStrings, errorx.Decorate(err, "failed to stat file").Error())
+			we := newWalkError(fullPath, OpStat, err)
+			nf.WalkErr = &we
 		} else {
+			walkLock.Lock()
 			timeSpentStating += time.Since(timer)
 			totalFilesStated++
-			nf.LastModified = fStat.ModTime()
-			nf.Size = fStat.Size()
-			if currTree.Comprehensive {
+			walkLock.Unlock()
+			setFileStat(&nf, fStat)
+			if currTree.Comprehensive && isHashable(nf) {
 				nf.Hash = utility.InitialiseHashLocation()
 
-				var errStrings []string
-				nf.Hash, errStrings = readHashFile(ReadLocation{
+				nf.Hash, fStat, nf.WalkErr = readHashFile(ReadLocation{
 					WalkStats:   currJob.WalkStats,
 					HashOffset:  lenAllBytesBeforeChildren + i*chosenHash,
 					HashLength:  chosenHash,
 					FullPath:    fullPath,
-					Size:        nf.Size,
+					Stat:        fStat,
+					Dev:         nf.Dev,
+					Ino:         nf.Ino,
 					AllHashByte: currJob.AllHashByte,
 				}, threadNum)
-				currTree.ErrStrings = append(currTree.ErrStrings, errStrings...)
+				// The file may have changed while being hashed, keep the `stat` the hash matches
+				setFileStat(&nf, fStat)
 			}
 		}
 
 		walkLock.Lock()
 		if currJob.WalkStats != nil {
-			currJob.WalkStats.UpdateLargestFiles(stats.BasicFile{Path: nf.Name, Size: nf.Size})
+			currJob.WalkStats.UpdateLargestFiles(stats.BasicFile{Path: nf.Name, Size: nf.ReportedSize()})
 		}
+		totalFilesFound++
 		walkLock.Unlock()
 
-		buildQLock.Lock()
-		buildQ[currJob.Depth][currJob.ThisIndexBuildQ].Files[i] = nf
-		buildQ[currJob.Depth][currJob.ThisIndexBuildQ].ErrStrings = append(buildQ[currJob.Depth][currJob.ThisIndexBuildQ].ErrStrings, currTree.ErrStrings...)
-		buildQ[currJob.Depth][currJob.ThisIndexBuildQ].LastModifiedDirect = utility.GetNewestTime(buildQ[currJob.Depth][currJob.ThisIndexBuildQ].LastModifiedDirect, nf.LastModified)
-		buildQ[currJob.Depth][currJob.ThisIndexBuildQ].SizeDirect += nf.Size
-		buildQLock.Unlock()
-
-		totalFilesFound++
+		currTree.Files = append(currTree.Files, nf)
+		currTree.LastModifiedDirect = utility.GetNewestTime(currTree.LastModifiedDirect, nf.LastModified)
+		currTree.SizeDirect += nf.Size
+		currTree.AllocatedDirect += nf.Allocated
 	}
 
+	currTree.NumFilesDirect = int64(len(currTree.Files))
+	currTree.TimeTaken = time.Since(startWalk) // This is a bit less acurrate now with MT...
+
 	buildQLock.Lock()
-	buildQ[currJob.Depth][currJob.ThisIndexBuildQ].NumFilesDirect = int64(len(buildQ[currJob.Depth][currJob.ThisIndexBuildQ].Files))
-	buildQ[currJob.Depth][currJob.ThisIndexBuildQ].TimeTaken = time.Since(startWalk) // This is a bit less acurrate now with MT...
+	buildQ[currJob.Depth][currJob.ThisIndexBuildQ] = currTree
 	buildQLock.Unlock()
 
-	dequeueDirJob(threadNum)
-}
-
-func pushDirJobOnQueue(j DirJob, threadNum int) {
-	dirJobQueueLocks[threadNum].Lock()
-	dirJobQueues[threadNum] = append(dirJobQueues[threadNum], j)
-	dirJobQueueLocks[threadNum].Unlock()
+	return childrenDirs
 }
 
-func dequeueDirJob(threadNum int) {
-	dirJobQueueLocks[threadNum].Lock()
-	dirJobQueues[threadNum] = dirJobQueues[threadNum][1:]
-	dirJobQueueLocks[threadNum].Unlock()
-}
-
-func getFileDataS(rl ReadLocation, threadNum int) (utility.HashLocation, []string) {
-	var errStrings []string
+func getFileDataS(rl ReadLocation, threadNum int) (utility.HashLocation, os.FileInfo, *WalkError) {
+	var walkErr *WalkError
 	hl := utility.InitialiseHashLocation()
+	stat := rl.Stat
 
 	timer := time.Now()
-	fTmp, err := os.OpenFile(rl.FullPath, os.O_RDONLY, 0400)
+	fTmp, err := openForHashing(rl.FullPath)
 	if err != nil {
-		errStrings = append(errStrings, errorx.Decorate(err, "failed to open file to get `Hash` for 'Comprehensive' scan").Error())
-	} else if rl.Size > 0 {
-		h := sha256.New()
-		n, err := io.CopyBuffer(h, fTmp, threadsCopyBuffer[threadNum])
+		we := newWalkError(rl.FullPath, OpOpen, err)
+		walkErr = &we
+	} else if rl.Stat.Size() > 0 {
+		var (
+			hashedBytes []byte
+			hashType    utility.HashType
+			n           int64
+		)
+		hashedBytes, hashType, stat, n, err = hashStable(fTmp, rl.Stat, threadsCopyBuffer[threadNum])
 		if err != nil {
-			errStrings = append(errStrings, errorx.Decorate(err, "failed to read file to get `Hash` for 'Comprehensive' scan").Error())
+			we := newWalkError(rl.FullPath, OpRead, err)
+			walkErr = &we
 		} else {
-			timeSpentReading += time.Since(timer)
-			totalBytesRead += float64(n)
 			threadsBytesRead[threadNum] += n
 			threadsFilesRead[threadNum]++
-			totalFilesRead++
-			hashedBytes := h.Sum(nil)
-			for i := 0; i < rl.HashLength; i++ {
-				(*rl.AllHashByte)[rl.HashOffset+i] = hashedBytes[i]
-			}
-			hl.Type = utility.SHA256
+
+			// Other threads may grow `AllHashByte` while this one hashes, so it's only written under its lock
+			allHashLock.Lock()
+			copy((*rl.AllHashByte)[rl.HashOffset:rl.HashOffset+rl.HashLength], hashedBytes)
+			allHashLock.Unlock()
+			hl.Type = hashType
 			hl.HashOffset = rl.HashOffset
 			hl.HashLength = rl.HashLength
 
 			walkLock.Lock()
+			timeSpentReading += time.Since(timer)
+			totalBytesRead += float64(n)
+			totalFilesRead++
 			if rl.WalkStats != nil {
-				rl.WalkStats.UpdateDuplicates(hashedBytes[:], rl.Size, rl.FullPath)
+				allocated, _ := getAllocated(stat)
+				rl.WalkStats.UpdateDuplicates(hashedBytes[:], reportedSize(stat.Size(), allocated), rl.FullPath, rl.Dev, rl.Ino)
 			}
 			walkLock.Unlock()
 		}
 	}
-	defer fTmp.Close()
-	return hl, errStrings
-}
-
-func chooseThreadDir(numThreads, totalDirsFound int, isComprehensive bool) int {
-	chosen := int(math.Mod(float64(totalDirsFound), float64(numThreads)))
-	if isComprehensive {
-		leastJobs := math.MaxInt
-		for j, q := range dirJobQueues {
-			if len(q) < leastJobs {
-				leastJobs = len(q)
-				chosen = j
-			}
-		}
-	}
-	return chosen
-}
-
-func chooseThreadFile(numThreads, totalFilesFound int, isComprehensive bool) int {
-	chosen := int(math.Mod(float64(totalFilesFound), float64(numThreads)))
-	if isComprehensive {
-		leastJobs := math.MaxInt
-		for j, q := range fileJobQueues {
-			if len(q) < leastJobs {
-				leastJobs = len(q)
-				chosen = j
-			}
-		}
-	}
-	return chosen
+	defer closeAfterHashing(fTmp)
+	return hl, stat, walkErr
 }
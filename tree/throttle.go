@@ -0,0 +1,126 @@
+package tree
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+A token bucket, shared by all the threads of a walk. Holds at most one second's worth of tokens,
+so a burst after being idle can't exceed the rate by more than that
+*/
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   float64 // tokens per second
+	tokens float64
+	last   time.Time
+}
+
+var (
+	maxReadRate  int64 // bytes per second, 0 is unlimited
+	maxIOPS      int64 // `stat`s and `readdir`s per second, 0 is unlimited
+	idlePriority bool
+
+	readLimiter *rateLimiter
+	iopsLimiter *rateLimiter
+)
+
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+/*
+Takes `n` tokens from the bucket, sleeping until they're available. A nil `rateLimiter`
+is unlimited
+*/
+func (r *rateLimiter) wait(n int64) {
+	if r == nil || n <= 0 {
+		return
+	}
+
+	r.lock.Lock()
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.rate {
+		r.tokens = r.rate
+	}
+	r.last = now
+
+	// Going into debt reserves the tokens for this caller, later callers wait behind it
+	r.tokens -= float64(n)
+	var sleep time.Duration
+	if r.tokens < 0 {
+		sleep = time.Duration(-r.tokens / r.rate * float64(time.Second))
+	}
+	r.lock.Unlock()
+
+	time.Sleep(sleep)
+}
+
+/*
+Limits the rate that files are read at while hashing, in bytes per second (0 is unlimited)
+*/
+func SetMaxReadRate(bytesPerSecond int64) {
+	maxReadRate = bytesPerSecond
+	readLimiter = newRateLimiter(bytesPerSecond)
+}
+
+func GetMaxReadRate() int64 {
+	return maxReadRate
+}
+
+/*
+Limits the rate of `stat` and `readdir` operations, per second (0 is unlimited)
+*/
+func SetMaxIOPS(opsPerSecond int64) {
+	maxIOPS = opsPerSecond
+	iopsLimiter = newRateLimiter(opsPerSecond)
+}
+
+func GetMaxIOPS() int64 {
+	return maxIOPS
+}
+
+type throttledReader struct {
+	r io.Reader
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	readLimiter.wait(int64(n))
+	return n, err
+}
+
+/*
+Wraps `r` so reads from it are limited by `maxReadRate`
+*/
+func throttleReads(r io.Reader) io.Reader {
+	if readLimiter == nil {
+		return r
+	}
+	return throttledReader{r: r}
+}
+
+/*
+`os.ReadDir`, limited by `maxIOPS`
+*/
+func readDir(path string) ([]os.DirEntry, error) {
+	iopsLimiter.wait(1)
+	return os.ReadDir(path)
+}
+
+/*
+Whether the walk is running at idle priority, see `SetIdlePriority`
+*/
+func GetIdlePriority() bool {
+	return idlePriority
+}
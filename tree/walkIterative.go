@@ -2,14 +2,11 @@ package tree
 
 import (
 	"crypto/sha256"
-	"fmt"
 	"os"
 	"path"
-	"strings"
 	"sync"
 	"time"
 
-	"github.com/joomcode/errorx"
 	"github.com/pericles-tpt/seye/stats"
 	"github.com/pericles-tpt/seye/utility"
 )
@@ -26,8 +23,6 @@ var (
 	timeSpentStating time.Duration
 	timeSpentReading time.Duration // also time spent hashing
 	buildQ           = [][]FileTree{}
-	walkQ            = [][]FileTree{}
-	mainDone         = false
 	totalBytesRead   float64
 	totalFilesRead   int64
 	totalFilesStated float64
@@ -35,60 +30,38 @@ var (
 	readdirTimeTaken time.Duration
 	totalFilesFound  = 0
 	totalDirsFound   = 0
-	idleCount        = 0
 )
 
 /*
-WARNING: This algorithm doesn't produce valid trees at the moment, tree order past the root is non-deterministic
-
 A walk algorithm that runs `readdir` operations on immediate files (`stat`, "read" and "hash") for multiple dirs
 across multiple threads.
 
+Threads take `DirJob`s from a shared channel and put the jobs for any subdirectories they find back on it, so
+a thread is never left idle while another has a backlog of directories. If the channel is full, the thread keeps
+the jobs in its own stack until there's room for them again.
+
+Since threads finish in any order, each depth of `buildQ` is sorted and `AllHash` is rewritten afterwards, so the
+resultant tree is the same as one from `WalkGenerateTreeRecursive` or `WalkTreeIterativeFile`.
+
 Fastest for "shallow" scans, but slower than `WalkTreeIterativeFile` for "comprehensive"
 */
 func WalkTreeIterativeDir(rootPath string, isComprehensive bool, walkStats *stats.WalkStats) (t *FileTree) {
 	totalFilesFound = 0
 	totalDirsFound = 0
-	idleCount = 0
 
-	rootPath = strings.TrimSuffix(rootPath, "/")
+	rootPath = trimRootPath(rootPath)
 	var (
 		allHashBytes = []byte{}
 	)
-	walkQ = make([][]FileTree, 1)
-	buildQ = make([][]FileTree, 1)
-	walkQ[0] = append(walkQ[0], FileTree{BasePath: rootPath})
+	buildQ = [][]FileTree{{{BasePath: rootPath}}}
+	resetWalkedDirs()
+	enterDir(rootPath, rootPath)
 
 	numThreads := maxNumThreadsComprehensive
 	if !isComprehensive {
 		numThreads = maxNumThreadsShallow
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(numThreads)
-	dirJobQueues = make([][]DirJob, numThreads)
-	dirJobQueueLocks = make([]sync.Mutex, numThreads)
-	for i := 0; i < numThreads; i++ {
-		dirJobQueues[i] = []DirJob{}
-		go func(n int) {
-			for {
-				if len(dirJobQueues[n]) > 0 {
-					doDirJob(n)
-				} else if !mainDone {
-					if isComprehensive {
-						time.Sleep(idleThreadsForComprehensive)
-					} else {
-						time.Sleep(idleThreadsForShallow)
-					}
-					idleCount++
-				} else {
-					break
-				}
-			}
-			wg.Done()
-		}(i)
-	}
-
 	threadsBytesRead = make([]int64, numThreads)
 	threadsFilesRead = make([]int64, numThreads)
 	threadsCopyBuffer = make([][]byte, numThreads)
@@ -98,79 +71,55 @@ func WalkTreeIterativeDir(rootPath string, isComprehensive bool, walkStats *stat
 	totalBytesRead = 0
 	totalFilesRead = 0
 	totalFilesStated = 0
-	a := time.Now()
-	depth := len(walkQ) - 1
-	// Append next level of walkQ, for threads to put their new nodes
-	for {
-		var t FileTree
-
-		walkQLock.Lock()
-		for depth+1 > len(walkQ)-1 {
-			walkQ = append(walkQ, []FileTree{})
-		}
-		walkQLock.Unlock()
-
-		// Problem is what if a thread is assigned from the previous level and not given anything from the new level... this won't trigger
-		allThreadsEmpty := true
-		for _, tq := range dirJobQueues {
-			allThreadsEmpty = allThreadsEmpty && (len(tq) == 0)
-		}
-
-		// i.e. stops from moving onto next depth unless finished all items at this depth... we could go faster...
-		walkQLock.Lock()
-		if len(walkQ[depth]) == 0 && allThreadsEmpty {
-			depth++
-			fmt.Printf("At depth %d, time taken %d ms\n", depth, time.Since(a).Milliseconds())
-		}
-		walkQLock.Unlock()
-		walkQLock.Lock()
-		if len(walkQ[depth]) > 0 {
-			walkQ, t = popFront2D(walkQ, depth)
-		} else {
-			walkQLock.Unlock()
-			ae := true
-			for _, tq := range dirJobQueues {
-				ae = ae && (len(tq) == 0)
-			}
-			if ae {
-				break
-			}
-			// fmt.Println("Nothing at depth: ", depth)
-			time.Sleep(10 * time.Microsecond)
-			continue
-		}
-		walkQLock.Unlock()
-
-		if utility.Contains(ignoredDirs, path.Base(t.BasePath)) {
-			continue
-		}
 
-		buildQLock.Lock()
-		for depth+1 > len(buildQ)-1 {
-			buildQ = append(buildQ, []FileTree{})
-		}
-		thisIndexBuildQ := len(buildQ[depth])
-		buildQ[depth] = append(buildQ[depth], t)
-		buildQLock.Unlock()
+	var (
+		wg sync.WaitGroup
+		// Incremented for each `DirJob` BEFORE it's queued, decremented once it's done
+		pendingDirJobs sync.WaitGroup
+		dirJobs        = make(chan DirJob, numThreads*jobQueueLenPerThread)
+	)
+	pendingDirJobs.Add(1)
+	dirJobs <- DirJob{
+		RootPath:        rootPath,
+		ThisIndexBuildQ: 0,
+		AllHashByte:     &allHashBytes,
+		IsComprehensive: isComprehensive,
+		Depth:           0,
+		WalkStats:       walkStats,
+	}
 
-		// 2. Pick a thread to assign the task too
-		chosenThread := chooseThreadDir(numThreads, totalDirsFound, isComprehensive)
+	wg.Add(numThreads)
+	for i := 0; i < numThreads; i++ {
+		go func(n int) {
+			defer wg.Done()
 
-		// TODO: Record which threads are handling, which dirs, so we can add the resultant `FileTree`s back in correct order
+			var (
+				job      DirJob
+				ok       bool
+				overflow = []DirJob{}
+			)
+			for {
+				if len(overflow) > 0 {
+					overflow = offerDirJobs(dirJobs, overflow)
+				}
 
-		// 3. Create job for thread + add it to the queue
-		pushDirJobOnQueue(DirJob{
-			ThisIndexBuildQ: thisIndexBuildQ,
-			AllHashByte:     &allHashBytes,
-			IsComprehensive: isComprehensive,
-			Depth:           depth,
-			WalkStats:       walkStats,
-		}, chosenThread)
+				if len(overflow) > 0 {
+					job = overflow[len(overflow)-1]
+					overflow = overflow[:len(overflow)-1]
+				} else if job, ok = <-dirJobs; !ok {
+					return
+				}
 
-		totalDirsFound++
+				newJobs := doDirJob(job, n)
+				pendingDirJobs.Add(len(newJobs))
+				overflow = append(overflow, offerDirJobs(dirJobs, newJobs)...)
+				pendingDirJobs.Done()
+			}
+		}(i)
 	}
 
-	mainDone = true
+	pendingDirJobs.Wait()
+	close(dirJobs)
 	wg.Wait()
 
 	// var speedStr string
@@ -183,6 +132,7 @@ func WalkTreeIterativeDir(rootPath string, isComprehensive bool, walkStats *stat
 	// }
 	// fmt.Printf("Traversed %d directories, found %d files, %s\n", totalDirsFound, totalFilesFound, speedStr)
 
+	sortBuildQLevels(buildQ)
 	newBuildQ := []FileTree{}
 	for _, arr := range buildQ {
 		newBuildQ = append(newBuildQ, arr...)
@@ -191,44 +141,60 @@ func WalkTreeIterativeDir(rootPath string, isComprehensive bool, walkStats *stat
 
 	tree := constructTreeFromIterativeQ(&newBuildQ)
 	tree.AllHash = allHashBytes
+	relayoutAllHash(&tree)
+	countHardlinksOnce(&tree)
+	recordChunkedHashing(&tree)
 
 	return &tree
 }
 
+/*
+Puts as many `jobs` on the `dirJobs` channel as it can without blocking, returns
+the jobs that didn't fit
+*/
+func offerDirJobs(dirJobs chan<- DirJob, jobs []DirJob) []DirJob {
+	for i, j := range jobs {
+		select {
+		case dirJobs <- j:
+		default:
+			return jobs[i:]
+		}
+	}
+	return []DirJob{}
+}
+
 /*
 A walk algorithm that runs file operations (`stat`, "read" and "hash") for multiple files across multiple threads.
 
 Fastest for "comprehensive" scans, but slower than `WalkTreeIterativeDir` for "shallow" scans
 */
 func WalkTreeIterativeFile(rootPath string, depth int, isComprehensive bool, walkStats *stats.WalkStats) (t *FileTree) {
-	rootPath = strings.TrimSuffix(rootPath, "/")
+	rootPath = trimRootPath(rootPath)
 	var (
 		allHashBytes = []byte{}
 		walkQ        = []FileTree{{BasePath: rootPath}}
 	)
+	resetWalkedDirs()
+	enterDir(rootPath, rootPath)
 
 	numThreads := maxNumThreadsComprehensive
 	if !isComprehensive {
 		numThreads = maxNumThreadsShallow
 	}
 
-	var wg sync.WaitGroup
+	// Threads take jobs from `fileJobs` as they free up, when it's full this
+	// thread has to wait before it can queue any more
+	var (
+		wg       sync.WaitGroup
+		fileJobs = make(chan FileJob, numThreads*jobQueueLenPerThread)
+	)
 	wg.Add(numThreads)
-	fileJobQueues = make([][]FileJob, numThreads)
-	fileJobQueueLocks = make([]sync.Mutex, numThreads)
 	for i := 0; i < numThreads; i++ {
-		fileJobQueues[i] = []FileJob{}
 		go func(n int) {
-			for {
-				if len(fileJobQueues[n]) > 0 {
-					doFileJob(n)
-				} else if !mainDone {
-					time.Sleep(100 * time.Microsecond)
-				} else {
-					break
-				}
+			defer wg.Done()
+			for j := range fileJobs {
+				doFileJob(j, n)
 			}
-			wg.Done()
 		}(i)
 	}
 
@@ -240,6 +206,7 @@ func WalkTreeIterativeFile(rootPath string, depth int, isComprehensive bool, wal
 	}
 	totalFilesFound := 0
 	totalDirs := 0
+	buildQS = []FileTree{}
 	totalBytesRead = 0
 	totalFilesRead = 0
 	totalFilesStated = 0
@@ -256,11 +223,13 @@ func WalkTreeIterativeFile(rootPath string, depth int, isComprehensive bool, wal
 			}
 
 			tempTime := time.Now()
-			pathChildren, err := os.ReadDir(t.BasePath)
+			pathChildren, err := readDir(t.BasePath)
 			readdirTimeTaken += time.Since(tempTime)
 			if err != nil {
-				(t).ErrStrings = append((t).ErrStrings, errorx.Decorate(err, "failed to open `tree.BasePath`").Error())
+				t.Errors = append(t.Errors, newWalkError(t.BasePath, OpReadDir, err))
 				if depth == 0 {
+					close(fileJobs)
+					wg.Wait()
 					return &t
 				}
 			}
@@ -268,6 +237,7 @@ func WalkTreeIterativeFile(rootPath string, depth int, isComprehensive bool, wal
 			(t).Comprehensive = isComprehensive
 			(t).Depth = depth
 			(t).LastVisited = time.Now()
+			setWalkedDirStat(&t)
 
 			buildQSLock.Lock()
 			buildQS = pushBack1D(buildQS, t)
@@ -276,16 +246,22 @@ func WalkTreeIterativeFile(rootPath string, depth int, isComprehensive bool, wal
 
 			childrenFiles := []os.DirEntry{}
 			for _, c := range pathChildren {
-				if c.IsDir() {
-					fullPath := getFullPath(t.BasePath, c.Name())
+				fullPath := getFullPath(t.BasePath, c.Name())
 
+				switch getEntryKind(fullPath, c) {
+				case entryDir:
+					if !enterDir(rootPath, fullPath) {
+						continue
+					}
 					walkQ = pushBack1D(walkQ, FileTree{BasePath: fullPath})
 					totalDirs++
-				} else if c.Type().IsRegular() {
+				case entryFile:
 					childrenFiles = append(childrenFiles, c)
 				}
 			}
 
+			// The threads may be writing hashes to `allHashBytes`, so it's only grown under its lock
+			allHashLock.Lock()
 			var (
 				fileIndex                 = 0
 				lenAllBytesBeforeChildren = len(allHashBytes)
@@ -293,6 +269,7 @@ func WalkTreeIterativeFile(rootPath string, depth int, isComprehensive bool, wal
 			if isComprehensive {
 				allHashBytes = append(allHashBytes, make([]byte, len(childrenFiles)*chosenHash)...)
 			}
+			allHashLock.Unlock()
 			for i, cf := range childrenFiles {
 				fullPath := getFullPath(t.BasePath, cf.Name())
 
@@ -301,11 +278,8 @@ func WalkTreeIterativeFile(rootPath string, depth int, isComprehensive bool, wal
 				buildQS[len(buildQS)-1].Files = append(buildQS[len(buildQS)-1].Files, File{})
 				buildQSLock.Unlock()
 
-				// 2. Pick a thread to assign the task too
-				chosenThread := chooseThreadFile(numThreads, totalFilesFound, isComprehensive)
-
-				// 3. Create job for thread + add it to the queue
-				pushFileJobOnQueue(FileJob{
+				// 2. Create job for the next free thread
+				fileJobs <- FileJob{
 					FullPath:           fullPath,
 					ParentIndexInQueue: thisIndexbuildQS,
 					ThisIndexInFiles:   fileIndex,
@@ -316,7 +290,7 @@ func WalkTreeIterativeFile(rootPath string, depth int, isComprehensive bool, wal
 					WalkStats:  walkStats,
 					HashOffset: lenAllBytesBeforeChildren + i*chosenHash,
 					HashLength: chosenHash,
-				}, chosenThread)
+				}
 
 				fileIndex++
 				totalFilesFound++
@@ -330,7 +304,7 @@ func WalkTreeIterativeFile(rootPath string, depth int, isComprehensive bool, wal
 		depth++
 	}
 
-	mainDone = true
+	close(fileJobs)
 	wg.Wait()
 
 	// var speedStr string
@@ -348,6 +322,8 @@ func WalkTreeIterativeFile(rootPath string, depth int, isComprehensive bool, wal
 	tree := constructTreeFromIterativeQ(&buildQS)
 
 	tree.AllHash = allHashBytes
+	countHardlinksOnce(&tree)
+	recordChunkedHashing(&tree)
 
 	return &tree
 }
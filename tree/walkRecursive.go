@@ -2,9 +2,12 @@ package tree
 
 import (
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/joomcode/errorx"
 	"github.com/pericles-tpt/seye/stats"
 	"github.com/pericles-tpt/seye/utility"
 )
@@ -14,99 +17,151 @@ type ReadLocation struct {
 	HashOffset  int
 	HashLength  int
 	FullPath    string
-	Size        int64
+	Stat        os.FileInfo // The `stat` the file's `File` was populated from
+	Dev         uint64
+	Ino         uint64
 	AllHashByte *[]byte
 }
 
 /*
 Currently the slowest, although still correct, algorithm. Currently singlethreaded.
 
-TODO: Rework for a MT approach, we'd likely need information about the `n`, roughly, equally most expensive, subtrees ahead of time so
-we can split off from the main thread at those point. Then rejoin the subtrees from those threads with the main thread.
+See `WalkTreeRecursiveSplit` for a multithreaded version of this algorithm
 */
 func WalkGenerateTreeRecursive(path string, depth int, isComprehensive bool, walkStats *stats.WalkStats) (tree *FileTree) {
-	AllHashBytes := []byte{}
-	if depth == 0 {
-		filesAddedForHashing = 0
+	filesAddedForHashing = 0
+
+	// This function is ST, these are initialised here for MT properties elsewhere
+	threadsCopyBuffer = [][]byte{make([]byte, copyBufferLen)}
+	threadsBytesRead = make([]int64, 1)
+	threadsFilesRead = make([]int64, 1)
+
+	// Otherwise e.g. a walk of "/x/" has paths like "/x//a", which don't match a walk of "/x"
+	path = filepath.Clean(path)
+	resetWalkedDirs()
+	enterDir(path, path)
+
+	allHashBytes := []byte{}
+	tree = walkRecursive(path, path, depth, isComprehensive, walkStats, &allHashBytes, 0, nil)
+	if len(allHashBytes) > 0 {
+		tree.AllHash = allHashBytes
+		relayoutAllHash(tree)
+	}
+	countHardlinksOnce(tree)
+	recordChunkedHashing(tree)
+
+	return tree
+}
+
+/*
+A multithreaded version of `WalkGenerateTreeRecursive`. Each of the `splitPaths` (see `GetSplitPoints`) is walked
+on its own thread, while the main thread walks the rest of the tree. When the main thread reaches one of the
+`splitPaths`, it waits for that thread's subtree and grafts it onto the tree in its place.
+
+`splitPaths` must not contain each other, otherwise some subtrees would be walked twice
+*/
+func WalkTreeRecursiveSplit(rootPath string, isComprehensive bool, walkStats *stats.WalkStats, splitPaths []string) (tree *FileTree) {
+	filesAddedForHashing = 0
+	rootPath = filepath.Clean(rootPath)
+	cleanSplitPaths := make([]string, len(splitPaths))
+	for i, sp := range splitPaths {
+		cleanSplitPaths[i] = filepath.Clean(sp)
+	}
+	splitPaths = cleanSplitPaths
+
+	numThreads := GetNumThreads(isComprehensive)
+
+	// Thread 0 is the main thread, the rest are for `splitPaths`
+	threadsCopyBuffer = make([][]byte, numThreads+1)
+	for i := range threadsCopyBuffer {
+		threadsCopyBuffer[i] = make([]byte, copyBufferLen)
+	}
+	threadsBytesRead = make([]int64, numThreads+1)
+	threadsFilesRead = make([]int64, numThreads+1)
+
+	var (
+		freeThreads  = make(chan int, numThreads)
+		splitResults = make(map[string]chan *FileTree, len(splitPaths))
+	)
+	for i := 1; i <= numThreads; i++ {
+		freeThreads <- i
+	}
+	resetWalkedDirs()
+	enterDir(rootPath, rootPath)
+	for _, sp := range splitPaths {
+		enterDir(rootPath, sp)
+	}
+	for _, sp := range splitPaths {
+		// Buffered so the thread can exit even if the main thread never reaches `sp`
+		results := make(chan *FileTree, 1)
+		splitResults[sp] = results
+
+		go func(splitPath string, depth int) {
+			threadNum := <-freeThreads
+			defer func() { freeThreads <- threadNum }()
+
+			allHashBytes := []byte{}
+			subTree := walkRecursive(rootPath, splitPath, depth, isComprehensive, walkStats, &allHashBytes, threadNum, nil)
+			subTree.AllHash = allHashBytes
+			results <- subTree
+		}(sp, strings.Count(strings.TrimPrefix(sp, getFullPath(trimRootPath(rootPath), "")), "/")+1)
+	}
+
+	allHashBytes := []byte{}
+	tree = walkRecursive(rootPath, rootPath, 0, isComprehensive, walkStats, &allHashBytes, 0, splitResults)
+	if len(allHashBytes) > 0 {
+		tree.AllHash = allHashBytes
+		relayoutAllHash(tree)
 	}
+	countHardlinksOnce(tree)
+	recordChunkedHashing(tree)
 
+	return tree
+}
+
+/*
+Walks the tree at `path` (below `rootPath`), adding the hashes of its files to `allHashBytes`. If `path` (or one of its subdirectories)
+is a key in `splitResults`, that subtree is taken from the channel rather than walked
+*/
+func walkRecursive(rootPath, path string, depth int, isComprehensive bool, walkStats *stats.WalkStats, allHashBytes *[]byte, threadNum int, splitResults map[string]chan *FileTree) (tree *FileTree) {
 	tree = &FileTree{BasePath: path}
+	setWalkedDirStat(tree)
 
-	ents, err := os.ReadDir(path)
+	ents, err := readDir(path)
 	if err != nil {
-		tree.ErrStrings = append(tree.ErrStrings, errorx.Decorate(err, "failed to open `tree.BasePath`").Error())
+		tree.Errors = append(tree.Errors, newWalkError(path, OpReadDir, err))
 		if depth == 0 {
 			return tree
 		}
 	}
 
-	// This function is ST currently, these are initialised here for MT properties elsewhere
-	threadsCopyBuffer = make([][]byte, 1)
-	threadsBytesRead = make([]int64, 1)
-	threadsFilesRead = make([]int64, 1)
-	threadsCopyBuffer[0] = make([]byte, copyBufferLen)
-
 	var (
 		startWalk = time.Now()
 	)
 	for _, e := range ents {
 		fullPath := getFullPath(path, e.Name())
 
-		if e.IsDir() {
-			if utility.Contains(ignoredDirs, e.Name()) {
+		switch getEntryKind(fullPath, e) {
+		case entryDir:
+			if utility.Contains(ignoredDirs, e.Name()) || !enterDir(rootPath, fullPath) {
 				continue
 			}
 
-			subTree := WalkGenerateTreeRecursive(fullPath, depth+1, isComprehensive, walkStats)
-			if len(subTree.ErrStrings) > 0 {
-				tree.ErrStrings = append(tree.ErrStrings, subTree.ErrStrings...)
+			var subTree *FileTree
+			if results, ok := splitResults[fullPath]; ok {
+				subTree = <-results
+				graftAllHash(subTree, allHashBytes)
+			} else {
+				subTree = walkRecursive(rootPath, fullPath, depth+1, isComprehensive, walkStats, allHashBytes, threadNum, splitResults)
 			}
 			tree.SizeBelow += subTree.SizeBelow
+			tree.AllocatedBelow += subTree.AllocatedBelow
 			tree.NumFilesBelow += subTree.NumFilesBelow
 
 			tree.SubTrees = append(tree.SubTrees, *subTree)
 			tree.LastModifiedBelow = utility.GetNewestTime(tree.LastModifiedBelow, subTree.LastModifiedBelow)
-		} else if e.Type().IsRegular() {
-			fStat, err := e.Info()
-			nf := File{
-				Name: fullPath,
-				Hash: utility.InitialiseHashLocation(),
-				Size: fStat.Size(),
-				// TODO: Err is never populated atm...
-				LastModified: fStat.ModTime(),
-			}
-			if err != nil {
-				tree.ErrStrings = append(tree.ErrStrings, errorx.Decorate(err, "failed to stat file").Error())
-			} else {
-				if isComprehensive {
-					oldAllHashByteLen := len(AllHashBytes)
-					AllHashBytes = append(AllHashBytes, make([]byte, chosenHash)...)
-					var errStrings []string
-					nf.Hash, errStrings = readHashFile(ReadLocation{
-						WalkStats:   walkStats,
-						FullPath:    fullPath,
-						HashOffset:  oldAllHashByteLen,
-						HashLength:  chosenHash,
-						Size:        nf.Size,
-						AllHashByte: &AllHashBytes,
-					}, 0)
-
-					if len(errStrings) > 0 {
-						tree.ErrStrings = append(tree.ErrStrings, errStrings...)
-					}
-				}
-
-				if walkStats != nil {
-					walkStats.UpdateLargestFiles(stats.BasicFile{Path: fullPath, Size: fStat.Size()})
-				}
-
-				tree.LastModifiedDirect = utility.GetNewestTime(tree.LastModifiedDirect, nf.LastModified)
-				tree.LastModifiedBelow = utility.GetNewestTime(tree.LastModifiedBelow, nf.LastModified)
-
-				tree.SizeDirect += nf.Size
-			}
-
-			tree.Files = append(tree.Files, nf)
+		case entryFile:
+			tree.addWalkedFile(walkFile(fullPath, e, isComprehensive, walkStats, allHashBytes, threadNum))
 		}
 	}
 	tree.TimeTaken = time.Since(startWalk)
@@ -117,13 +172,178 @@ func WalkGenerateTreeRecursive(path string, depth int, isComprehensive bool, wal
 	tree.NumFilesDirect = int64(len(tree.Files))
 	tree.NumFilesBelow += tree.NumFilesDirect
 	tree.SizeBelow += tree.SizeDirect
+	tree.AllocatedBelow += tree.AllocatedDirect
 
 	tree.LastVisited = time.Now()
 	tree.Depth = depth
 
-	if tree.Depth == 0 && len(AllHashBytes) > 0 {
-		tree.AllHash = AllHashBytes
+	return tree
+}
+
+/*
+Stats the file at `fullPath`, and hashes it for "comprehensive" walks, adding its hash to `allHashBytes`.
+Returns false if it couldn't be stat'd
+*/
+func walkFile(fullPath string, e os.DirEntry, isComprehensive bool, walkStats *stats.WalkStats, allHashBytes *[]byte, threadNum int) (File, bool) {
+	nf := File{
+		Name: fullPath,
+		Hash: utility.InitialiseHashLocation(),
+	}
+	fStat, err := getFileInfo(fullPath, e, &nf)
+	if err != nil {
+		we := newWalkError(fullPath, OpStat, err)
+		nf.WalkErr = &we
+		return nf, false
 	}
 
-	return tree
+	setFileStat(&nf, fStat)
+	if isComprehensive && isHashable(nf) {
+		oldAllHashByteLen := len(*allHashBytes)
+		*allHashBytes = append(*allHashBytes, make([]byte, chosenHash)...)
+		nf.Hash, fStat, nf.WalkErr = readHashFile(ReadLocation{
+			WalkStats:   walkStats,
+			FullPath:    fullPath,
+			HashOffset:  oldAllHashByteLen,
+			HashLength:  chosenHash,
+			Stat:        fStat,
+			Dev:         nf.Dev,
+			Ino:         nf.Ino,
+			AllHashByte: allHashBytes,
+		}, threadNum)
+		// The file may have changed while being hashed, keep the `stat` the hash matches
+		setFileStat(&nf, fStat)
+	}
+
+	walkLock.Lock()
+	if walkStats != nil {
+		walkStats.UpdateLargestFiles(stats.BasicFile{Path: fullPath, Size: nf.ReportedSize()})
+	}
+	walkLock.Unlock()
+
+	return nf, true
+}
+
+/*
+Adds a file from `walkFile` to the tree, only counting it in the tree's totals if it was stat'd
+*/
+func (tree *FileTree) addWalkedFile(nf File, statted bool) {
+	if statted {
+		tree.LastModifiedDirect = utility.GetNewestTime(tree.LastModifiedDirect, nf.LastModified)
+		tree.LastModifiedBelow = utility.GetNewestTime(tree.LastModifiedBelow, nf.LastModified)
+
+		tree.SizeDirect += nf.Size
+		tree.AllocatedDirect += nf.Allocated
+	}
+	tree.Files = append(tree.Files, nf)
+}
+
+/*
+Appends the `AllHash` of a subtree walked on another thread, to the `AllHash` of
+the tree it's being grafted onto, offsetting the hashes of its files to match
+*/
+func graftAllHash(subTree *FileTree, allHashBytes *[]byte) {
+	offset := len(*allHashBytes)
+	*allHashBytes = append(*allHashBytes, subTree.AllHash...)
+	subTree.AllHash = nil
+
+	var offsetHashes func(t *FileTree)
+	offsetHashes = func(t *FileTree) {
+		for i := range t.Files {
+			if t.Files[i].Hash.HashOffset > -1 {
+				t.Files[i].Hash.HashOffset += offset
+			}
+		}
+		for i := range t.SubTrees {
+			offsetHashes(&t.SubTrees[i])
+		}
+	}
+	offsetHashes(subTree)
+}
+
+/*
+Picks up to `n` subtrees of `previous` (the last scan of a tree) to split a `WalkTreeRecursiveSplit` at, such that
+they're the most, and roughly equally, expensive to walk.
+
+Starting with the subtrees of the root, the most expensive subtree is repeatedly replaced by its own subtrees while
+it costs more than 1/n of the whole tree. The cost of a subtree is its `SizeBelow` for "comprehensive" scans (i.e.
+bytes to read and hash) and its `NumFilesBelow` for "shallow" scans (i.e. files to `stat`).
+*/
+func GetSplitPoints(previous *FileTree, n int, isComprehensive bool) []string {
+	if previous == nil || n < 1 {
+		return []string{}
+	}
+
+	cost := func(t *FileTree) int64 {
+		if isComprehensive {
+			return t.SizeBelow
+		}
+		return t.NumFilesBelow
+	}
+	byCost := func(candidates []*FileTree) {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return cost(candidates[i]) > cost(candidates[j])
+		})
+	}
+
+	var (
+		threshold  = cost(previous) / int64(n)
+		candidates = []*FileTree{}
+	)
+	for i := range previous.SubTrees {
+		candidates = append(candidates, &previous.SubTrees[i])
+	}
+	byCost(candidates)
+
+	for len(candidates) > 0 {
+		expanded := false
+		for i, c := range candidates {
+			if cost(c) <= threshold {
+				break
+			}
+			if len(c.SubTrees) == 0 {
+				continue
+			}
+
+			candidates = append(candidates[:i], candidates[i+1:]...)
+			for j := range c.SubTrees {
+				candidates = append(candidates, &c.SubTrees[j])
+			}
+			byCost(candidates)
+			expanded = true
+			break
+		}
+		if !expanded {
+			break
+		}
+	}
+
+	splitPoints := []string{}
+	for i := 0; i < n && i < len(candidates); i++ {
+		if cost(candidates[i]) == 0 || utility.Contains(ignoredDirs, path.Base(candidates[i].BasePath)) {
+			continue
+		}
+		splitPoints = append(splitPoints, candidates[i].BasePath)
+	}
+
+	return splitPoints
+}
+
+/*
+Used in place of `GetSplitPoints` when there's no previous scan of a tree, simply
+splits at each directory directly below the root
+*/
+func GetTopLevelSplitPoints(rootPath string) []string {
+	splitPoints := []string{}
+
+	ents, err := os.ReadDir(rootPath)
+	if err != nil {
+		return splitPoints
+	}
+	for _, e := range ents {
+		if e.IsDir() && !utility.Contains(ignoredDirs, e.Name()) {
+			splitPoints = append(splitPoints, getFullPath(rootPath, e.Name()))
+		}
+	}
+
+	return splitPoints
 }
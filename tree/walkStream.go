@@ -0,0 +1,216 @@
+package tree
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/joomcode/errorx"
+	"github.com/pericles-tpt/seye/stats"
+	"github.com/pericles-tpt/seye/utility"
+)
+
+/*
+The state of a `WalkWriteTree`, shared by each directory walked
+*/
+type streamWalk struct {
+	tw              *TreeWriter
+	rootPath        string
+	isComprehensive bool
+	walkStats       *stats.WalkStats
+
+	// The inodes of the hardlinks seen so far, see `countHardlinksOnce`
+	seenLinks     map[DevIno]struct{}
+	numDirs       int64
+	numUnstable   int
+	allHashLength int
+}
+
+/*
+Walks the tree at `rootPath` like `WalkGenerateTreeRecursive`, but writes each directory to the `.tree`
+file at `path` (and its index) as soon as its files have been walked, so the whole tree is never in
+memory. Returns the root of the tree, without its files or subtrees, and the number of files that
+changed while being hashed (see `CountUnstableFiles`). Currently singlethreaded.
+
+The header of a tree summarises all of it, so the directories are written to a temporary file first,
+then copied after the header once the walk is done
+*/
+func WalkWriteTree(rootPath string, isComprehensive bool, walkStats *stats.WalkStats, path string) (FileTree, int, error) {
+	filesAddedForHashing = 0
+
+	// This function is ST, these are initialised here for MT properties elsewhere
+	threadsCopyBuffer = [][]byte{make([]byte, copyBufferLen)}
+	threadsBytesRead = make([]int64, 1)
+	threadsFilesRead = make([]int64, 1)
+
+	rootPath = filepath.Clean(rootPath)
+	resetWalkedDirs()
+	enterDir(rootPath, rootPath)
+
+	if err := os.Remove(IndexPath(path)); err != nil && !os.IsNotExist(err) {
+		return FileTree{}, 0, errorx.Decorate(err, "failed to remove old index of FileTree data")
+	}
+	body, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".body*")
+	if err != nil {
+		return FileTree{}, 0, errorx.Decorate(err, "failed to create temporary file for FileTree data")
+	}
+	defer os.Remove(body.Name())
+	defer body.Close()
+
+	sw := &streamWalk{
+		tw:              NewTreeWriter(body, isComprehensive),
+		rootPath:        rootPath,
+		isComprehensive: isComprehensive,
+		walkStats:       walkStats,
+		seenLinks:       map[DevIno]struct{}{},
+	}
+	root, err := sw.walk(rootPath, 0)
+	if err == nil {
+		err = sw.tw.Finish(sw.allHashLength)
+	}
+	if err != nil {
+		return root, sw.numUnstable, errorx.Decorate(err, "failed to write FileTree data")
+	}
+	recordChunkedHashing(&root)
+
+	h := root.NewHeader()
+	h.NumDirs = sw.numDirs
+	var headerLen int64
+	err = utility.WriteFileAtomic(path, 0600, func(w io.Writer) error {
+		cw := &countingWriter{w: w}
+		hw, err := utility.NewCompressedWriter(cw)
+		if err != nil {
+			return errorx.Decorate(err, "failed to create compressor for FileTree data")
+		}
+		if _, err = utility.WriteFileHeader(hw, h); err != nil {
+			return errorx.Decorate(err, "failed to write FileTree header")
+		}
+		if err = hw.Close(); err != nil {
+			return err
+		}
+		headerLen = cw.n
+
+		// The blocks of the tree are compressed on their own, so they're copied as they are
+		if _, err = body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err = io.Copy(w, body)
+		return err
+	})
+	if err != nil {
+		return root, sw.numUnstable, errorx.Decorate(err, "failed to write FileTree data")
+	}
+
+	// The offsets of the blocks were from the start of the temporary file
+	entries := sw.tw.index
+	for i := range entries {
+		entries[i].block += headerLen
+	}
+	err = writeIndex(IndexPath(path), isComprehensive, sw.tw.readAllHashLength(sw.allHashLength), entries)
+	if err != nil {
+		return root, sw.numUnstable, errorx.Decorate(err, "failed to write index of FileTree data")
+	}
+	return root, sw.numUnstable, nil
+}
+
+/*
+Walks the directory `path`, writing it once its files have been walked, then its subtrees, then
+closing it. Returns the directory without its files or subtrees
+*/
+func (sw *streamWalk) walk(path string, depth int) (FileTree, error) {
+	tree := FileTree{BasePath: path}
+	setWalkedDirStat(&tree)
+	sw.numDirs++
+
+	ents, err := readDir(path)
+	if err != nil {
+		tree.Errors = append(tree.Errors, newWalkError(path, OpReadDir, err))
+		if depth == 0 {
+			if err = sw.tw.Open(&tree, nil); err != nil {
+				return tree, err
+			}
+			return tree, sw.tw.Close(&tree)
+		}
+	}
+
+	var (
+		startWalk = time.Now()
+		allHash   = []byte{}
+		subDirs   = []string{}
+	)
+	for _, e := range ents {
+		fullPath := getFullPath(path, e.Name())
+
+		switch getEntryKind(fullPath, e) {
+		case entryDir:
+			if !utility.Contains(ignoredDirs, e.Name()) {
+				subDirs = append(subDirs, fullPath)
+			}
+		case entryFile:
+			tree.addWalkedFile(walkFile(fullPath, e, sw.isComprehensive, sw.walkStats, &allHash, 0))
+		}
+	}
+	for _, f := range tree.Files {
+		if f.WalkErr != nil && f.WalkErr.Class == ClassUnstable {
+			sw.numUnstable++
+		}
+	}
+	tree.Comprehensive = sw.isComprehensive
+	tree.Depth = depth
+	tree.NumFilesDirect = int64(len(tree.Files))
+	sw.countHardlinksOnceDirect(&tree)
+
+	if err = sw.tw.Open(&tree, allHash); err != nil {
+		return tree, err
+	}
+	sw.allHashLength += len(allHash)
+	tree.Files = nil
+
+	for _, subDir := range subDirs {
+		// Entered as each is reached, like `walkRecursive`, so the same directory is skipped
+		if !enterDir(sw.rootPath, subDir) {
+			continue
+		}
+		subTree, err := sw.walk(subDir, depth+1)
+		if err != nil {
+			return tree, err
+		}
+		tree.SizeBelow += subTree.SizeBelow
+		tree.AllocatedBelow += subTree.AllocatedBelow
+		tree.NumFilesBelow += subTree.NumFilesBelow
+		tree.LastModifiedBelow = utility.GetNewestTime(tree.LastModifiedBelow, subTree.LastModifiedBelow)
+	}
+	tree.TimeTaken = time.Since(startWalk)
+
+	tree.NumFilesBelow += tree.NumFilesDirect
+	tree.SizeBelow += tree.SizeDirect
+	tree.AllocatedBelow += tree.AllocatedDirect
+
+	tree.LastVisited = time.Now()
+
+	return tree, sw.tw.Close(&tree)
+}
+
+/*
+Like `countHardlinksOnce`, but for just the files of `t`, since its subtrees haven't been walked yet.
+Directories are walked in the same order, so the same link is counted
+*/
+func (sw *streamWalk) countHardlinksOnceDirect(t *FileTree) {
+	if countLinks {
+		return
+	}
+	for _, f := range t.Files {
+		if f.Nlink < 2 {
+			continue
+		}
+
+		id := DevIno{Dev: f.Dev, Ino: f.Ino}
+		if _, ok := sw.seenLinks[id]; ok {
+			t.SizeDirect -= f.Size
+			t.AllocatedDirect -= f.Allocated
+		} else {
+			sw.seenLinks[id] = struct{}{}
+		}
+	}
+}
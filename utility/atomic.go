@@ -0,0 +1,68 @@
+package utility
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/*
+Writes the file at `path` with `write`, so that after a crash it's either as it was or completely
+replaced, never partially written. `write` writes to a temporary file in the same directory, which
+is synced then renamed over `path`, then the directory is synced so the rename itself is durable
+*/
+func WriteFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := os.CreateTemp(dir, "."+base+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	ok := false
+	defer func() {
+		if !ok {
+			f.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	if err = write(bw); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	ok = true
+
+	return SyncDir(dir)
+}
+
+/*
+Flushes the entries of the directory `dir` (e.g. files renamed in it) to disk
+*/
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,77 @@
+package utility
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+var (
+	compressionLevel = gzip.DefaultCompression
+)
+
+/*
+Sets the `gzip` level that scan files are written with, from `gzip.BestSpeed` to
+`gzip.BestCompression`. `gzip.NoCompression` writes them uncompressed
+*/
+func SetCompressionLevel(level int) {
+	compressionLevel = level
+}
+
+func GetCompressionLevel() int {
+	return compressionLevel
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+/*
+Wraps `w` so writes to it are compressed at the `compressionLevel`, the returned writer must be
+closed to flush the compressed data
+*/
+func NewCompressedWriter(w io.Writer) (io.WriteCloser, error) {
+	if compressionLevel == gzip.NoCompression {
+		return nopWriteCloser{w}, nil
+	}
+	return gzip.NewWriterLevel(w, compressionLevel)
+}
+
+/*
+Returns a reader of the decompressed contents of `r`, if they're compressed (i.e. start with the
+`gzip` magic bytes), otherwise `r` itself
+*/
+func NewDecompressedReader(r *bufio.Reader) (*bufio.Reader, error) {
+	magic, err := r.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return r, nil
+	}
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(gr), nil
+}
+
+/*
+Gets the size of the file at `path` once decompressed (the same as its size if it's uncompressed)
+*/
+func GetUncompressedSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r, err := NewDecompressedReader(bufio.NewReader(f))
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(io.Discard, r)
+}
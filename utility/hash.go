@@ -10,6 +10,9 @@ type HashType int
 
 const (
 	SHA256 HashType = iota
+	// The SHA256 of the concatenated SHA256s of each fixed size chunk of a file, so the
+	// chunks can be hashed in parallel. Only used for large files, see `tree.SetChunkedHashing`
+	SHA256Chunked
 	// TODO: MD5? Smaller, faster, less secure...
 )
 
@@ -70,7 +73,7 @@ func AddHashAtOffset(offset int, length int, hashType HashType, hashBytes []byte
 Copies a hash from an old *[]byte to a new *[]byte
 */
 func CopyHashToNewArray(addFromLocation HashLocation, fromAllHash, toAllHash *[]byte) HashLocation {
-	newHashOffset := len(*toAllHash) - 1
+	newHashOffset := len(*toAllHash)
 	*toAllHash = append(*toAllHash, (*fromAllHash)[addFromLocation.HashOffset:addFromLocation.HashOffset+addFromLocation.HashLength]...)
 	return HashLocation{
 		HashOffset: newHashOffset,
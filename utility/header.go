@@ -0,0 +1,143 @@
+package utility
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+/*
+The header at the start of every scan file (after decompression), so a file can be identified, and
+its scan summarised, without decoding the rest of it:
+
+	"SEYE" | header version | len(header) | header, as JSON
+
+Files written by older versions of seye don't have a header, see `seye migrate`
+*/
+const (
+	headerMagic   = "SEYE"
+	headerVersion = 1
+
+	// Longer headers than this are treated as corrupt
+	maxHeaderLen = 1 << 16
+
+	FileKindTree = "tree"
+	FileKindDiff = "diff"
+)
+
+/*
+The version of seye, set at build time with `-ldflags "-X github.com/pericles-tpt/seye/utility.SeyeVersion=..."`
+*/
+var SeyeVersion = "dev"
+
+type FileHeader struct {
+	Kind          string // `FileKindTree` or `FileKindDiff`
+	FormatVersion int    // The version of the format of the rest of the file, for its `Kind`
+	SeyeVersion   string
+	HashAlgorithm string
+	RootPath      string
+	ScanTime      time.Time
+	Comprehensive bool
+
+	// The chunked hashing the scan's files were hashed with, 0 for files written before they were
+	// recorded (which used the defaults)
+	HashChunkThreshold int64
+	HashChunkSize      int64
+
+	// Summary totals of the scan, for a diff these are of the newer scan (and are 0 for diffs
+	// migrated from older versions, since the newer scan may no longer be stored)
+	Size      int64
+	Allocated int64
+	NumFiles  int64
+	NumDirs   int64
+}
+
+/*
+Writes `h` to `w`, returning the number of bytes written
+*/
+func WriteFileHeader(w io.Writer, h FileHeader) (int64, error) {
+	hb, err := json.Marshal(h)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := []byte(headerMagic)
+	buf = binary.AppendUvarint(buf, headerVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(hb)))
+	buf = append(buf, hb...)
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+/*
+Reads the header from the start of `r`, returning nil if it doesn't have one (i.e. it was written by
+an older version of seye). Also returns the number of bytes read
+*/
+func ReadFileHeader(r *bufio.Reader) (*FileHeader, int64, error) {
+	magic, err := r.Peek(len(headerMagic))
+	if err != nil || string(magic) != headerMagic {
+		return nil, 0, nil
+	}
+
+	cr := &countingByteReader{r: r}
+	if _, err = cr.Read(make([]byte, len(headerMagic))); err != nil {
+		return nil, cr.n, err
+	}
+	version, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return nil, cr.n, err
+	} else if version != headerVersion {
+		return nil, cr.n, fmt.Errorf("unsupported file header version %d, this version of seye reads version %d", version, headerVersion)
+	}
+	hLen, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return nil, cr.n, err
+	} else if hLen > maxHeaderLen {
+		return nil, cr.n, fmt.Errorf("invalid file header length %d, the file may be corrupt", hLen)
+	}
+
+	hb := make([]byte, hLen)
+	if _, err = io.ReadFull(cr, hb); err != nil {
+		return nil, cr.n, err
+	}
+	var h FileHeader
+	if err = json.Unmarshal(hb, &h); err != nil {
+		return nil, cr.n, fmt.Errorf("invalid file header, the file may be corrupt: %v", err)
+	}
+	return &h, cr.n, nil
+}
+
+/*
+Checks the file is of `kind` and in a format version this version of seye can read, i.e. at most
+`maxVersion`
+*/
+func (h *FileHeader) Check(kind string, maxVersion int) error {
+	if h.Kind != kind {
+		return fmt.Errorf("expected a %s file, found a %s file", kind, h.Kind)
+	} else if h.FormatVersion < 1 || h.FormatVersion > maxVersion {
+		return fmt.Errorf("unsupported %s format version %d (written by seye %s), this version of seye (%s) reads versions 1 to %d", kind, h.FormatVersion, h.SeyeVersion, SeyeVersion, maxVersion)
+	}
+	return nil
+}
+
+type countingByteReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingByteReader) Read(p []byte) (int, error) {
+	n, err := io.ReadFull(c.r, p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingByteReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
@@ -0,0 +1,66 @@
+package utility
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/joomcode/errorx"
+)
+
+/*
+Gets the names of users by their uid, from `/etc/passwd`
+*/
+func GetUserNames() (map[uint32]string, error) {
+	return readIdNames("/etc/passwd")
+}
+
+/*
+Gets the names of groups by their gid, from `/etc/group`
+*/
+func GetGroupNames() (map[uint32]string, error) {
+	return readIdNames("/etc/group")
+}
+
+func readIdNames(path string) (map[uint32]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errorx.Decorate(err, "failed to open '%s' to read names", path)
+	}
+	defer f.Close()
+
+	return parseIdNames(f)
+}
+
+/*
+Both `/etc/passwd` and `/etc/group` have the name in the first field and the id
+in the third, e.g. "root:x:0:0:root:/root:/bin/bash" or "wheel:x:10:alice"
+*/
+func parseIdNames(r io.Reader) (map[uint32]string, error) {
+	names := map[uint32]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		if _, ok := names[uint32(id)]; !ok {
+			names[uint32(id)] = fields[0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errorx.Decorate(err, "failed to read names")
+	}
+	return names, nil
+}
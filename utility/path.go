@@ -0,0 +1,50 @@
+package utility
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+The canonical form of the path `p`, i.e. absolute, clean and with symlinks resolved, so the same
+directory always has the same path. If `p` doesn't exist (e.g. it's been removed since it was
+scanned), the part of it that does is resolved
+*/
+func CanonicalPath(p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err == nil {
+		return resolved, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	parent := filepath.Dir(abs)
+	if parent == abs {
+		return abs, nil
+	}
+	resolvedParent, err := CanonicalPath(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolvedParent, filepath.Base(abs)), nil
+}
+
+/*
+Moves the path `p` from under `oldRoot` to under `newRoot`, paths that aren't `oldRoot` or under
+it are returned unchanged
+*/
+func RebasePath(p, oldRoot, newRoot string) string {
+	if p == oldRoot {
+		return newRoot
+	}
+	rel, ok := strings.CutPrefix(p, strings.TrimSuffix(oldRoot, "/")+"/")
+	if !ok {
+		return p
+	}
+	return strings.TrimSuffix(newRoot, "/") + "/" + rel
+}
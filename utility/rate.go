@@ -0,0 +1,53 @@
+package utility
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var byteUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+}
+
+/*
+Parses a rate of bytes per second, e.g. "50MB/s", "1.5GiB/S" or "4096", into bytes per second
+*/
+func ParseByteRate(s string) (int64, error) {
+	rate := strings.TrimSpace(s)
+	if strings.HasSuffix(strings.ToLower(rate), "/s") {
+		rate = rate[:len(rate)-len("/s")]
+	}
+	return ParseByteSize(rate)
+}
+
+/*
+Parses a number of bytes, e.g. "50MB", "1.5GiB" or "4096"
+*/
+func ParseByteSize(s string) (int64, error) {
+	size := strings.ToUpper(strings.TrimSpace(s))
+	unitStart := strings.IndexFunc(size, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if unitStart < 0 {
+		unitStart = len(size)
+	}
+
+	multiplier, ok := byteUnits[size[unitStart:]]
+	if !ok {
+		return 0, fmt.Errorf("invalid unit in '%s', must be one of: B, KB, MB, GB, KiB, MiB, GiB", s)
+	}
+	n, err := strconv.ParseFloat(size[:unitStart], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number of bytes '%s'", s)
+	}
+
+	return int64(n * multiplier), nil
+}